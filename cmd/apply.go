@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+var (
+	ApplyConsole  bool
+	ApplyOutput   string
+	ApplyPassword string
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply <recipe.yaml> <file>",
+	Short: "Applies a recipe of multiple operations to a settings export",
+	Long: `Applies a recipe of operations to a settings export in a single pass.
+The export is decrypted once, all operations are run in memory, and the result is re-encrypted once.
+
+A recipe is a YAML file with a list of steps, each containing exactly one operation:
+
+steps:
+  - set:                    # set preferences
+      language: en
+  - unset: [key_a, key_b]   # remove preferences
+  - rename:                 # rename preferences, all renames of a step happen at once
+      old_key: new_key
+  - objectives:             # mark objectives as completed or reset them
+      complete: [1, 2, 3]
+      reset: [4]
+  - metadata:               # edit the export metadata
+      device_name: Clinic phone
+  - format: string          # store preferences as a 'string' or 'object'
+  - encryption: encrypted   # write the export 'encrypted' or 'decrypted'
+
+Examples:
+aaps-export-tool apply recipe.yaml export.json
+aaps-export-tool apply recipe.yaml export.json --dry-run
+aaps-export-tool apply recipe.yaml export.json --out "export-applied.json"`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recipeData, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		recipe, err := util.ParseRecipe(recipeData)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid recipe: %s", err))
+		}
+
+		data, err := readInput(args[1])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		passwordFunc := func() (string, error) {
+			return getPassword(ApplyPassword)
+		}
+
+		export, err := util.LoadExport(data, passwordFunc)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		// the input and output share a password, unless a step changes the encryption
+		password := export.Password
+
+		err = recipe.Apply(export, passwordFunc)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		outputData, err := export.Bytes()
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		if core.DryRun {
+			if export.Password != "" {
+				password = export.Password
+			}
+			reportDryRun(data, outputData, password)
+		}
+
+		path := outputPath(args[1], ApplyOutput, "_applied")
+		wroteFile, err := writeOutput(outputData, path, ApplyConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Applied %d step(s) and wrote to \"%s\" successfully\n", len(recipe.Steps), absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	applyCmd.Flags().StringVarP(&ApplyPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	applyCmd.Flags().BoolVarP(&ApplyConsole, "console", "c", false, "Write export to stdout")
+	applyCmd.Flags().StringVarP(&ApplyOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_applied' before file extension)")
+	applyCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/pretty"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	AutomationPassword string
+	AutomationConsole  bool
+	AutomationOutput   string
+	AutomationReplace  bool
+	AutomationDisabled bool
+	AutomationExpand   bool
+)
+
+// automationCmd represents the automation command
+var automationCmd = &cobra.Command{
+	Use:   "automation",
+	Short: "View and edit the automation rules in a settings export",
+	Long: `View and edit the rules of the automation plugin, which AAPS stores as a JSON array inside the
+AUTOMATION_EVENTS preference, with their triggers and actions serialized into strings once more.
+
+Rules are numbered from 1 in the order AAPS shows them, as printed by 'automation list'. Rules exported with
+'automation export' can be imported into another export, e.g. to share vetted rules.
+
+Examples:
+aaps-export-tool automation list export.json
+aaps-export-tool automation show export.json 2
+aaps-export-tool automation disable export.json 2
+aaps-export-tool automation export export.json 2 > rule.json
+aaps-export-tool automation import rule.json other-export.json --disabled`,
+}
+
+var automationListCmd = &cobra.Command{
+	Use:   "list <file>",
+	Short: "Lists the automation rules",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, events := loadAutomationEvents(args[0])
+		if len(events) == 0 {
+			fmt.Println("The export has no automation rules")
+			return
+		}
+		for i, event := range events {
+			state := "[x]"
+			if !event.Enabled {
+				state = "[ ]"
+			}
+			fmt.Printf("%3d %s %s\n", i+1, state, event.Title)
+		}
+	},
+}
+
+var automationShowCmd = &cobra.Command{
+	Use:   "show <file> [n]",
+	Short: "Shows the triggers and actions of automation rules",
+	Long:  `Shows the triggers and actions of an automation rule, or of all rules if no number is given.`,
+	Args:  cobra.MatchAll(cobra.RangeArgs(1, 2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, events := loadAutomationEvents(args[0])
+		if len(args) == 2 {
+			events = events[automationIndex(events, args[1]) : automationIndex(events, args[1])+1]
+		}
+		for i, event := range events {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(event.Describe())
+		}
+	},
+}
+
+var automationEnableCmd = &cobra.Command{
+	Use:   "enable <file> <n>",
+	Short: "Enables an automation rule",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		setAutomationEnabled(args, true)
+	},
+}
+
+var automationDisableCmd = &cobra.Command{
+	Use:   "disable <file> <n>",
+	Short: "Disables an automation rule",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		setAutomationEnabled(args, false)
+	},
+}
+
+var automationDeleteCmd = &cobra.Command{
+	Use:   "delete <file> <n>",
+	Short: "Deletes an automation rule",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data, events := loadAutomationEvents(args[0])
+		i := automationIndex(events, args[1])
+		title := events[i].Title
+
+		events = append(events[:i], events[i+1:]...)
+		export.SetPreferences(util.SetAutomationEvents(export.Preferences(), events))
+		writeExport(export, data, args[0], AutomationOutput, AutomationConsole, "_automation", fmt.Sprintf("Deleted automation \"%s\"", title))
+	},
+}
+
+var automationExportCmd = &cobra.Command{
+	Use:   "export <file> [n]",
+	Short: "Exports automation rules to a rule file",
+	Long: `Exports an automation rule as JSON, or all rules as a JSON array if no number is given. The rule file can be
+imported with 'automation import'. With --expand, triggers and actions are written as objects instead of strings,
+which is easier to read and edit, and is accepted by 'automation import' as well.`,
+	Args: cobra.MatchAll(cobra.RangeArgs(1, 2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, events := loadAutomationEvents(args[0])
+		if len(args) == 2 {
+			events = events[automationIndex(events, args[1]) : automationIndex(events, args[1])+1]
+		}
+
+		var rules []string
+		for i := range events {
+			rule := events[i].Raw
+			if AutomationExpand {
+				rule = string(events[i].Expanded())
+			}
+			rules = append(rules, rule)
+		}
+
+		outputData := []byte("[" + strings.Join(rules, ",") + "]")
+		if len(args) == 2 {
+			outputData = []byte(rules[0])
+		}
+		outputData = pretty.Pretty(outputData)
+
+		if core.DryRun {
+			for i := range events {
+				fmt.Println(events[i].Title)
+			}
+			fmt.Printf("Would export %d automation(s)\n", len(events))
+		}
+		wroteFile, err := writeOutput(outputData, AutomationOutput, false)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(AutomationOutput)
+			fmt.Printf("Exported %d automation(s) to \"%s\" successfully\n", len(events), absolutePath)
+		}
+	},
+}
+
+var automationImportCmd = &cobra.Command{
+	Use:   "import <rule.json> <file>",
+	Short: "Imports automation rules from a rule file",
+	Long: `Imports the automation rules of a rule file, as written by 'automation export', after the existing rules.
+Imported rules must have a title that isn't used yet, unless --replace is given, which replaces the rule with the same
+title. With --disabled, the rules are imported disabled, so they can be reviewed in AAPS before they run.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	Run: func(cmd *cobra.Command, args []string) {
+		ruleData, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		imported, err := util.ParseAutomationEvents(ruleData)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid automation rule: %s", err))
+		}
+
+		export, data, events := loadAutomationEvents(args[1])
+		for _, event := range imported {
+			if AutomationDisabled {
+				event.SetEnabled(false)
+			}
+			if i := util.FindAutomationEvent(events, event.Title); i >= 0 {
+				if !AutomationReplace {
+					exitWithError(fmt.Sprintf("Automation \"%s\" already exists, use --replace to replace it", event.Title))
+				}
+				events[i] = event
+			} else {
+				events = append(events, event)
+			}
+		}
+
+		export.SetPreferences(util.SetAutomationEvents(export.Preferences(), events))
+		writeExport(export, data, args[1], AutomationOutput, AutomationConsole, "_automation", fmt.Sprintf("Imported %d automation(s)", len(imported)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(automationCmd)
+	automationCmd.AddCommand(automationListCmd, automationShowCmd, automationEnableCmd, automationDisableCmd,
+		automationDeleteCmd, automationExportCmd, automationImportCmd)
+
+	automationCmd.PersistentFlags().StringVarP(&AutomationPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	for _, c := range []*cobra.Command{automationEnableCmd, automationDisableCmd, automationDeleteCmd, automationImportCmd} {
+		c.Flags().BoolVarP(&AutomationConsole, "console", "c", false, "Write export to stdout")
+		c.Flags().StringVarP(&AutomationOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_automation' before file extension)")
+		c.MarkFlagsMutuallyExclusive("console", "out")
+	}
+
+	automationExportCmd.Flags().StringVarP(&AutomationOutput, "out", "o", "", "Write the rules to the specified file (default: stdout)")
+	automationExportCmd.Flags().BoolVar(&AutomationExpand, "expand", false, "Write triggers and actions as objects instead of strings")
+
+	automationImportCmd.Flags().BoolVar(&AutomationReplace, "replace", false, "Replace existing rules with the same title")
+	automationImportCmd.Flags().BoolVar(&AutomationDisabled, "disabled", false, "Import the rules disabled")
+}
+
+func setAutomationEnabled(args []string, enabled bool) {
+	export, data, events := loadAutomationEvents(args[0])
+	i := automationIndex(events, args[1])
+	events[i].SetEnabled(enabled)
+
+	status := "Enabled"
+	if !enabled {
+		status = "Disabled"
+	}
+	export.SetPreferences(util.SetAutomationEvents(export.Preferences(), events))
+	writeExport(export, data, args[0], AutomationOutput, AutomationConsole, "_automation", fmt.Sprintf("%s automation \"%s\"", status, events[i].Title))
+}
+
+func loadAutomationEvents(path string) (*util.Export, []byte, []util.AutomationEvent) {
+	export, data := loadExport(path, AutomationPassword)
+
+	events, err := util.AutomationEvents(export.Preferences())
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	return export, data, events
+}
+
+// automationIndex parses the number of a rule as shown by 'automation list' into an index of events
+func automationIndex(events []util.AutomationEvent, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(events) {
+		exitWithError(fmt.Sprintf("Invalid automation number \"%s\", the export has %d automation(s)", arg, len(events)))
+	}
+	return n - 1
+}
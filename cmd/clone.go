@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ClonePassword        string
+	CloneNewPassword     string
+	CloneDeviceName      string
+	CloneDriversConfig   string
+	CloneResetObjectives bool
+	CloneConsole         bool
+	CloneOutput          string
+)
+
+// cloneCmd represents the clone command
+var cloneCmd = &cobra.Command{
+	Use:   "clone <file> --device-name <name>",
+	Short: "Prepares an export for another phone by removing device-bound preferences",
+	Long: `Prepares a settings export to be imported on another phone. Preferences which are bound to the devices the
+original phone is paired with, such as pump pairing keys, Bluetooth addresses and pod or patch state, are removed, so
+the new phone can't take over the pump of the original phone. The drivers have to be set up again on the new phone.
+
+The device name in the metadata is replaced, objectives can be reset with --reset-objectives, and a report of all
+changes is printed. The clone is always encrypted, with a new password which is asked for unless --new-password is
+given.
+
+The device-bound preferences of each driver are listed below. The list can be extended, and new drivers added, with a
+YAML file given by --drivers-config:
+
+drivers:
+  - name: mypump
+    description: My pump pairing
+    keys: [mypump_address, mypump_pairing_*]
+
+Built-in drivers:
+` + driversHelp() + `
+Examples:
+aaps-export-tool clone export.json --device-name "Backup phone"
+aaps-export-tool clone export.json --device-name "Backup phone" --reset-objectives --out backup.json`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		drivers := util.DeviceBoundDrivers
+		if CloneDriversConfig != "" {
+			config, err := readInput(CloneDriversConfig)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			drivers, err = util.LoadDeviceBoundDrivers(config)
+			if err != nil {
+				exitWithError(fmt.Sprintf("Invalid drivers config: %s", err))
+			}
+		}
+
+		export, _ := loadExport(args[0], ClonePassword)
+		original := export.Data
+
+		prefs, stripped := util.StripDeviceBoundPreferences(export.Preferences(), drivers)
+
+		var objectiveChanges []util.Change
+		if CloneResetObjectives {
+			reset := prefs
+			for i := range util.Objectives {
+				reset = util.Objectives[i].Reset(reset)
+			}
+			objectiveChanges = util.DiffObjects(prefs, reset)
+			prefs = reset
+		}
+
+		export.SetPreferences(prefs)
+		oldDeviceName := gjson.GetBytes(export.Data, "metadata.device_name").String()
+		export.Data, _ = sjson.SetBytes(export.Data, "metadata.device_name", CloneDeviceName)
+
+		if core.DryRun {
+			reportDryRun(util.ConvertPreferencesToString(original), util.ConvertPreferencesToString(export.Data), "")
+		}
+
+		password := CloneNewPassword
+		if password == "" {
+			if readStdin {
+				exitWithError("The new password can't be prompted for when reading from stdin, use --new-password")
+			}
+			var err error
+			password, err = displayNewPasswordPrompt("Enter a password for the clone:")
+			if err != nil {
+				exitWithError(err.Error())
+			}
+		}
+		if export.Encrypted && password == export.Password {
+			fmt.Fprintln(os.Stderr, "warning: the new password is the same as the password of the original export")
+		}
+
+		outputData, err := util.EncryptExport(export.Data, password)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		path := outputPath(args[0], CloneOutput, "_clone")
+		wroteFile, err := writeOutput(outputData, path, CloneConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		// the report goes to stderr when stdout is carrying the export
+		var report io.Writer = os.Stdout
+		if !wroteFile {
+			report = os.Stderr
+		}
+
+		for _, change := range stripped {
+			fmt.Fprintf(report, "Removed %s = %q (%s)\n", change.Key, change.OldValue, change.Driver)
+		}
+		for _, change := range objectiveChanges {
+			fmt.Fprintf(report, "Reset %s: %q -> %q\n", change.Key, change.OldValue, change.NewValue)
+		}
+		fmt.Fprintf(report, "Set metadata.device_name: %q -> %q\n", oldDeviceName, CloneDeviceName)
+		fmt.Fprintf(report, "Removed %d device-bound preference(s)", len(stripped))
+		if CloneResetObjectives {
+			fmt.Fprintf(report, " and reset %d objective preference(s)", len(objectiveChanges))
+		}
+		fmt.Fprintln(report)
+
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Encrypted clone was written to \"%s\"\n", absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+
+	cloneCmd.Flags().StringVar(&CloneDeviceName, "device-name", "", "Device name of the new phone, written to the metadata")
+	cloneCmd.MarkFlagRequired("device-name")
+	cloneCmd.Flags().BoolVar(&CloneResetObjectives, "reset-objectives", false, "Reset the progress of all objectives")
+	cloneCmd.Flags().StringVar(&CloneDriversConfig, "drivers-config", "", "YAML file with additional device-bound preferences")
+
+	cloneCmd.Flags().StringVarP(&ClonePassword, "password", "p", "", "Manually specify encryption password of the original export (only use if necessary, like in shell scripts)")
+	cloneCmd.Flags().StringVar(&CloneNewPassword, "new-password", "", "Manually specify the password to encrypt the clone with (only use if necessary, like in shell scripts)")
+
+	cloneCmd.Flags().BoolVarP(&CloneConsole, "console", "c", false, "Write export to stdout")
+	cloneCmd.Flags().StringVarP(&CloneOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_clone' before file extension)")
+	cloneCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
+
+func driversHelp() string {
+	var sb strings.Builder
+	for _, driver := range util.DeviceBoundDrivers {
+		sb.WriteString(fmt.Sprintf("  %-12s %s\n", driver.Name, driver.Description))
+	}
+	return sb.String()
+}
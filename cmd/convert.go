@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ConvertTo           string
+	ConvertFrom         string
+	ConvertCommentsFrom string
+	ConvertDescribe     bool
+	ConvertConsole      bool
+	ConvertOutput       string
+)
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert --to <json|yaml|toml> <file>",
+	Short: "Converts a decrypted settings export to and from YAML or TOML for editing",
+	Long: `Converts a decrypted settings export to YAML or TOML, which are easier to edit by hand than JSON, and back to the
+JSON export AAPS imports. When converting back, the preferences are stored as a string and the file hash is
+recalculated.
+
+Preference values are always strings. In YAML, unquoted values like true or 3.0 are used exactly as written.
+Nested mappings and sequences of a preference are serialized into a compact JSON string. In TOML, preference values
+must be strings, booleans or integers.
+
+JSON can't hold comments. To keep the comments of an edited YAML file when converting an export to YAML again, pass
+the edited file with --comments-from. With --describe, known preferences get their description as a comment.
+
+The input format is detected from the file extension, use --from for stdin or other extensions.
+
+Examples:
+aaps-export-tool convert --to yaml export.json --describe
+aaps-export-tool convert --to json export.yaml
+aaps-export-tool convert --to yaml export.json --comments-from export-old.yaml --out export.yaml
+aaps-export-tool convert --to toml export.json`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		for _, format := range []string{ConvertTo, ConvertFrom} {
+			switch format {
+			case "", "json", "yaml", "toml":
+			default:
+				return fmt.Errorf("unknown format \"%s\", expected json, yaml or toml", format)
+			}
+		}
+		if ConvertCommentsFrom != "" {
+			return pathArgs(2)(cmd, []string{ConvertCommentsFrom, args[0]})
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		from := ConvertFrom
+		if from == "" {
+			from = formatOfPath(args[0])
+		}
+		if from == ConvertTo {
+			exitWithError(fmt.Sprintf("The export is already %s", strings.ToUpper(from)))
+		}
+
+		data, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		var exportJson []byte
+		switch from {
+		case "yaml":
+			exportJson, err = util.ExportFromYaml(data)
+		case "toml":
+			exportJson, err = util.ExportFromToml(data)
+		default:
+			exportJson = data
+		}
+		if err != nil {
+			exitWithError(fmt.Sprintf("Cannot read %s: %s", strings.ToUpper(from), err))
+		}
+
+		var outputData []byte
+		switch ConvertTo {
+		case "yaml":
+			var comments []byte
+			if ConvertCommentsFrom != "" {
+				comments, err = os.ReadFile(ConvertCommentsFrom)
+				if err != nil {
+					exitWithError(err.Error())
+				}
+			}
+			outputData, err = util.ExportToYaml(exportJson, comments, ConvertDescribe)
+		case "toml":
+			outputData, err = util.ExportToToml(exportJson)
+		default:
+			outputData = exportJson
+		}
+		if err != nil {
+			exitWithError(fmt.Sprintf("Cannot convert to %s: %s", strings.ToUpper(ConvertTo), err))
+		}
+
+		path := ConvertOutput
+		if path == "" && !readStdin {
+			path = strings.TrimSuffix(args[0], filepath.Ext(args[0])) + "." + ConvertTo
+		}
+		if core.DryRun {
+			// converting back to an existing JSON export reports the preferences which would change
+			if existing, err := os.ReadFile(path); err == nil && ConvertTo == "json" && !ConvertConsole && !util.IsEncrypted(existing) {
+				reportDryRun(existing, outputData, "")
+			}
+			fmt.Printf("Would convert the %s export to %s\n", strings.ToUpper(from), strings.ToUpper(ConvertTo))
+		}
+		wroteFile, err := writeOutput(outputData, path, ConvertConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Converted to %s and wrote to \"%s\" successfully\n", strings.ToUpper(ConvertTo), absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+
+	convertCmd.Flags().StringVar(&ConvertTo, "to", "", "Format to convert to: json, yaml or toml")
+	convertCmd.MarkFlagRequired("to")
+	convertCmd.Flags().StringVar(&ConvertFrom, "from", "", "Format of the input: json, yaml or toml (default: detected from the file extension)")
+	convertCmd.Flags().StringVar(&ConvertCommentsFrom, "comments-from", "", "YAML file to copy the comments from when converting to YAML")
+	convertCmd.Flags().BoolVar(&ConvertDescribe, "describe", false, "Add the descriptions of known preferences as comments when converting to YAML")
+
+	convertCmd.Flags().BoolVarP(&ConvertConsole, "console", "c", false, "Write converted file to stdout")
+	convertCmd.Flags().StringVarP(&ConvertOutput, "out", "o", "", "Write output to the specified file (default: original filename with the extension of the new format)")
+	convertCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
+
+// formatOfPath detects the format of a file from its extension, defaulting to JSON
+func formatOfPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
@@ -1,15 +1,13 @@
 package cmd
 
 import (
+	"aaps-export-tool/core"
 	"aaps-export-tool/util"
 	"encoding/hex"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/tidwall/gjson"
-	"io/ioutil"
-	"os"
 	"path/filepath"
-	"strings"
 )
 
 var (
@@ -27,53 +25,37 @@ var decryptCmd = &cobra.Command{
 	Short: "Decrypts an AAPS settings export and outputs to a file",
 	Long: `Decrypts the preferences of an AAPS settings export and outputs to a file.
 
+With --console, the whole decrypted export is written to stdout, so it can be piped into other commands. Earlier
+versions only printed the decrypted preferences, which --console --only-preferences still does.
+
 Examples:
 aaps-export-tool decrypt export.json
 aaps-export-tool decrypt export.json --out "decrypted.json"
 aaps-export-tool decrypt export.json --console
 aaps-export-tool decrypt export.json --preferences-object
+aaps-export-tool decrypt - --console < export.json
 `,
 	Args: pathArg,
 	Run: func(cmd *cobra.Command, args []string) {
-		data, err := ioutil.ReadFile(args[0])
+		data, err := readInput(args[0])
 		if err != nil {
-			panic(err)
+			exitWithError(err.Error())
 		}
 
 		if !DecryptForce && !util.IsEncrypted(data) {
-			fmt.Println("Cannot decrypt: input file is already decrypted")
-			return
+			exitWithError("Cannot decrypt: input file is already decrypted")
 		}
 
-		password := DecryptPassword
-		if DecryptPassword == "" {
-			var err error
-			password, err = displayPasswordPrompt()
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
+		password, err := getPassword(DecryptPassword)
+		if err != nil {
+			exitWithError(err.Error())
 		}
 
 		salt, _ := hex.DecodeString(gjson.GetBytes(data, "security.salt").String())
 
 		decrypted, err := util.Decrypt([]byte(password), salt, gjson.GetBytes(data, "content").String())
 		if err != nil {
-			panic(err)
-		}
-
-		if DecryptConsole {
-			fmt.Print(string(decrypted))
-			return
-		}
-
-		outputPath := DecryptOutput
-		if outputPath == "" {
-			ext := filepath.Ext(args[0])
-			name := strings.TrimSuffix(args[0], ext)
-			name = name + "_decrypted" + ext
-
-			outputPath = name
+			exitWithError(fmt.Sprintf("Cannot decrypt: %s", err))
 		}
 
 		outputData := decrypted
@@ -86,13 +68,19 @@ aaps-export-tool decrypt export.json --preferences-object
 			outputData = util.ConvertPreferencesToObject(outputData)
 		}
 
-		err = os.WriteFile(outputPath, outputData, 0644)
-		if err != nil {
-			panic(err)
+		if core.DryRun {
+			reportDryRun(data, util.ConvertToUnencryptedFormat(data, decrypted), password)
 		}
 
-		absolutePath, _ := filepath.Abs(outputPath)
-		fmt.Printf("Decrypted settings were exported to \"%s\"\n", absolutePath)
+		path := outputPath(args[0], DecryptOutput, "_decrypted")
+		wroteFile, err := writeOutput(outputData, path, DecryptConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Decrypted settings were exported to \"%s\"\n", absolutePath)
+		}
 	},
 }
 
@@ -1,14 +1,12 @@
 package cmd
 
 import (
+	"aaps-export-tool/core"
 	"aaps-export-tool/util"
 	"encoding/hex"
 	"fmt"
 	"github.com/tidwall/gjson"
-	"io/ioutil"
-	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -30,27 +28,22 @@ var encryptCmd = &cobra.Command{
 Examples:
 aaps-export-tool encrypt export.json
 aaps-export-tool encrypt export.json --out "encrypted.json"
-aaps-export-tool encrypt export.json --console`,
+aaps-export-tool encrypt export.json --console
+aaps-export-tool decrypt export.json -c | aaps-export-tool prefs set - key=value -c | aaps-export-tool encrypt - -c > out.json`,
 	Args: pathArg,
 	Run: func(cmd *cobra.Command, args []string) {
-		data, err := ioutil.ReadFile(args[0])
+		data, err := readInput(args[0])
 		if err != nil {
-			panic(err)
+			exitWithError(err.Error())
 		}
 
 		if !EncryptForce && util.IsEncrypted(data) {
-			fmt.Println("Cannot encrypt: input file is already encrypted")
-			return
+			exitWithError("Cannot encrypt: input file is already encrypted")
 		}
 
-		password := EncryptPassword
-		if EncryptPassword == "" {
-			var err error
-			password, err = displayPasswordPrompt()
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
+		password, err := getPassword(EncryptPassword)
+		if err != nil {
+			exitWithError(err.Error())
 		}
 
 		salt, _ := hex.DecodeString(EncryptSalt)
@@ -61,32 +54,24 @@ aaps-export-tool encrypt export.json --console`,
 		content := []byte(gjson.GetBytes(data, "content").String())
 		encrypted, err := util.Encrypt([]byte(password), salt, content)
 		if err != nil {
-			panic(err)
+			exitWithError(err.Error())
 		}
 
 		outputData := util.ConvertToEncryptedFormat(data, salt, encrypted, util.Sha256(content))
 
-		if EncryptConsole {
-			fmt.Print(string(outputData))
-			return
-		}
-
-		outputPath := EncryptOutput
-		if outputPath == "" {
-			ext := filepath.Ext(args[0])
-			name := strings.TrimSuffix(args[0], ext)
-			name = name + "_encrypted" + ext
-
-			outputPath = name
+		if core.DryRun {
+			reportDryRun(data, outputData, password)
 		}
 
-		err = os.WriteFile(outputPath, outputData, 0644)
+		path := outputPath(args[0], EncryptOutput, "_encrypted")
+		wroteFile, err := writeOutput(outputData, path, EncryptConsole)
 		if err != nil {
-			panic(err)
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Encrypted settings were exported to \"%s\"\n", absolutePath)
 		}
-
-		absolutePath, _ := filepath.Abs(outputPath)
-		fmt.Printf("Encrypted settings were exported to \"%s\"\n", absolutePath)
 	},
 }
 
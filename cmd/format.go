@@ -1,19 +1,21 @@
 package cmd
 
 import (
+	"aaps-export-tool/core"
 	"aaps-export-tool/util"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
 )
 
 var (
 	FormatForce   bool
 	FormatConsole bool
 	FormatOutput  string
+	FormatTyped   bool
+	FormatDeep    bool
 )
 
 // formatCmd represents the format command
@@ -23,44 +25,84 @@ var formatCmd = &cobra.Command{
 	Long: `Converts the 'content' key (aka preferences) in an unencrypted settings export between a string and JSON object.
 
 AAPS cannot import a settings export when the 'content' key is not a string, but JSON as a string is hard to edit manually.
-This command allows you to convert the preferences between JSON object and string, to allow for manual editing and re-importing.`,
+This command allows you to convert the preferences between JSON object and string, to allow for manual editing and re-importing.
+
+With --typed, the JSON object uses native JSON booleans and numbers instead of strings, based on the catalog of known
+preferences, so it can be checked with JSON Schema and editor tooling. Converting back to a string restores the
+exact strings AAPS stores.
+
+With --deep, JSON serialized inside preference values (automation events, QuickWizard entries and local profiles) is
+also expanded into real objects and arrays. Converting back to a string serializes them compactly again.
+
+Examples:
+aaps-export-tool format export.json
+aaps-export-tool format --typed export.json
+aaps-export-tool format --deep --typed export.json`,
 	Args: pathArg,
 	Run: func(cmd *cobra.Command, args []string) {
-		data, err := ioutil.ReadFile(args[0])
+		data, err := readInput(args[0])
 		if err != nil {
-			panic(err)
-		}
-
-		outputPath := args[0]
-		if FormatOutput != "" {
-			outputPath = FormatOutput
+			exitWithError(err.Error())
 		}
 
 		if !FormatForce && util.IsEncrypted(data) {
-			fmt.Println("Cannot format: input file is encrypted")
-			return
+			exitWithError("Cannot format: input file is encrypted")
 		}
 
 		var outputData []byte
 		var convertedType string
-		if util.IsPreferencesObject(data) {
+		isObject := util.IsPreferencesObject(data)
+		content := []byte(gjson.GetBytes(data, "content").Raw)
+		isTyped := isObject && util.HasTypedPreferences(content)
+		isExpanded := isObject && util.HasExpandedPreferences(content)
+
+		if isObject {
+			if err := util.ValidateExpandedPreferences(content); err != nil {
+				exitWithError(fmt.Sprintf("Cannot format: %s", err))
+			}
+		}
+
+		switch {
+		case FormatDeep && !isExpanded || FormatTyped && !isTyped:
+			// data is kept unchanged for the dry run report
+			stringData := data
+			if isObject {
+				stringData = util.ConvertPreferencesToString(data)
+			}
+			if FormatDeep {
+				outputData, err = util.ConvertPreferencesToExpandedObject(stringData, FormatTyped)
+				if err != nil {
+					exitWithError(fmt.Sprintf("Cannot format: %s", err))
+				}
+				convertedType = "expanded JSON object"
+			} else {
+				outputData = util.ConvertPreferencesToTypedObject(stringData)
+				convertedType = "typed JSON object"
+			}
+		case isObject:
 			outputData = util.ConvertPreferencesToString(data)
 			convertedType = "string"
-		} else {
+		default:
 			outputData = util.ConvertPreferencesToObject(data)
 			convertedType = "JSON object"
 		}
 
-		err = os.WriteFile(outputPath, outputData, 0644)
-		if err != nil {
-			panic(err)
+		if core.DryRun {
+			reportDryRun(data, outputData, "")
 		}
 
-		absolutePath, _ := filepath.Abs(outputPath)
-		if FormatOutput != "" {
-			fmt.Printf("Converted preferences to %s and wrote to \"%s\" successfully\n", convertedType, absolutePath)
-		} else {
-			fmt.Printf("Converted preferences to %s successfully\n", convertedType)
+		path := outputPath(args[0], FormatOutput, "")
+		wroteFile, err := writeOutput(outputData, path, FormatConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			if FormatOutput != "" {
+				fmt.Printf("Converted preferences to %s and wrote to \"%s\" successfully\n", convertedType, absolutePath)
+			} else {
+				fmt.Printf("Converted preferences to %s successfully\n", convertedType)
+			}
 		}
 	},
 }
@@ -70,6 +112,9 @@ func init() {
 
 	formatCmd.Flags().BoolVarP(&FormatForce, "force", "f", false, "Don't check if the input is decrypted before converting")
 
+	formatCmd.Flags().BoolVarP(&FormatTyped, "typed", "t", false, "Convert to a JSON object with native booleans and numbers")
+	formatCmd.Flags().BoolVar(&FormatDeep, "deep", false, "Convert to a JSON object with JSON inside preference values expanded")
+
 	formatCmd.Flags().BoolVarP(&FormatConsole, "console", "c", false, "Write converted file to stdout")
 	formatCmd.Flags().StringVarP(&FormatOutput, "out", "o", "", "Write output to the specified file (default: original file)")
 	formatCmd.MarkFlagsMutuallyExclusive("console", "out")
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"aaps-export-tool/util"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+	"os"
+	"strings"
+)
+
+var GitPassword string
+
+const gitSetupHelp = `Setup, with the password in AAPS_EXPORT_PASSWORD or a command printing it in AAPS_EXPORT_PASSWORD_COMMAND:
+
+git config diff.aaps.textconv "aaps-export-tool git-textconv"
+git config filter.aaps.clean "aaps-export-tool git-filter clean"
+git config filter.aaps.smudge "aaps-export-tool git-filter smudge"
+git config filter.aaps.required true
+echo "*.json filter=aaps diff=aaps" >> .gitattributes`
+
+// gitTextconvCmd represents the git-textconv command
+var gitTextconvCmd = &cobra.Command{
+	Use:   "git-textconv <file>",
+	Short: "Prints an export as a diffable list of preferences, for git's textconv",
+	Long: `Prints the metadata and preferences of an export as a sorted list of key = value lines, so 'git diff' shows which
+settings changed instead of changed ciphertext. Encrypted exports are decrypted.
+
+` + gitSetupHelp,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], GitPassword)
+
+		normalized, err := util.NormalizeExport(export.Data, false)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		gjson.GetBytes(normalized, "metadata").ForEach(func(key, value gjson.Result) bool {
+			fmt.Printf("metadata.%s = %s\n", key.String(), value.String())
+			return true
+		})
+		prefs := []byte(gjson.GetBytes(normalized, "content").Raw)
+		for _, key := range util.PreferenceKeys(prefs) {
+			value, _ := util.GetPreference(prefs, key)
+			fmt.Printf("%s = %s\n", key, value)
+		}
+	},
+}
+
+// gitFilterCmd represents the git-filter command
+var gitFilterCmd = &cobra.Command{
+	Use:   "git-filter",
+	Short: "Git clean and smudge filters which store exports encrypted, but check them out decrypted",
+	Long: `Git clean and smudge filters for exports. The clean filter encrypts exports when they're added to the repository,
+and the smudge filter decrypts them when they're checked out, so only encrypted exports are stored in the repository.
+
+Both filters normalize the export (see the normalize command). The clean filter encrypts deterministically, with a salt
+and nonce derived from the password and the preferences, so unchanged exports don't show up as modified. This reveals
+whether two encrypted versions have the same preferences, but nothing about the preferences themselves.
+
+The clean filter fails on every file it can't encrypt, such as an export which is no longer valid JSON after a hand
+edit, so git refuses to add it instead of storing it in plaintext. Only match exports in .gitattributes.
+
+The smudge filter passes exports it can't decrypt through unchanged, so a checkout without the password still works.
+
+` + gitSetupHelp,
+}
+
+var gitFilterCleanCmd = &cobra.Command{
+	Use:   "clean [path]",
+	Short: "Encrypts an export from stdin to stdout",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := readInput(stdinPath)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		output, err := cleanExport(data)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Cannot encrypt %s: %s", filterPath(args), err))
+		}
+		os.Stdout.Write(output)
+	},
+}
+
+var gitFilterSmudgeCmd = &cobra.Command{
+	Use:   "smudge [path]",
+	Short: "Decrypts an export from stdin to stdout",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := readInput(stdinPath)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		output, err := smudgeExport(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: checking out %s encrypted: %s\n", filterPath(args), err)
+			output = data
+		}
+		os.Stdout.Write(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gitTextconvCmd, gitFilterCmd)
+	gitFilterCmd.AddCommand(gitFilterCleanCmd, gitFilterSmudgeCmd)
+
+	for _, c := range []*cobra.Command{gitTextconvCmd, gitFilterCmd} {
+		c.PersistentFlags().StringVarP(&GitPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+	}
+}
+
+// cleanExport normalizes and deterministically encrypts an export. Anything which isn't an export is an error, since
+// passing it through would store a broken decrypted export in plaintext.
+func cleanExport(data []byte) ([]byte, error) {
+	if !isExport(data) {
+		return nil, errors.New("not a valid settings export, fix it or remove it from the files matched by the filter")
+	}
+
+	password, err := getPassword(GitPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if util.IsEncrypted(data) {
+		if data, err = util.DecryptExport(data, password); err != nil {
+			return nil, err
+		}
+	}
+	if data, err = util.NormalizeExport(data, false); err != nil {
+		return nil, err
+	}
+	return util.EncryptExportDeterministic(data, password)
+}
+
+// smudgeExport decrypts and normalizes an export. Files which aren't encrypted exports are returned unchanged.
+func smudgeExport(data []byte) ([]byte, error) {
+	if !isExport(data) || !util.IsEncrypted(data) {
+		return data, nil
+	}
+
+	password, err := getPassword(GitPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := util.DecryptExport(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return util.NormalizeExport(decrypted, false)
+}
+
+// isExport checks whether the data looks like a settings export, since git attributes may match other files
+func isExport(data []byte) bool {
+	return gjson.ValidBytes(data) && gjson.GetBytes(data, "format").Exists() && gjson.GetBytes(data, "content").Exists()
+}
+
+func filterPath(args []string) string {
+	if len(args) == 0 {
+		return "export"
+	}
+	return strings.TrimSpace(args[0])
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"aaps-export-tool/util"
+	"bytes"
+	"testing"
+)
+
+func TestCleanExport(t *testing.T) {
+	GitPassword = "secret"
+	defer func() { GitPassword = "" }()
+
+	export := []byte(`{"metadata":{"aaps_version":"3.2.0"},"format":"aaps_structured","security":{"algorithm":"none"},` +
+		`"content":{"units":"mg/dl","nsclientinternal_api_secret":"very secret"}}`)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"invalid JSON", []byte(`{"format":"aaps_structured","content":{"nsclientinternal_api_secret":"very secret",}}`)},
+		{"not an export", []byte(`{"units":"mg/dl","nsclientinternal_api_secret":"very secret"}`)},
+		{"plain text", []byte("nsclientinternal_api_secret = very secret\n")},
+		{"empty", nil},
+	}
+	for _, test := range tests {
+		if output, err := cleanExport(test.data); err == nil {
+			t.Errorf("%s: no error, got %s", test.name, output)
+		}
+	}
+
+	output, err := cleanExport(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !util.IsEncrypted(output) || bytes.Contains(output, []byte("very secret")) {
+		t.Errorf("the export wasn't encrypted:\n%s", output)
+	}
+	again, err := cleanExport(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, output) {
+		t.Errorf("cleaning an encrypted export changed it")
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"aaps-export-tool/util"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var (
+	LintFormat    string
+	LintSuppress  []string
+	LintFailOn    string
+	LintListRules bool
+	LintPassword  string
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint <file>",
+	Short: "Checks the preferences of an export for unsafe or inconsistent settings",
+	Long: `Checks the preferences of an export against safety and consistency rules, such as safety limits for the
+patient age, conflicting plugins and missing settings.
+
+Each rule has an ID and a severity. Findings can be suppressed by rule ID, or by rule ID and preference key
+(e.g. --suppress max-iob-limit:openapsmb_max_iob). The exit status is 1 if there are findings with at least the
+severity given by --fail-on.
+
+Examples:
+aaps-export-tool lint export.json
+aaps-export-tool lint export.json --format sarif > results.sarif
+aaps-export-tool lint export.json --suppress nightscout-url-missing --fail-on warning
+aaps-export-tool lint --list-rules`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if LintListRules {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MatchAll(cobra.ExactArgs(1), pathArg)(cmd, args)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch LintFormat {
+		case "text", "json", "sarif":
+		default:
+			return fmt.Errorf("invalid format \"%s\", expected text, json or sarif", LintFormat)
+		}
+		if LintFailOn != "none" && util.Severity(LintFailOn).Rank() == 0 {
+			return fmt.Errorf("invalid severity \"%s\" for --fail-on, expected error, warning, info or none", LintFailOn)
+		}
+		for _, suppressed := range LintSuppress {
+			id, _, _ := strings.Cut(suppressed, ":")
+			if util.FindLintRule(id) == nil {
+				return fmt.Errorf("unknown rule \"%s\" in --suppress", id)
+			}
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if LintListRules {
+			for _, rule := range util.LintRules {
+				fmt.Printf("%-24s %-8s %s\n", rule.ID, rule.Severity, rule.Description)
+			}
+			return
+		}
+
+		export, _ := loadExport(args[0], LintPassword)
+
+		findings := util.Lint(export, LintSuppress)
+
+		switch LintFormat {
+		case "json":
+			if findings == nil {
+				findings = []util.LintFinding{}
+			}
+			printJson(findings)
+		case "sarif":
+			printJson(util.SarifReport(args[0], findings))
+		default:
+			for _, finding := range findings {
+				fmt.Printf("%-8s %-24s %s: %s\n", finding.Severity, finding.RuleID, finding.Key, finding.Message)
+			}
+			fmt.Printf("%d problem(s) found\n", len(findings))
+		}
+
+		for _, finding := range findings {
+			if finding.Severity.AtLeast(util.Severity(LintFailOn)) {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringVarP(&LintPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+	lintCmd.Flags().StringVar(&LintFormat, "format", "text", "Output format: text, json or sarif")
+	lintCmd.Flags().StringSliceVar(&LintSuppress, "suppress", []string{}, "Comma-separated rule ID(s) or rule:key pairs to suppress. May be specified multiple times")
+	lintCmd.Flags().StringVar(&LintFailOn, "fail-on", "error", "Exit with status 1 if there are findings of at least this severity: error, warning, info or none")
+	lintCmd.Flags().BoolVar(&LintListRules, "list-rules", false, "List all rules instead of checking an export")
+}
+
+func printJson(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(out))
+}
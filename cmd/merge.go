@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var (
+	MergeBase        string
+	MergePrefer      string
+	MergePassword    string
+	MergeNewPassword string
+	MergeConsole     bool
+	MergeOutput      string
+)
+
+// mergeCmd represents the merge command
+var mergeCmd = &cobra.Command{
+	Use:   "merge --base <base> <ours> <theirs>",
+	Short: "Merges the preferences of two exports which were changed from a common base export",
+	Long: `Performs a three-way merge of the preferences of two exports which were changed from a common base export.
+
+Preferences which were only changed in one of the exports are taken automatically. Preferences which were changed
+differently in both exports are conflicts, and are resolved by prompting for each one, or with --prefer.
+The metadata of the merged export is taken from 'ours', and the merged export is encrypted with a new password.
+
+Examples:
+aaps-export-tool merge --base baseline.json phone.json clinic.json
+aaps-export-tool merge --base baseline.json phone.json clinic.json --prefer theirs
+aaps-export-tool merge --base baseline.json phone.json clinic.json --out "merged.json"`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if MergePrefer != "" && MergePrefer != "ours" && MergePrefer != "theirs" {
+			return fmt.Errorf("invalid value \"%s\" for --prefer, expected \"ours\" or \"theirs\"", MergePrefer)
+		}
+		// the base is checked with the other inputs, so only one of them can be read from stdin
+		return pathArgs(3)(cmd, append([]string{MergeBase}, args...))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		base, _ := loadExport(MergeBase, MergePassword)
+		ours, _ := loadExport(args[0], MergePassword)
+		theirs, _ := loadExport(args[1], MergePassword)
+
+		original := ours.Data
+		merged, conflicts := util.MergePreferences(base.Preferences(), ours.Preferences(), theirs.Preferences())
+
+		if len(conflicts) > 0 && MergePrefer == "" && readStdin {
+			exitWithError(fmt.Sprintf("%d conflict(s) can't be resolved interactively when reading from stdin, use --prefer", len(conflicts)))
+		}
+
+		for _, conflict := range conflicts {
+			useTheirs := MergePrefer == "theirs"
+			if MergePrefer == "" {
+				var err error
+				useTheirs, err = selectConflictResolution(conflict)
+				if err != nil {
+					exitWithError(err.Error())
+				}
+			}
+
+			merged = util.ResolveConflict(merged, conflict, useTheirs)
+			if core.Verbose {
+				side := "ours"
+				if useTheirs {
+					side = "theirs"
+				}
+				log.Printf("Resolved conflict in preference \"%s\" using %s", conflict.Key, side)
+			}
+		}
+
+		ours.SetPreferences(merged)
+
+		if core.DryRun {
+			// the unencrypted exports are compared, since the merged export uses a different password than the inputs
+			reportDryRun(original, ours.Data, "")
+		}
+
+		password := MergeNewPassword
+		if password == "" {
+			if readStdin {
+				exitWithError("The new password can't be prompted for when reading from stdin, use --new-password")
+			}
+			var err error
+			password, err = displayNewPasswordPrompt("Enter a password for the merged export:")
+			if err != nil {
+				exitWithError(err.Error())
+			}
+		}
+
+		outputData, err := util.EncryptExport(ours.Data, password)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		path := outputPath(args[0], MergeOutput, "_merged")
+		wroteFile, err := writeOutput(outputData, path, MergeConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Merged preferences with %d conflict(s) and wrote to \"%s\" successfully\n", len(conflicts), absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringVarP(&MergeBase, "base", "b", "", "The export which both exports were changed from")
+	mergeCmd.MarkFlagRequired("base")
+	mergeCmd.Flags().StringVar(&MergePrefer, "prefer", "", "Resolve all conflicts using 'ours' or 'theirs' instead of prompting")
+
+	mergeCmd.Flags().StringVarP(&MergePassword, "password", "p", "", "Manually specify the password of the input exports (only use if necessary, like in shell scripts)")
+	mergeCmd.Flags().StringVar(&MergeNewPassword, "new-password", "", "Manually specify the password for the merged export (only use if necessary, like in shell scripts)")
+
+	mergeCmd.Flags().BoolVarP(&MergeConsole, "console", "c", false, "Write export to stdout")
+	mergeCmd.Flags().StringVarP(&MergeOutput, "out", "o", "", "Write output to the specified file (default: 'ours' filename with '_merged' before file extension)")
+	mergeCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
+
+func selectConflictResolution(conflict util.MergeConflict) (bool, error) {
+	oursOption := fmt.Sprintf("Ours: %s", conflict.Ours)
+	theirsOption := fmt.Sprintf("Theirs: %s", conflict.Theirs)
+
+	selected := ""
+	prompt := &survey.Select{
+		Message: fmt.Sprintf("Conflict in preference \"%s\" (base: %s):", conflict.Key, conflict.Base),
+		Options: []string{oursOption, theirsOption},
+	}
+	err := survey.AskOne(prompt, &selected, survey.WithStdio(os.Stdin, os.Stderr, os.Stderr))
+	if err != nil {
+		return false, err
+	}
+
+	return selected == theirsOption, nil
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	MigrateTo          string
+	MigrateFrom        string
+	MigrateMigrations  string
+	MigrateVersionOnly bool
+	MigrateConsole     bool
+	MigrateOutput      string
+	MigratePassword    string
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate --to <version> <file>",
+	Short: "Migrates the preferences of an export to a newer AAPS version",
+	Long: `Migrates the preferences of an export to a newer AAPS version, for example when restoring an old backup onto a
+new AAPS build. Every migration newer than the AAPS version of the export, up to the target version, is applied in
+order. Migrations rename, convert or drop preferences, and the AAPS version in the metadata is set to the target.
+A target such as 3.2 includes every 3.2.x version.
+
+No migrations are built in yet, they are loaded from a directory of YAML files with --migrations. The command fails
+when no migration covers the versions from the export to the target, because the preferences would be left unchanged
+while the metadata claims the newer version. --version-only changes only the AAPS version in the metadata instead.
+
+version: 3.2.0
+steps:
+  - rename:               # rename preferences, keeping their value
+      old_key: new_key
+    overwrite: true       # replace existing preferences with the new keys, renaming to them fails otherwise
+  - convert:              # replace values of a preference
+      key: some_key
+      values:
+        old_value: new_value
+  - drop: [key_a, key_*]  # remove preferences, '*' matches any characters
+
+Examples:
+aaps-export-tool migrate --to 3.2 --migrations ./migrations export.json
+aaps-export-tool migrate --to 3.2 --from 3.1.0.3 --migrations ./migrations export.json --dry-run
+aaps-export-tool migrate --to 3.2 --version-only export.json --out "export-3.2.json"`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		var migrations []util.Migration
+		if MigrateMigrations != "" {
+			var err error
+			migrations, err = loadMigrations(MigrateMigrations)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			util.SortMigrations(migrations)
+		}
+
+		export, data := loadExport(args[0], MigratePassword)
+
+		from := MigrateFrom
+		if from == "" {
+			from = util.ExportVersion(export.Data)
+		}
+		if from == "" {
+			exitWithError("The export has no AAPS version in its metadata, specify it with --from")
+		}
+		if util.CompareVersionsTo(from, MigrateTo) > 0 {
+			exitWithError(fmt.Sprintf("Cannot migrate from AAPS %s to the older version %s", from, MigrateTo))
+		}
+
+		// a target such as 3.2 keeps the more precise version of an export which is already at 3.2.x
+		version := MigrateTo
+		if util.CompareVersionsTo(from, MigrateTo) == 0 {
+			version = from
+		}
+
+		needed := util.MigrationsBetween(migrations, from, MigrateTo)
+		if len(needed) > 0 && MigrateVersionOnly {
+			exitWithError(fmt.Sprintf("--version-only is given, but migrations between AAPS %s and %s exist", from, MigrateTo))
+		}
+		if len(needed) == 0 && version != from && !MigrateVersionOnly {
+			exitWithError(fmt.Sprintf("No migration covers AAPS %s to %s, so only the version in the metadata would change. "+
+				"Add the migrations with --migrations, or use --version-only to change only the version", from, MigrateTo))
+		}
+		changes, err := util.MigrateExport(export, needed, version)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		outputData, err := export.Bytes()
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		if core.DryRun {
+			reportDryRun(data, outputData, export.Password)
+		}
+
+		path := outputPath(args[0], MigrateOutput, "_migrated")
+		wroteFile, err := writeOutput(outputData, path, MigrateConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		// the report goes to stderr when stdout is carrying the export
+		var report io.Writer = os.Stdout
+		if !wroteFile {
+			report = os.Stderr
+		}
+
+		versions := make([]string, len(needed))
+		for i, migration := range needed {
+			versions[i] = migration.Version
+		}
+		if len(versions) > 0 {
+			fmt.Fprintf(report, "Applied migration(s) %s\n", strings.Join(versions, ", "))
+		} else {
+			fmt.Fprintf(report, "No migrations between AAPS %s and %s\n", from, MigrateTo)
+		}
+		for _, change := range changes {
+			fmt.Fprintln(report, change)
+		}
+
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Migrated to AAPS %s and wrote to \"%s\" successfully\n", version, absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVarP(&MigratePassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+	migrateCmd.Flags().StringVar(&MigrateTo, "to", "", "AAPS version to migrate to")
+	migrateCmd.MarkFlagRequired("to")
+	migrateCmd.Flags().StringVar(&MigrateFrom, "from", "", "AAPS version the export was created with (default: the version in the export metadata)")
+	migrateCmd.Flags().StringVar(&MigrateMigrations, "migrations", "", "Directory with the YAML migrations to apply")
+	migrateCmd.Flags().BoolVar(&MigrateVersionOnly, "version-only", false, "Only change the AAPS version in the metadata, for versions which need no migrations")
+
+	migrateCmd.Flags().BoolVarP(&MigrateConsole, "console", "c", false, "Write export to stdout")
+	migrateCmd.Flags().StringVarP(&MigrateOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_migrated' before file extension)")
+	migrateCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
+
+// loadMigrations parses all YAML migrations in a directory
+func loadMigrations(dir string) ([]util.Migration, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	ymlPaths, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+	paths = append(paths, ymlPaths...)
+
+	var migrations []util.Migration
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		migration, err := util.ParseMigration(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration \"%s\": %w", path, err)
+		}
+		migrations = append(migrations, *migration)
+	}
+	return migrations, nil
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var (
+	NormalizeCheck   bool
+	NormalizeDeep    bool
+	NormalizeConsole bool
+	NormalizeOutput  string
+)
+
+// normalizeCmd represents the normalize command
+var normalizeCmd = &cobra.Command{
+	Use:   "normalize <file>",
+	Short: "Converts a decrypted settings export to a canonical form for version control",
+	Long: `Converts a decrypted settings export to a canonical form, so exports with the same settings are identical
+regardless of the order AAPS wrote the keys in. This keeps diffs in a settings repository small.
+
+The preferences and all other keys are sorted, the indentation is stable, and the file hash is recalculated. The values
+of the preferences and the storage format of the preferences are kept. With --deep, JSON inside preference values, such
+as automations and QuickWizard buttons, is also serialized with sorted keys.
+Encrypted exports can't be normalized without changing their ciphertext, decrypt them first.
+
+Every command which writes an export can also write it in canonical form with --canonical, which is the form of
+normalize without --deep.
+
+Examples:
+aaps-export-tool normalize export.json
+aaps-export-tool normalize export.json --check
+aaps-export-tool normalize export.json --deep
+aaps-export-tool decrypt export.json --canonical`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		if NormalizeCheck {
+			normalized, err := util.IsNormalized(data, NormalizeDeep)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			if !normalized {
+				fmt.Fprintf(os.Stderr, "%s is not normalized\n", args[0])
+				os.Exit(1)
+			}
+			return
+		}
+
+		outputData, err := util.NormalizeExport(data, NormalizeDeep)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		if core.DryRun {
+			reportDryRun(data, outputData, "")
+		}
+
+		path := outputPath(args[0], NormalizeOutput, "")
+		wroteFile, err := writeOutput(outputData, path, NormalizeConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Normalized and wrote to \"%s\" successfully\n", absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(normalizeCmd)
+
+	normalizeCmd.Flags().BoolVar(&NormalizeCheck, "check", false, "Don't write anything, exit with status 1 if the export is not normalized")
+	normalizeCmd.Flags().BoolVar(&NormalizeDeep, "deep", false, "Also sort the keys of JSON inside preference values")
+
+	normalizeCmd.Flags().BoolVarP(&NormalizeConsole, "console", "c", false, "Write normalized file to stdout")
+	normalizeCmd.Flags().StringVarP(&NormalizeOutput, "out", "o", "", "Write output to the specified file (default: original file)")
+	normalizeCmd.MarkFlagsMutuallyExclusive("console", "out")
+	normalizeCmd.MarkFlagsMutuallyExclusive("check", "console")
+	normalizeCmd.MarkFlagsMutuallyExclusive("check", "out")
+}
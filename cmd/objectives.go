@@ -3,17 +3,13 @@ package cmd
 import (
 	"aaps-export-tool/core"
 	"aaps-export-tool/util"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/spf13/cobra"
-	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
-	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 var (
@@ -37,100 +33,56 @@ aaps-export-tool objectives export.json -j 6,7,8`,
 	Hidden: true,
 	Args:   pathArg,
 	Run: func(cmd *cobra.Command, args []string) {
-		data, err := ioutil.ReadFile(args[0])
-		if err != nil {
-			panic(err)
-		}
-
-		isPrefsObject := util.IsPreferencesObject(data)
-		isEncrypted := util.IsEncrypted(data)
-		password := ""
-
-		if isEncrypted {
-			// decrypt the data first
-			password, err = displayPasswordPrompt()
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
+		export, data := loadExport(args[0], ObjectivesPassword)
 
-			salt, _ := hex.DecodeString(gjson.GetBytes(data, "security.salt").String())
+		prefs := export.Preferences()
 
-			decrypted, err := util.Decrypt([]byte(password), salt, gjson.GetBytes(data, "content").String())
-			if err != nil {
-				panic(err)
+		if len(ObjectivesList) == 0 {
+			if readStdin {
+				exitWithError("Objectives must be specified with --objectives when reading from stdin")
 			}
-			data = util.ConvertToUnencryptedFormat(data, decrypted)
-		}
 
-		prefs := []byte(gjson.GetBytes(data, "content").String())
-
-		if len(ObjectivesList) == 0 {
 			defaults := util.GetCompletedObjectives(prefs)
 			objs, err := selectObjectives(defaults)
 			if err != nil {
-				fmt.Println(err.Error())
-				return
+				exitWithError(err.Error())
 			}
 
 			ObjectivesList = objs
 		}
 
 		if len(ObjectivesList) == 0 {
-			fmt.Println("No objectives were selected")
-			return
+			exitWithError("No objectives were selected")
 		}
 
 		objList := util.ObjectiveNumbersToObjects(ObjectivesList)
 		for _, obj := range objList {
 			prefs = obj.Complete(prefs)
 			if core.Verbose {
-				fmt.Printf("Set objective %d (%s) as completed\n", obj.Number, obj.Name)
+				log.Printf("Set objective %d (%s) as completed", obj.Number, obj.Name)
 			}
 		}
 
-		if isEncrypted {
-			// re-encrypt if original was encrypted
-			salt, _ := util.GenerateSalt()
-			encrypted, err := util.Encrypt([]byte(password), salt, prefs)
-			if err != nil {
-				panic(err)
-			}
-
-			data = util.ConvertToEncryptedFormat(data, salt, encrypted, util.Sha256(prefs))
-		} else {
-			data, _ = sjson.SetRawBytes(data, "content", prefs)
-
-			// restore the previous storage format for the prefs
-			if isPrefsObject {
-				data = util.ConvertPreferencesToObject(data)
-			} else {
-				data = util.ConvertPreferencesToString(data)
-			}
-		}
-
-		if ObjectivesConsole {
-			fmt.Print(string(data))
-			return
+		export.SetPreferences(prefs)
+		outputData, err := export.Bytes()
+		if err != nil {
+			exitWithError(err.Error())
 		}
 
-		outputPath := ObjectivesOutput
-		if outputPath == "" {
-			ext := filepath.Ext(args[0])
-			name := strings.TrimSuffix(args[0], ext)
-			name = name + "_objectives" + ext
-
-			outputPath = name
+		if core.DryRun {
+			reportDryRun(data, outputData, export.Password)
 		}
 
-		err = os.WriteFile(outputPath, data, 0644)
+		path := outputPath(args[0], ObjectivesOutput, "_objectives")
+		wroteFile, err := writeOutput(outputData, path, ObjectivesConsole)
 		if err != nil {
-			panic(err)
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			vals, _ := json.Marshal(ObjectivesList)
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Objectives %s are now completed and the file was exported to \"%s\"\n", vals, absolutePath)
 		}
-
-		vals, _ := json.Marshal(ObjectivesList)
-		absolutePath, _ := filepath.Abs(outputPath)
-		fmt.Printf("Objectives %s are now completed and the file was exported to \"%s\"\n", vals, absolutePath)
 	},
 }
 
@@ -170,7 +122,7 @@ func selectObjectives(defaults []int) ([]int, error) {
 		Default:  defaultOptions,
 		PageSize: 10,
 	}
-	err := survey.AskOne(prompt, &selectedOptions)
+	err := survey.AskOne(prompt, &selectedOptions, survey.WithStdio(os.Stdin, os.Stderr, os.Stderr))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+var (
+	PatchPassword string
+	PatchConsole  bool
+	PatchOutput   string
+)
+
+// patchCmd represents the patch command
+var patchCmd = &cobra.Command{
+	Use:   "patch",
+	Short: "Creates and applies JSON Patches (RFC 6902) for the preferences of settings exports",
+	Long: `Creates and applies JSON Patches (RFC 6902) for the preferences of settings exports.
+Patches are small and reviewable, which makes them suitable for distributing settings changes.
+
+Examples:
+aaps-export-tool patch create old.json new.json > changes.patch.json
+aaps-export-tool patch apply changes.patch.json export.json
+aaps-export-tool patch apply changes.patch.json export.json --out "export-patched.json"`,
+}
+
+var patchCreateCmd = &cobra.Command{
+	Use:   "create <old> <new>",
+	Short: "Creates a patch with the preference changes between two exports",
+	Long: `Creates a JSON Patch with the preference changes between two exports.
+Replaced and removed preferences are preceded by a 'test' operation, so the patch will refuse to apply to preferences
+which have been changed since.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	Run: func(cmd *cobra.Command, args []string) {
+		oldExport, _ := loadExport(args[0], PatchPassword)
+		newExport, _ := loadExport(args[1], PatchPassword)
+
+		patch := util.CreatePatch(oldExport.Preferences(), newExport.Preferences())
+		if patch == nil {
+			patch = []util.PatchOperation{}
+		}
+
+		outputData, err := json.MarshalIndent(patch, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		outputData = append(outputData, '\n')
+
+		if core.DryRun {
+			for _, op := range patch {
+				if op.Op != "test" {
+					fmt.Printf("%s %s\n", op.Op, op.Path)
+				}
+			}
+			fmt.Printf("Would create a patch with %d operation(s)\n", len(patch))
+		}
+		wroteFile, err := writeOutput(outputData, PatchOutput, PatchConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(PatchOutput)
+			fmt.Printf("Patch with %d operation(s) was written to \"%s\"\n", len(patch), absolutePath)
+		}
+	},
+}
+
+var patchApplyCmd = &cobra.Command{
+	Use:   "apply <patch> <file>",
+	Short: "Applies a patch to the preferences of an export",
+	Long: `Applies a JSON Patch to the preferences of an export.
+Encrypted exports are decrypted in memory and re-encrypted with the same password, and the storage of the preferences
+(string or JSON object) is kept. The patch is not applied at all if one of its 'test' operations fails.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	Run: func(cmd *cobra.Command, args []string) {
+		patchData, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		patch, err := util.ParsePatch(patchData)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid patch: %s", err))
+		}
+
+		export, data := loadExport(args[1], PatchPassword)
+
+		prefs, err := util.ApplyPatch(export.Preferences(), patch)
+		if err != nil {
+			var conflict *util.PatchConflictError
+			if errors.As(err, &conflict) {
+				exitWithError("Cannot apply patch, the export has conflicting changes:", err)
+			}
+			exitWithError("Cannot apply patch:", err)
+		}
+
+		export.SetPreferences(prefs)
+		writeExport(export, data, args[1], PatchOutput, PatchConsole, "_patched", fmt.Sprintf("Applied %d patch operation(s)", len(patch)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(patchCmd)
+	patchCmd.AddCommand(patchCreateCmd, patchApplyCmd)
+
+	patchCmd.PersistentFlags().StringVarP(&PatchPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	patchCreateCmd.Flags().BoolVarP(&PatchConsole, "console", "c", false, "Write patch to stdout (default)")
+	patchCreateCmd.Flags().StringVarP(&PatchOutput, "out", "o", "", "Write patch to the specified file")
+	patchCreateCmd.MarkFlagsMutuallyExclusive("console", "out")
+
+	patchApplyCmd.Flags().BoolVarP(&PatchConsole, "console", "c", false, "Write export to stdout")
+	patchApplyCmd.Flags().StringVarP(&PatchOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_patched' before file extension)")
+	patchApplyCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
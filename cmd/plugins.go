@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	PluginsPassword string
+	PluginsConsole  bool
+	PluginsOutput   string
+	PluginsAll      bool
+)
+
+// pluginsCmd represents the plugins command
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins <file>",
+	Short: "Shows the active plugins of the config builder",
+	Long: `Shows the enabled pump, BG source, APS, sensitivity, insulin, profile and sync plugins of the config builder,
+which AAPS stores in the ConfigBuilder_<TYPE>_<Plugin>_Enabled and _Visible preferences.
+With --all, every plugin type and every plugin with a state in the export is shown, marking the enabled ones.
+
+Examples:
+aaps-export-tool plugins export.json
+aaps-export-tool plugins export.json --all
+aaps-export-tool plugins use export.json pump OmnipodDash`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], PluginsPassword)
+		prefs := export.Preferences()
+
+		if PluginsAll {
+			for _, pluginType := range util.PluginTypes(prefs) {
+				fmt.Printf("%s:\n", pluginType)
+				for _, plugin := range util.Plugins(prefs, pluginType) {
+					state := "[ ]"
+					if util.IsPluginEnabled(prefs, pluginType, plugin) {
+						state = "[x]"
+					}
+					fmt.Printf("  %s %s\n", state, plugin)
+				}
+			}
+			return
+		}
+
+		for _, t := range util.PluginTypeNames {
+			enabled := util.EnabledPlugins(prefs, t.Type)
+			active := strings.Join(enabled, ", ")
+			if len(enabled) == 0 {
+				active = "none"
+			}
+			fmt.Printf("%-12s %s\n", t.Name+":", active)
+			if len(enabled) > 1 && util.IsExclusivePluginType(t.Type) {
+				fmt.Fprintf(os.Stderr, "warning: %d %s plugins are enabled, AAPS only allows one\n", len(enabled), t.Type)
+			}
+		}
+	},
+}
+
+var pluginsUseCmd = &cobra.Command{
+	Use:   "use <file> <type> <plugin>",
+	Short: "Switches the active plugin of a type",
+	Long: `Enables a plugin and shows its tab. For the pump, BG source, APS, sensitivity, insulin and profile types, of
+which AAPS only allows one plugin at once, all other plugins of the type are disabled and their tabs hidden.
+
+The type is a plugin type such as pump or aps, ignoring case. The plugin is found by its class name, ignoring case and
+the "Plugin" suffix, or by an unambiguous prefix of it, among the plugins with a state in the export. A full class name
+ending in "Plugin" which has no state yet is added.
+
+Settings of the previous plugin, such as the pairing of a pump, are kept. The new plugin still has to be set up in AAPS.
+
+Examples:
+aaps-export-tool plugins use export.json pump OmnipodDash
+aaps-export-tool plugins use export.json aps OpenAPSSMB --out "export-smb.json"`,
+	Args: cobra.MatchAll(cobra.ExactArgs(3), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		pluginType := strings.ToUpper(args[1])
+		export, data := loadExport(args[0], PluginsPassword)
+		prefs := export.Preferences()
+
+		var types []string
+		for _, t := range util.PluginTypeNames {
+			types = append(types, t.Type)
+		}
+		for _, t := range util.PluginTypes(prefs) {
+			if !containsName(types, t) {
+				types = append(types, t)
+			}
+		}
+		if !containsName(types, pluginType) {
+			exitWithError(fmt.Sprintf("Unknown plugin type \"%s\", expected one of %s", args[1], strings.ToLower(strings.Join(types, ", "))))
+		}
+
+		plugin, err := util.FindPlugin(prefs, pluginType, args[2])
+		if err != nil {
+			if !strings.HasSuffix(args[2], "Plugin") {
+				exitWithError(err.Error())
+			}
+			plugin = args[2]
+			fmt.Fprintf(os.Stderr, "warning: %s has no state in the export yet, make sure the class name is right\n", plugin)
+		}
+
+		prefs, changes := util.UsePlugin(prefs, pluginType, plugin)
+		if core.Verbose {
+			for _, change := range changes {
+				log.Println(change)
+			}
+		}
+
+		export.SetPreferences(prefs)
+		writeExport(export, data, args[0], PluginsOutput, PluginsConsole, "_plugins", fmt.Sprintf("Switched %s to %s", pluginType, plugin))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pluginsCmd)
+	pluginsCmd.AddCommand(pluginsUseCmd)
+
+	pluginsCmd.PersistentFlags().StringVarP(&PluginsPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+	pluginsCmd.Flags().BoolVarP(&PluginsAll, "all", "a", false, "Show all plugin types and plugins")
+
+	pluginsUseCmd.Flags().BoolVarP(&PluginsConsole, "console", "c", false, "Write export to stdout")
+	pluginsUseCmd.Flags().StringVarP(&PluginsOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_plugins' before file extension)")
+	pluginsUseCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var (
+	PolicyFix      bool
+	PolicyFormat   string
+	PolicyFailOn   string
+	PolicyPassword string
+	PolicyConsole  bool
+	PolicyOutput   string
+)
+
+// policyCmd represents the policy command
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Enforces clinic policies on the preferences of settings exports",
+}
+
+var policyCheckCmd = &cobra.Command{
+	Use:   "check <policy.yaml> <file>",
+	Short: "Checks an export against a policy file",
+	Long: `Checks the preferences of an export against a policy file, such as the allowed settings ranges of a clinic.
+
+A policy is a YAML file with a list of rules. Each rule restricts a single preference, and only applies when all of
+its conditions ('when') are met:
+
+rules:
+  - id: max-iob-new-loopers
+    description: Max IOB is limited until the autosens objective is completed
+    severity: error                    # error (default), warning or info
+    key: openapsmb_max_iob
+    max: 5                             # also: min, allowed, equals, required
+    when:
+      objectives_not_completed: [8]    # also: objectives_completed, preferences
+  - id: no-smb-before-objective-9
+    key: use_smb
+    equals: "false"
+    when:
+      objectives_not_completed: [9]
+
+With --fix, values outside of the allowed range are clamped and values which must be equal to a value are set to it,
+then the export is written (re-encrypted if needed). The exit status is 1 if violations with at least the severity
+given by --fail-on remain.
+
+Examples:
+aaps-export-tool policy check clinic-policy.yaml export.json
+aaps-export-tool policy check clinic-policy.yaml export.json --fix
+aaps-export-tool policy check clinic-policy.yaml export.json --format json --fail-on warning`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if PolicyFormat != "text" && PolicyFormat != "json" {
+			return fmt.Errorf("invalid format \"%s\", expected text or json", PolicyFormat)
+		}
+		if PolicyFailOn != "none" && util.Severity(PolicyFailOn).Rank() == 0 {
+			return fmt.Errorf("invalid severity \"%s\" for --fail-on, expected error, warning, info or none", PolicyFailOn)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		policyData, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		policy, err := util.ParsePolicy(policyData)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid policy: %s", err))
+		}
+
+		export, data := loadExport(args[1], PolicyPassword)
+
+		version := util.ExportVersion(export.Data)
+		remaining := policy.Check(export.Preferences(), version)
+
+		if PolicyFix {
+			var prefs []byte
+			var fixes []util.PolicyViolation
+			prefs, fixes, remaining = policy.Fix(export.Preferences(), version)
+			export.SetPreferences(prefs)
+
+			outputData, err := export.Bytes()
+			if err != nil {
+				exitWithError(err.Error())
+			}
+
+			if core.DryRun {
+				reportDryRun(data, outputData, export.Password)
+			}
+
+			path := outputPath(args[1], PolicyOutput, "_policy")
+			wroteFile, err := writeOutput(outputData, path, PolicyConsole)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			if wroteFile {
+				absolutePath, _ := filepath.Abs(path)
+				fmt.Printf("Fixed %d violation(s) and wrote to \"%s\"\n", len(fixes), absolutePath)
+			} else {
+				// stdout is carrying the export, so the report can't go there
+				printPolicyViolations(remaining, true)
+				exitOnPolicyViolations(remaining)
+				return
+			}
+		}
+
+		printPolicyViolations(remaining, false)
+		exitOnPolicyViolations(remaining)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyCheckCmd)
+
+	policyCheckCmd.Flags().StringVarP(&PolicyPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+	policyCheckCmd.Flags().StringVar(&PolicyFormat, "format", "text", "Output format: text or json")
+	policyCheckCmd.Flags().StringVar(&PolicyFailOn, "fail-on", "error", "Exit with status 1 if violations of at least this severity remain: error, warning, info or none")
+	policyCheckCmd.Flags().BoolVar(&PolicyFix, "fix", false, "Fix violations by clamping values to the allowed range, and write the fixed export")
+
+	policyCheckCmd.Flags().BoolVarP(&PolicyConsole, "console", "c", false, "Write fixed export to stdout")
+	policyCheckCmd.Flags().StringVarP(&PolicyOutput, "out", "o", "", "Write fixed export to the specified file (default: original filename with '_policy' before file extension)")
+	policyCheckCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
+
+func printPolicyViolations(violations []util.PolicyViolation, toStderr bool) {
+	out := os.Stdout
+	if toStderr {
+		out = os.Stderr
+	}
+
+	if PolicyFormat == "json" && !toStderr {
+		if violations == nil {
+			violations = []util.PolicyViolation{}
+		}
+		printJson(violations)
+		return
+	}
+
+	for _, v := range violations {
+		fmt.Fprintf(out, "%-8s %-24s %s: %s\n", v.Severity, v.RuleID, v.Key, v.Message)
+	}
+	fmt.Fprintf(out, "%d policy violation(s) found\n", len(violations))
+}
+
+func exitOnPolicyViolations(violations []util.PolicyViolation) {
+	for _, v := range violations {
+		if v.Severity.AtLeast(util.Severity(PolicyFailOn)) {
+			os.Exit(1)
+		}
+	}
+}
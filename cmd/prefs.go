@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var (
+	PrefsPassword  string
+	PrefsConsole   bool
+	PrefsOutput    string
+	PrefsForce     bool
+	PrefsKnown     bool
+	PrefsUnknown   bool
+	PrefsVersion   string
+	PrefsChanged   bool
+	PrefsNoDefault bool
+	PrefsGroup     string
+)
+
+// prefsCmd represents the prefs command
+var prefsCmd = &cobra.Command{
+	Use:   "prefs",
+	Short: "View and edit individual preferences in a settings export",
+	Long: `View and edit individual preferences in a settings export.
+Encrypted exports are decrypted in memory and re-encrypted with the same password when they're modified.
+
+Examples:
+aaps-export-tool prefs list export.json
+aaps-export-tool prefs list export.json --unknown
+aaps-export-tool prefs list export.json --changed
+aaps-export-tool prefs reset-defaults export.json --group aps
+aaps-export-tool prefs describe openapsmb_max_iob
+aaps-export-tool prefs get export.json language
+aaps-export-tool prefs set export.json language=en units=mmol
+aaps-export-tool prefs unset export.json language --out "export-edited.json"`,
+}
+
+var prefsListCmd = &cobra.Command{
+	Use:   "list <file>",
+	Short: "Lists all preferences in an export",
+	Long: `Lists all preferences in an export.
+With --known or --unknown, only the preferences which are (or aren't) in the catalog of known AAPS preferences for
+the AAPS version of the export are listed.
+
+With --changed, only the preferences which differ from their default value for the AAPS version of the export are
+listed, grouped by category. Preferences without a known default value, such as unknown preferences and the local
+profiles, can't be compared and are only listed with --no-default, in a section of their own.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], PrefsPassword)
+		prefs := export.Preferences()
+		version := util.ExportVersion(export.Data)
+
+		if PrefsNoDefault && !PrefsChanged {
+			exitWithError("--no-default can only be used with --changed")
+		}
+		if PrefsChanged {
+			listChangedPreferences(prefs, version, PrefsNoDefault)
+			return
+		}
+
+		for _, key := range util.PreferenceKeys(prefs) {
+			known := util.LookupPreference(key, version) != nil
+			if PrefsKnown && !known || PrefsUnknown && known {
+				continue
+			}
+
+			value, _ := util.GetPreference(prefs, key)
+			fmt.Printf("%s=%s\n", key, value)
+		}
+	},
+}
+
+var prefsResetDefaultsCmd = &cobra.Command{
+	Use:   "reset-defaults <file> --group <group>",
+	Short: "Resets a group of preferences to their default values",
+	Long: `Resets the preferences of a group to their default values for the AAPS version of the export.
+Preferences without a known default value are left unchanged.
+
+Groups:
+` + groupsHelp(),
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		group := util.FindPreferenceGroup(util.PreferenceGroups, PrefsGroup)
+		if group == nil {
+			exitWithError(fmt.Sprintf("Unknown preference group \"%s\"", PrefsGroup))
+		}
+
+		export, data := loadExport(args[0], PrefsPassword)
+		prefs, changes, skipped := util.ResetPreferencesToDefaults(export.Preferences(), group, util.ExportVersion(export.Data))
+
+		if core.Verbose {
+			for _, change := range changes {
+				log.Println(change)
+			}
+			for _, key := range skipped {
+				log.Printf("Skipped %s, it has no known default value", key)
+			}
+		}
+
+		export.SetPreferences(prefs)
+		writeExport(export, data, args[0], PrefsOutput, PrefsConsole, "", fmt.Sprintf("Reset %d preference(s) of group %s", len(changes), group.Name))
+	},
+}
+
+var prefsDescribeCmd = &cobra.Command{
+	Use:   "describe <key>",
+	Short: "Describes a preference from the catalog of known AAPS preferences",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pref := util.LookupPreference(args[0], PrefsVersion)
+		if pref == nil {
+			exitWithError(fmt.Sprintf("Preference \"%s\" is not a known preference", args[0]))
+		}
+
+		fmt.Printf("Key:         %s\n", args[0])
+		if pref.Key != args[0] {
+			fmt.Printf("Pattern:     %s\n", pref.Key)
+		}
+		fmt.Printf("Description: %s\n", pref.Description)
+		fmt.Printf("Type:        %s\n", pref.Type)
+		if pref.Units != "" {
+			fmt.Printf("Units:       %s\n", pref.Units)
+		}
+		if pref.Default != nil {
+			fmt.Printf("Default:     %q\n", *pref.Default)
+		}
+		if pref.Min != nil || pref.Max != nil {
+			fmt.Printf("Range:       %s to %s\n", formatBound(pref.Min), formatBound(pref.Max))
+		}
+		if len(pref.Allowed) > 0 {
+			fmt.Printf("Allowed:     %s\n", strings.Join(pref.Allowed, ", "))
+		}
+		if pref.Since != "" {
+			fmt.Printf("Since:       AAPS %s\n", pref.Since)
+		}
+		if pref.Removed != "" {
+			fmt.Printf("Removed:     AAPS %s\n", pref.Removed)
+		}
+	},
+}
+
+var prefsGetCmd = &cobra.Command{
+	Use:   "get <file> <key>",
+	Short: "Prints the value of a preference",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], PrefsPassword)
+
+		value, ok := util.GetPreference(export.Preferences(), args[1])
+		if !ok {
+			exitWithError(fmt.Sprintf("Preference \"%s\" does not exist", args[1]))
+		}
+		fmt.Println(value)
+	},
+}
+
+var prefsSetCmd = &cobra.Command{
+	Use:   "set <file> <key=value>...",
+	Short: "Sets the value of one or more preferences",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], PrefsPassword)
+		prefs := export.Preferences()
+		version := util.ExportVersion(export.Data)
+
+		for _, arg := range args[1:] {
+			key, value, found := strings.Cut(arg, "=")
+			if !found || key == "" {
+				exitWithError(fmt.Sprintf("Invalid preference \"%s\", expected the format key=value", arg))
+			}
+
+			if pref := util.LookupPreference(key, version); pref != nil && !PrefsForce {
+				if err := pref.Validate(value); err != nil {
+					exitWithError(fmt.Sprintf("Invalid value for preference \"%s\": %s", key, err))
+				}
+			}
+			prefs = util.SetPreference(prefs, key, value)
+		}
+
+		export.SetPreferences(prefs)
+		writeExport(export, data, args[0], PrefsOutput, PrefsConsole, "", fmt.Sprintf("Set %d preference(s)", len(args)-1))
+	},
+}
+
+var prefsUnsetCmd = &cobra.Command{
+	Use:   "unset <file> <key>...",
+	Short: "Removes one or more preferences",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], PrefsPassword)
+		prefs := export.Preferences()
+
+		for _, key := range args[1:] {
+			prefs = util.DeletePreference(prefs, key)
+		}
+
+		export.SetPreferences(prefs)
+		writeExport(export, data, args[0], PrefsOutput, PrefsConsole, "", fmt.Sprintf("Removed %d preference(s)", len(args)-1))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(prefsCmd)
+	prefsCmd.AddCommand(prefsListCmd, prefsGetCmd, prefsSetCmd, prefsUnsetCmd, prefsDescribeCmd, prefsResetDefaultsCmd)
+
+	prefsCmd.PersistentFlags().StringVarP(&PrefsPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	for _, c := range []*cobra.Command{prefsSetCmd, prefsUnsetCmd, prefsResetDefaultsCmd} {
+		c.Flags().BoolVarP(&PrefsConsole, "console", "c", false, "Write export to stdout")
+		c.Flags().StringVarP(&PrefsOutput, "out", "o", "", "Write output to the specified file (default: original file)")
+		c.MarkFlagsMutuallyExclusive("console", "out")
+	}
+
+	prefsListCmd.Flags().BoolVar(&PrefsKnown, "known", false, "Only list preferences which are in the catalog of known preferences")
+	prefsListCmd.Flags().BoolVar(&PrefsUnknown, "unknown", false, "Only list preferences which are not in the catalog of known preferences")
+	prefsListCmd.Flags().BoolVar(&PrefsChanged, "changed", false, "Only list preferences which differ from their default value, grouped by category")
+	prefsListCmd.Flags().BoolVar(&PrefsNoDefault, "no-default", false, "With --changed, also list preferences without a known default value")
+	prefsListCmd.MarkFlagsMutuallyExclusive("known", "unknown", "changed")
+
+	prefsResetDefaultsCmd.Flags().StringVarP(&PrefsGroup, "group", "g", "", "Preference group to reset")
+	prefsResetDefaultsCmd.MarkFlagRequired("group")
+
+	prefsSetCmd.Flags().BoolVarP(&PrefsForce, "force", "f", false, "Don't validate the values of known preferences before setting them")
+
+	prefsDescribeCmd.Flags().StringVar(&PrefsVersion, "aaps-version", "", "Describe the preference as of the given AAPS version")
+}
+
+// listChangedPreferences prints the preferences which differ from their default value, grouped by PreferenceGroups.
+// The preferences without a known default value follow in their own section when noDefault is set.
+func listChangedPreferences(prefs []byte, version string, noDefault bool) {
+	changed, withoutDefault := util.ChangedPreferences(prefs, version)
+
+	grouped := map[string][]string{}
+	for _, key := range changed {
+		value, _ := util.GetPreference(prefs, key)
+		line := fmt.Sprintf("%s=%s (default: %s)", key, value, *util.LookupPreference(key, version).Default)
+
+		name := "other"
+		if group := util.GroupOfPreference(util.PreferenceGroups, key); group != nil {
+			name = group.Name
+		}
+		grouped[name] = append(grouped[name], line)
+	}
+	if noDefault {
+		for _, key := range withoutDefault {
+			value, _ := util.GetPreference(prefs, key)
+			grouped["no known default"] = append(grouped["no known default"], fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+
+	names := make([]string, 0, len(util.PreferenceGroups)+2)
+	for _, group := range util.PreferenceGroups {
+		names = append(names, group.Name)
+	}
+	names = append(names, "other", "no known default")
+
+	first := true
+	for _, name := range names {
+		lines := grouped[name]
+		if len(lines) == 0 {
+			continue
+		}
+		if !first {
+			fmt.Println()
+		}
+		first = false
+
+		fmt.Printf("[%s]\n", name)
+		for _, line := range lines {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+}
+
+func formatBound(bound *float64) string {
+	if bound == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*bound, 'f', -1, 64)
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	PresetsPassword string
+	PresetsConsole  bool
+	PresetsOutput   string
+	PresetsText     string
+	PresetsCarbs    int
+	PresetsFrom     string
+	PresetsTo       string
+	PresetsTarget   float64
+	PresetsDuration int
+	PresetsUnits    string
+)
+
+// values of the QuickWizard option flags
+var quickWizardOptionValues = map[string]int{
+	"yes":      util.QuickWizardYes,
+	"no":       util.QuickWizardNo,
+	"positive": util.QuickWizardPositiveOnly,
+	"negative": util.QuickWizardNegativeOnly,
+}
+
+// presetsCmd represents the presets command
+var presetsCmd = &cobra.Command{
+	Use:   "presets",
+	Short: "View and edit QuickWizard buttons and temporary target presets",
+	Long: `View and edit the QuickWizard buttons, which AAPS stores as JSON inside the QuickWizard preference, and the
+eating soon, activity and hypo temporary target presets.
+
+QuickWizard buttons are numbered from 1 in the order AAPS shows them, as printed by 'presets list'. The time windows
+of the buttons must not overlap. Both ends of a window are included, so a button offered until 08:59 can be followed by
+one offered from 09:00.
+
+Examples:
+aaps-export-tool presets list export.json
+aaps-export-tool presets add export.json --text Breakfast --carbs 40 --from 06:00 --to 10:00 --cob yes
+aaps-export-tool presets edit export.json 1 --carbs 45
+aaps-export-tool presets remove export.json 1
+aaps-export-tool presets set-target export.json eatingsoon --target 5 --units mmol --duration 45`,
+}
+
+var presetsListCmd = &cobra.Command{
+	Use:   "list <file>",
+	Short: "Lists the QuickWizard buttons and temporary target presets",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], PresetsPassword)
+		prefs := export.Preferences()
+		entries := loadQuickWizardEntries(prefs)
+
+		fmt.Println("QuickWizard:")
+		if len(entries) == 0 {
+			fmt.Println("  none")
+		}
+		for i := range entries {
+			fmt.Printf("%3d %s\n", i+1, formatQuickWizardEntry(&entries[i]))
+		}
+		for _, err := range util.QuickWizardOverlaps(entries) {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		}
+
+		presets, err := util.TempTargetPresets(prefs, util.ExportVersion(export.Data))
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		units := util.GlucoseUnits(prefs)
+		fmt.Println()
+		fmt.Println("Temporary targets:")
+		for _, preset := range presets {
+			fmt.Printf("  %-10s %6s %s %5d min\n", preset.Name, strconv.FormatFloat(preset.Target, 'f', -1, 64), units, preset.Duration)
+		}
+	},
+}
+
+var presetsAddCmd = &cobra.Command{
+	Use:   "add <file> --text <text> --carbs <g>",
+	Short: "Adds a QuickWizard button",
+	Long: `Adds a QuickWizard button after the existing ones. Without --from and --to, the button is offered all day.
+Options which aren't given use the defaults: BG, bolus IOB and basal IOB are included, COB, trend, superbolus and
+temp target are not.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], PresetsPassword)
+		entries := loadQuickWizardEntries(export.Preferences())
+
+		entry := util.NewQuickWizardEntry()
+		applyQuickWizardFlags(cmd, &entry)
+		entries = append(entries, entry)
+		validateQuickWizardEntry(entries, len(entries)-1)
+
+		export.SetPreferences(util.SetQuickWizardEntries(export.Preferences(), entries))
+		writeExport(export, data, args[0], PresetsOutput, PresetsConsole, "_presets", fmt.Sprintf("Added QuickWizard \"%s\"", entry.Text))
+	},
+}
+
+var presetsEditCmd = &cobra.Command{
+	Use:   "edit <file> <n>",
+	Short: "Edits a QuickWizard button",
+	Long:  `Edits a QuickWizard button, changing only the given values.`,
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], PresetsPassword)
+		entries := loadQuickWizardEntries(export.Preferences())
+		i := quickWizardIndex(entries, args[1])
+
+		applyQuickWizardFlags(cmd, &entries[i])
+		validateQuickWizardEntry(entries, i)
+
+		export.SetPreferences(util.SetQuickWizardEntries(export.Preferences(), entries))
+		writeExport(export, data, args[0], PresetsOutput, PresetsConsole, "_presets", fmt.Sprintf("Edited QuickWizard \"%s\"", entries[i].Text))
+	},
+}
+
+var presetsRemoveCmd = &cobra.Command{
+	Use:   "remove <file> <n>",
+	Short: "Removes a QuickWizard button",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], PresetsPassword)
+		entries := loadQuickWizardEntries(export.Preferences())
+		i := quickWizardIndex(entries, args[1])
+		text := entries[i].Text
+
+		entries = append(entries[:i], entries[i+1:]...)
+		export.SetPreferences(util.SetQuickWizardEntries(export.Preferences(), entries))
+		writeExport(export, data, args[0], PresetsOutput, PresetsConsole, "_presets", fmt.Sprintf("Removed QuickWizard \"%s\"", text))
+	},
+}
+
+var presetsSetTargetCmd = &cobra.Command{
+	Use:   "set-target <file> <eatingsoon|activity|hypo>",
+	Short: "Sets the target and duration of a temporary target preset",
+	Long: `Sets the target and duration of a temporary target preset. AAPS stores the target in the glucose units of the
+app, so a target given in other --units is converted.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArg),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !containsName(util.TempTargetPresetNames, args[1]) {
+			return fmt.Errorf("unknown preset \"%s\", expected one of %s", args[1], strings.Join(util.TempTargetPresetNames, ", "))
+		}
+		if !cmd.Flags().Changed("target") && !cmd.Flags().Changed("duration") {
+			return fmt.Errorf("--target or --duration is required")
+		}
+		if PresetsUnits != "" && PresetsUnits != util.UnitsMgdl && PresetsUnits != util.UnitsMmol {
+			return fmt.Errorf("unknown units \"%s\", expected %s or %s", PresetsUnits, util.UnitsMgdl, util.UnitsMmol)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], PresetsPassword)
+		prefs := export.Preferences()
+		version := util.ExportVersion(export.Data)
+		units := util.GlucoseUnits(prefs)
+
+		presets, err := util.TempTargetPresets(prefs, version)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		var preset util.TempTargetPreset
+		for _, p := range presets {
+			if p.Name == args[1] {
+				preset = p
+			}
+		}
+
+		if cmd.Flags().Changed("target") {
+			from := PresetsUnits
+			if from == "" {
+				from = units
+			}
+			preset.Target = util.ConvertGlucose(PresetsTarget, from, units)
+		}
+		if cmd.Flags().Changed("duration") {
+			preset.Duration = PresetsDuration
+		}
+
+		prefs, err = util.SetTempTargetPreset(prefs, preset, version)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		export.SetPreferences(prefs)
+		status := fmt.Sprintf("Set %s temporary target to %s %s for %d min", preset.Name, strconv.FormatFloat(preset.Target, 'f', -1, 64), units, preset.Duration)
+		writeExport(export, data, args[0], PresetsOutput, PresetsConsole, "_presets", status)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(presetsCmd)
+	presetsCmd.AddCommand(presetsListCmd, presetsAddCmd, presetsEditCmd, presetsRemoveCmd, presetsSetTargetCmd)
+
+	presetsCmd.PersistentFlags().StringVarP(&PresetsPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	for _, c := range []*cobra.Command{presetsAddCmd, presetsEditCmd, presetsRemoveCmd, presetsSetTargetCmd} {
+		c.Flags().BoolVarP(&PresetsConsole, "console", "c", false, "Write export to stdout")
+		c.Flags().StringVarP(&PresetsOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_presets' before file extension)")
+		c.MarkFlagsMutuallyExclusive("console", "out")
+	}
+
+	for _, c := range []*cobra.Command{presetsAddCmd, presetsEditCmd} {
+		c.Flags().StringVar(&PresetsText, "text", "", "Text of the button")
+		c.Flags().IntVar(&PresetsCarbs, "carbs", 0, "Carbs in grams")
+		c.Flags().StringVar(&PresetsFrom, "from", "", "Start of the time window the button is offered in, as HH:MM")
+		c.Flags().StringVar(&PresetsTo, "to", "", "End of the time window the button is offered in, as HH:MM")
+		for _, option := range util.QuickWizardOptions {
+			values := "yes or no"
+			if option.Signed {
+				values = "yes, no, positive or negative"
+			}
+			c.Flags().String(option.Name, "", fmt.Sprintf("Include %s in the calculation: %s", option.Description, values))
+		}
+	}
+	presetsAddCmd.MarkFlagRequired("text")
+	presetsAddCmd.MarkFlagRequired("carbs")
+
+	presetsSetTargetCmd.Flags().Float64Var(&PresetsTarget, "target", 0, "Target glucose")
+	presetsSetTargetCmd.Flags().IntVar(&PresetsDuration, "duration", 0, "Duration in minutes")
+	presetsSetTargetCmd.Flags().StringVar(&PresetsUnits, "units", "", "Units of --target, mg/dl or mmol (default: the units of the app)")
+}
+
+// applyQuickWizardFlags sets the values of a QuickWizard button which were given as flags
+func applyQuickWizardFlags(cmd *cobra.Command, entry *util.QuickWizardEntry) {
+	flags := cmd.Flags()
+	if flags.Changed("text") {
+		entry.SetText(PresetsText)
+	}
+	if flags.Changed("carbs") {
+		entry.SetCarbs(PresetsCarbs)
+	}
+
+	from, to := entry.ValidFrom, entry.ValidTo
+	var err error
+	if flags.Changed("from") {
+		if from, err = util.ParseTimeOfDay(PresetsFrom); err != nil {
+			exitWithError(fmt.Sprintf("--from: %s", err))
+		}
+	}
+	if flags.Changed("to") {
+		if to, err = util.ParseTimeOfDay(PresetsTo); err != nil {
+			exitWithError(fmt.Sprintf("--to: %s", err))
+		}
+	}
+	entry.SetWindow(from, to)
+
+	for _, option := range util.QuickWizardOptions {
+		if !flags.Changed(option.Name) {
+			continue
+		}
+		value, ok := quickWizardOptionValues[flags.Lookup(option.Name).Value.String()]
+		if !ok || !option.Signed && value > util.QuickWizardNo {
+			exitWithError(fmt.Sprintf("Invalid value \"%s\" of --%s", flags.Lookup(option.Name).Value.String(), option.Name))
+		}
+		entry.SetOption(option.Key, value)
+	}
+}
+
+// validateQuickWizardEntry validates the added or edited button at the index. Problems of the other buttons are left
+// alone, 'presets list' warns about them.
+func validateQuickWizardEntry(entries []util.QuickWizardEntry, i int) {
+	if err := entries[i].Validate(); err != nil {
+		exitWithError(fmt.Sprintf("Invalid QuickWizard: %s", err))
+	}
+	if errs := util.QuickWizardOverlapsOf(entries, i); len(errs) > 0 {
+		exitWithError(fmt.Sprintf("Invalid QuickWizard: %s", errs[0]))
+	}
+}
+
+func formatQuickWizardEntry(entry *util.QuickWizardEntry) string {
+	var options []string
+	for _, option := range util.QuickWizardOptions {
+		switch entry.Option(option.Key) {
+		case util.QuickWizardYes:
+			options = append(options, option.Description)
+		case util.QuickWizardPositiveOnly:
+			options = append(options, option.Description+" (positive only)")
+		case util.QuickWizardNegativeOnly:
+			options = append(options, option.Description+" (negative only)")
+		}
+	}
+	return fmt.Sprintf("%-20s %4d g  %s-%s  %s", entry.Text, entry.Carbs,
+		util.FormatTimeOfDay(entry.ValidFrom), util.FormatTimeOfDay(entry.ValidTo), strings.Join(options, ", "))
+}
+
+// quickWizardIndex parses the number of a QuickWizard button as shown by 'presets list' into an index of entries
+func quickWizardIndex(entries []util.QuickWizardEntry, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(entries) {
+		exitWithError(fmt.Sprintf("Invalid QuickWizard number \"%s\", the export has %d QuickWizard button(s)", arg, len(entries)))
+	}
+	return n - 1
+}
+
+func loadQuickWizardEntries(prefs []byte) []util.QuickWizardEntry {
+	entries, err := util.QuickWizardEntries(prefs)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	return entries
+}
@@ -0,0 +1,430 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"encoding/json"
+	"fmt"
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ProfilePassword  string
+	ProfileNames     []string
+	ProfileOutput    string
+	ProfileTimezone  string
+	ProfileConsole   bool
+	ProfileReplace   bool
+	ProfileActive    string
+	ProfileName      string
+	ProfileYes       bool
+	ProfileOnly      []string
+	ProfileMaxChange float64
+	ProfileCircadian bool
+	ProfileChart     bool
+	ProfileSvg       string
+	ProfileCompare   string
+)
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "View and convert the local profiles in a settings export",
+	Long: `View and convert the local profiles (basal, ISF, IC, targets, DIA and units) of the local profile plugin,
+which are stored in the LocalProfile_* preferences of a settings export.
+
+Examples:
+aaps-export-tool profile list export.json
+aaps-export-tool profile show export.json --name Default
+aaps-export-tool profile export export.json --out nightscout-profile.json
+aaps-export-tool profile import nightscout-profile.json export.json --active Default
+aaps-export-tool profile autotune-apply autotune/profile.json export.json --profile Default --max-change 20`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list <file>",
+	Short: "Lists the names of the local profiles",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, profile := range loadLocalProfiles(args[0]) {
+			fmt.Println(profile.Name)
+		}
+	},
+}
+
+var profileShowCmd = &cobra.Command{
+	Use:   "show <file>",
+	Short: "Shows local profiles as a table or chart",
+	Long: `Shows the schedules of local profiles as a table, with the values in effect at each time a schedule changes.
+All profiles are shown unless --name is given.
+
+With --chart, the basal, ISF, IC and target schedules are drawn as 24 hour charts instead, with all shown profiles in
+the same charts for comparison. --svg writes the same charts to a standalone SVG file, which can be printed.
+--compare adds the profiles with the same names from a second export, such as an older export, to the charts.
+ISF and targets are shown in the units of the first profile.
+
+Examples:
+aaps-export-tool profile show export.json --chart
+aaps-export-tool profile show export.json --name Default,Sport --svg profiles.svg
+aaps-export-tool profile show export.json --name Default --compare old-export.json --chart`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if ProfileCompare != "" && !ProfileChart && ProfileSvg == "" {
+			return fmt.Errorf("--compare requires --chart or --svg")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles := selectLocalProfiles(loadLocalProfiles(args[0]))
+		if !ProfileChart && ProfileSvg == "" {
+			for i := range profiles {
+				if i > 0 {
+					fmt.Println()
+				}
+				printProfileTable(&profiles[i])
+			}
+			return
+		}
+
+		labels := util.LocalProfileNames(profiles)
+		if ProfileCompare != "" {
+			for i := range labels {
+				labels[i] += " (" + filepath.Base(args[0]) + ")"
+			}
+			compared := selectLocalProfiles(loadLocalProfiles(ProfileCompare))
+			for _, profile := range compared {
+				labels = append(labels, profile.Name+" ("+filepath.Base(ProfileCompare)+")")
+			}
+			profiles = append(profiles, compared...)
+		}
+
+		legend := make([]string, len(profiles))
+		for i := range profiles {
+			legend[i] = fmt.Sprintf("%s: %s", labels[i], formatProfileSummary(&profiles[i]))
+		}
+		charts := util.ProfileCharts(profiles, labels)
+
+		if ProfileChart {
+			for i, line := range legend {
+				fmt.Printf("%c %s\n", util.ChartGlyphs[i%len(util.ChartGlyphs)], line)
+			}
+			for i := range charts {
+				fmt.Println()
+				fmt.Print(charts[i].ASCII(8))
+			}
+		}
+
+		if ProfileSvg != "" {
+			title := "Profile " + profiles[0].Name
+			if len(profiles) > 1 {
+				title = "Profiles " + strings.Join(labels, ", ")
+			}
+			if core.DryRun {
+				fmt.Printf("Would write %d chart(s) of %d profile(s) as SVG\n", len(charts), len(profiles))
+			}
+			wroteFile, err := writeOutput(util.ProfileChartsSvg(title, charts, legend), ProfileSvg, false)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			if wroteFile {
+				absolutePath, _ := filepath.Abs(ProfileSvg)
+				fmt.Fprintf(os.Stderr, "Wrote charts to \"%s\" successfully\n", absolutePath)
+			}
+		}
+	},
+}
+
+var profileExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Exports local profiles as a Nightscout profile document",
+	Long: `Exports local profiles as a Nightscout profile document, with each profile in the 'store' and the first
+profile as the default profile. All profiles are exported unless --name is given.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], ProfilePassword)
+		profiles, err := util.LocalProfiles(export.Preferences())
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		ns := util.ToNightscout(selectLocalProfiles(profiles), ProfileTimezone)
+		ns.StartDate = gjson.GetBytes(export.Data, "metadata.created_at").String()
+
+		outputData, err := json.MarshalIndent(ns, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		outputData = append(outputData, '\n')
+
+		if core.DryRun {
+			names := make([]string, 0, len(ns.Store))
+			for name := range ns.Store {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Printf("Would export %d profile(s): %s\n", len(names), strings.Join(names, ", "))
+		}
+		wroteFile, err := writeOutput(outputData, ProfileOutput, false)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(ProfileOutput)
+			fmt.Printf("Exported %d profile(s) to \"%s\" successfully\n", len(ns.Store), absolutePath)
+		}
+	},
+}
+
+var profileImportCmd = &cobra.Command{
+	Use:   "import <nightscout-profile.json> <file>",
+	Short: "Imports Nightscout profiles into the local profiles of an export",
+	Long: `Imports the profiles of a Nightscout profile document (or an array of documents, as returned by the Nightscout
+API) into the local profiles of an export. Local profiles with the same name are replaced, and new profiles are added
+after the existing ones. With --replace, all existing local profiles are removed first. All profiles are imported
+unless --name is given.
+
+The time blocks, units, DIA and values of every imported profile are validated before anything is written.
+
+AAPS selects the first local profile in the local profile plugin. --active moves the given profile to the first
+position. Activate it with a profile switch in AAPS after importing the settings.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	Run: func(cmd *cobra.Command, args []string) {
+		nsData, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		documents, err := util.ParseNightscoutProfiles(nsData)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid Nightscout profile: %s", err))
+		}
+		imported, err := util.FromNightscout(documents)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid Nightscout profile: %s", err))
+		}
+		imported = selectLocalProfiles(imported)
+
+		export, data := loadExport(args[1], ProfilePassword)
+		var profiles []util.LocalProfile
+		if !ProfileReplace {
+			profiles, err = util.LocalProfiles(export.Preferences())
+			if err != nil {
+				exitWithError(err.Error())
+			}
+		}
+		profiles, err = util.ImportLocalProfiles(profiles, imported, ProfileActive)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Cannot import the profiles: %s", err))
+		}
+
+		export.SetPreferences(util.SetLocalProfiles(export.Preferences(), profiles))
+		writeExport(export, data, args[1], ProfileOutput, ProfileConsole, "_profiles", fmt.Sprintf("Imported %d profile(s)", len(imported)))
+	},
+}
+
+var profileAutotuneApplyCmd = &cobra.Command{
+	Use:   "autotune-apply <autotune.json> <file> --profile <name>",
+	Short: "Applies the recommendations of oref0 autotune to a local profile",
+	Long: `Applies the basal, ISF and carb ratio recommendations of an oref0 autotune profile.json to a local profile.
+The old and new value of every changed time block is shown, and the changes to apply are selected interactively,
+unless --yes is given.
+
+Autotune recommends a single ISF and carb ratio, which replace the whole schedule. With --circadian, the existing
+schedule is scaled instead, so its average matches the recommendation. --max-change limits the change of every block
+to a percentage of its old value.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), pathArgs(2)),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		for _, schedule := range ProfileOnly {
+			switch schedule {
+			case "basal", "isf", "ic":
+			default:
+				return fmt.Errorf("unknown schedule \"%s\" in --only, expected basal, isf or ic", schedule)
+			}
+		}
+		if ProfileMaxChange < 0 {
+			return fmt.Errorf("--max-change must not be negative")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		autotuneData, err := readInput(args[0])
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		result, err := util.ParseAutotuneProfile(autotuneData)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid autotune profile: %s", err))
+		}
+
+		export, data := loadExport(args[1], ProfilePassword)
+		profiles, err := util.LocalProfiles(export.Preferences())
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		profile := util.FindLocalProfile(profiles, ProfileName)
+		if profile == nil {
+			exitWithError(fmt.Sprintf("Profile \"%s\" does not exist, the profiles are: %s", ProfileName, strings.Join(util.LocalProfileNames(profiles), ", ")))
+		}
+
+		var changes []util.ProfileChange
+		for _, change := range util.AutotuneChanges(profile, result, ProfileCircadian, ProfileMaxChange) {
+			if len(ProfileOnly) == 0 || containsName(ProfileOnly, change.Schedule) {
+				changes = append(changes, change)
+			}
+		}
+		if len(changes) == 0 {
+			fmt.Fprintln(os.Stderr, "Autotune recommends no changes")
+			return
+		}
+
+		labels := make([]string, len(changes))
+		for i, change := range changes {
+			labels[i] = formatProfileChange(&change)
+		}
+
+		accepted := changes
+		if !ProfileYes {
+			if readStdin {
+				exitWithError("Changes can't be selected when reading from stdin, use --yes")
+			}
+			var selected []int
+			prompt := &survey.MultiSelect{
+				Message:  "Select the changes to apply:",
+				Options:  labels,
+				Default:  labels,
+				PageSize: 24,
+			}
+			if err := survey.AskOne(prompt, &selected, survey.WithStdio(os.Stdin, os.Stderr, os.Stderr)); err != nil {
+				exitWithError(err.Error())
+			}
+			accepted = nil
+			for _, i := range selected {
+				accepted = append(accepted, changes[i])
+			}
+		} else {
+			for _, label := range labels {
+				fmt.Fprintln(os.Stderr, label)
+			}
+		}
+
+		util.ApplyProfileChanges(profile, accepted)
+		if err := profile.Validate(); err != nil {
+			exitWithError(err.Error())
+		}
+
+		export.SetPreferences(util.SetLocalProfiles(export.Preferences(), profiles))
+		writeExport(export, data, args[1], ProfileOutput, ProfileConsole, "_profiles", fmt.Sprintf("Applied %d of %d change(s) to profile \"%s\"", len(accepted), len(changes), profile.Name))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileListCmd, profileShowCmd, profileExportCmd, profileImportCmd, profileAutotuneApplyCmd)
+
+	profileCmd.PersistentFlags().StringVarP(&ProfilePassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	for _, c := range []*cobra.Command{profileShowCmd, profileExportCmd, profileImportCmd} {
+		c.Flags().StringSliceVar(&ProfileNames, "name", []string{}, "Comma-separated name(s) of the profiles. May be specified multiple times")
+	}
+
+	profileExportCmd.Flags().StringVarP(&ProfileOutput, "out", "o", "", "Write the profile document to the specified file (default: stdout)")
+	profileImportCmd.Flags().BoolVar(&ProfileReplace, "replace", false, "Remove all existing local profiles before importing")
+	profileImportCmd.Flags().StringVar(&ProfileActive, "active", "", "Move the given profile to the first position, where AAPS selects it")
+	for _, c := range []*cobra.Command{profileImportCmd, profileAutotuneApplyCmd} {
+		c.Flags().BoolVarP(&ProfileConsole, "console", "c", false, "Write export to stdout")
+		c.Flags().StringVarP(&ProfileOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_profiles' before file extension)")
+		c.MarkFlagsMutuallyExclusive("console", "out")
+	}
+
+	profileAutotuneApplyCmd.Flags().StringVar(&ProfileName, "profile", "", "Name of the local profile to change")
+	profileAutotuneApplyCmd.MarkFlagRequired("profile")
+	profileAutotuneApplyCmd.Flags().BoolVarP(&ProfileYes, "yes", "y", false, "Apply all changes without asking")
+	profileAutotuneApplyCmd.Flags().StringSliceVar(&ProfileOnly, "only", []string{}, "Comma-separated schedule(s) to change: basal, isf or ic")
+	profileAutotuneApplyCmd.Flags().Float64Var(&ProfileMaxChange, "max-change", 0, "Limit the change of every block to this percentage of its old value")
+	profileAutotuneApplyCmd.Flags().BoolVar(&ProfileCircadian, "circadian", false, "Scale the existing ISF and carb ratio schedules instead of replacing them")
+
+	profileShowCmd.Flags().BoolVar(&ProfileChart, "chart", false, "Draw the schedules as charts instead of a table")
+	profileShowCmd.Flags().StringVar(&ProfileSvg, "svg", "", "Write the charts to the specified SVG file")
+	profileShowCmd.Flags().StringVar(&ProfileCompare, "compare", "", "Add the profiles of a second export to the charts")
+
+	profileExportCmd.Flags().StringVar(&ProfileTimezone, "timezone", "", "Timezone of the profiles, such as Europe/Berlin")
+}
+
+func loadLocalProfiles(path string) []util.LocalProfile {
+	export, _ := loadExport(path, ProfilePassword)
+	profiles, err := util.LocalProfiles(export.Preferences())
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	return profiles
+}
+
+// selectLocalProfiles returns the profiles named by --name in that order, or all profiles if it's not given
+func selectLocalProfiles(profiles []util.LocalProfile) []util.LocalProfile {
+	if len(ProfileNames) == 0 {
+		if len(profiles) == 0 {
+			exitWithError("The export has no local profiles")
+		}
+		return profiles
+	}
+
+	var selected []util.LocalProfile
+	for _, name := range ProfileNames {
+		profile := util.FindLocalProfile(profiles, name)
+		if profile == nil {
+			exitWithError(fmt.Sprintf("Profile \"%s\" does not exist, the profiles are: %s", name, strings.Join(util.LocalProfileNames(profiles), ", ")))
+		}
+		selected = append(selected, *profile)
+	}
+	return selected
+}
+
+func printProfileTable(profile *util.LocalProfile) {
+	fmt.Printf("Profile \"%s\" (%s)\n", profile.Name, formatProfileSummary(profile))
+
+	times := util.ScheduleTimes(profile.Basal, profile.ISF, profile.IC, profile.TargetLow, profile.TargetHigh)
+
+	fmt.Printf("%-6s %8s %8s %8s %13s\n", "Time", "Basal", "ISF", "IC", "Target")
+	for _, t := range times {
+		target := formatProfileValue(profile.TargetLow.ValueAt(t)) + "-" + formatProfileValue(profile.TargetHigh.ValueAt(t))
+		fmt.Printf("%-6s %8s %8s %8s %13s\n",
+			util.FormatTimeOfDay(t),
+			formatProfileValue(profile.Basal.ValueAt(t)),
+			formatProfileValue(profile.ISF.ValueAt(t)),
+			formatProfileValue(profile.IC.ValueAt(t)),
+			target,
+		)
+	}
+}
+
+func formatProfileSummary(profile *util.LocalProfile) string {
+	return fmt.Sprintf("%s, DIA %s h, daily basal %.2f U", profile.Units(), formatProfileValue(profile.Dia), profile.Basal.Total())
+}
+
+func formatProfileChange(change *util.ProfileChange) string {
+	label := fmt.Sprintf("%-5s %s: %s -> %s (%+.0f%%)", change.Schedule, util.FormatTimeOfDay(change.TimeAsSeconds),
+		formatProfileValue(change.Old), formatProfileValue(change.New), change.Percent())
+	if change.Capped {
+		label += " capped"
+	}
+	return label
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func formatProfileValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -1,16 +1,16 @@
 package cmd
 
 import (
+	"aaps-export-tool/core"
 	"aaps-export-tool/util"
 	"fmt"
 	"github.com/spf13/cobra"
-	"io/ioutil"
-	"os"
 	"path/filepath"
 )
 
 var (
-	RehashOutput string
+	RehashConsole bool
+	RehashOutput  string
 )
 
 // rehashCmd represents the rehash command
@@ -21,28 +21,29 @@ var rehashCmd = &cobra.Command{
 This is useful when the export file has been modified manually and the file hash is no longer valid.`,
 	Args: pathArg,
 	Run: func(cmd *cobra.Command, args []string) {
-		data, err := ioutil.ReadFile(args[0])
+		data, err := readInput(args[0])
 		if err != nil {
-			panic(err)
-		}
-
-		outputPath := args[0]
-		if RehashOutput != "" {
-			outputPath = RehashOutput
+			exitWithError(err.Error())
 		}
 
 		outputData := util.CalculateFileHash(data)
 
-		err = os.WriteFile(outputPath, outputData, 0644)
-		if err != nil {
-			panic(err)
+		if core.DryRun {
+			reportDryRun(data, outputData, "")
 		}
 
-		absolutePath, _ := filepath.Abs(outputPath)
-		if RehashOutput != "" {
-			fmt.Printf("Recalculated file hash and wrote to \"%s\" successfully\n", absolutePath)
-		} else {
-			fmt.Println("File hash was recalculated successfully")
+		path := outputPath(args[0], RehashOutput, "")
+		wroteFile, err := writeOutput(outputData, path, RehashConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			if RehashOutput != "" {
+				fmt.Printf("Recalculated file hash and wrote to \"%s\" successfully\n", absolutePath)
+			} else {
+				fmt.Println("File hash was recalculated successfully")
+			}
 		}
 	},
 }
@@ -50,5 +51,7 @@ This is useful when the export file has been modified manually and the file hash
 func init() {
 	rootCmd.AddCommand(rehashCmd)
 
+	rehashCmd.Flags().BoolVarP(&RehashConsole, "console", "c", false, "Write output to stdout")
 	rehashCmd.Flags().StringVarP(&RehashOutput, "out", "o", "", "Write output to the specified file (default: original file)")
+	rehashCmd.MarkFlagsMutuallyExclusive("console", "out")
 }
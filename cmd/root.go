@@ -2,10 +2,17 @@ package cmd
 
 import (
 	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"bytes"
 	"errors"
+	"fmt"
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/tidwall/gjson"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -20,12 +27,62 @@ var rootCmd = &cobra.Command{
 	// Run: func(cmd *cobra.Command, args []string) { },
 }
 
+// stdinPath is the input path used to read an export from stdin instead of a file
+const stdinPath = "-"
+
+// PasswordEnv is the environment variable which is used as the password when no password flag is given
+const PasswordEnv = "AAPS_EXPORT_PASSWORD"
+
+// PasswordCommandEnv is the environment variable with a shell command which prints the password, such as a password
+// manager or agent. It's used when no password flag or PasswordEnv is given.
+const PasswordCommandEnv = "AAPS_EXPORT_PASSWORD_COMMAND"
+
+// dryRunChangesExitCode is the exit status of a dry run which would have changed the export
+const dryRunChangesExitCode = 2
+
+// dryRunSkippedOutput is set when a dry run skipped writing output, so the command exits with dryRunChangesExitCode
+var dryRunSkippedOutput bool
+
+// readStdin is set once the input was read from stdin, since the password prompt can't use it afterwards
+var readStdin bool
+
 var pathArg = func(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
 		return errors.New("requires an input file")
 	}
 
-	_, err := ioutil.ReadFile(args[0])
+	return checkInputPath(args[0])
+}
+
+// pathArgs validates that the first n positional arguments are readable input files
+func pathArgs(n int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < n {
+			return fmt.Errorf("requires %d input files", n)
+		}
+
+		stdinCount := 0
+		for _, path := range args[:n] {
+			if path == stdinPath {
+				stdinCount++
+			}
+			if err := checkInputPath(path); err != nil {
+				return err
+			}
+		}
+		if stdinCount > 1 {
+			return errors.New("only one input can be read from stdin")
+		}
+		return nil
+	}
+}
+
+func checkInputPath(path string) error {
+	if path == stdinPath {
+		return nil
+	}
+
+	_, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
@@ -39,6 +96,9 @@ func Execute() {
 	if err != nil {
 		os.Exit(1)
 	}
+	if dryRunSkippedOutput {
+		os.Exit(dryRunChangesExitCode)
+	}
 }
 
 func init() {
@@ -53,6 +113,182 @@ func init() {
 	//rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	rootCmd.PersistentFlags().BoolVarP(&core.Verbose, "verbose", "v", false, "Enable additional logging output")
+	rootCmd.PersistentFlags().BoolVar(&core.Canonical, "canonical", false, "Write decrypted exports in the canonical form of the normalize command")
+	rootCmd.PersistentFlags().BoolVarP(&core.DryRun, "dry-run", "n", false, fmt.Sprintf("Print the changes that would be made without writing anything (exits with status %d if there are changes)", dryRunChangesExitCode))
+}
+
+// readInput reads an export from the given path, or from stdin when the path is "-"
+func readInput(path string) ([]byte, error) {
+	if path == stdinPath {
+		readStdin = true
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// loadExport reads an export from the path, or from stdin when the path is "-", and prepares it for editing.
+// The password is only needed when the export is encrypted, see getPassword.
+func loadExport(path string, password string) (*util.Export, []byte) {
+	data, err := readInput(path)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	export, err := util.LoadExport(data, func() (string, error) {
+		return getPassword(password)
+	})
+	if err != nil {
+		exitWithError(fmt.Sprintf("Cannot load \"%s\": %s", path, err))
+	}
+	return export, data
+}
+
+// writeExport writes an edited export in the storage format of the input data, see outputPath and writeOutput for
+// where it's written. In a dry run, the changed preferences are reported instead. The status is printed when a file
+// was written.
+func writeExport(export *util.Export, data []byte, input string, out string, console bool, suffix string, status string) {
+	outputData, err := export.Bytes()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if core.DryRun {
+		reportDryRun(data, outputData, export.Password)
+	}
+
+	path := outputPath(input, out, suffix)
+	wroteFile, err := writeOutput(outputData, path, console)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	if wroteFile {
+		absolutePath, _ := filepath.Abs(path)
+		fmt.Printf("%s and wrote to \"%s\" successfully\n", status, absolutePath)
+	}
+}
+
+// outputPath determines where a command writes its output. An explicit output path always wins, otherwise the
+// suffix is inserted before the extension of the input path (or the input file is overwritten if there's no suffix).
+// An empty path is returned for stdin input, meaning the output is written to stdout.
+func outputPath(input string, output string, suffix string) string {
+	if output != "" {
+		return output
+	}
+	if input == stdinPath {
+		return ""
+	}
+	if suffix == "" {
+		return input
+	}
+
+	ext := filepath.Ext(input)
+	return strings.TrimSuffix(input, ext) + suffix + ext
+}
+
+// writeOutput writes the data to stdout when console is set or the path is empty, otherwise to the file at path.
+// It returns true if a file was written, so status lines are only printed when stdout isn't carrying the export.
+// In a dry run nothing is written, see reportDryRunOutput.
+func writeOutput(data []byte, path string, console bool) (bool, error) {
+	if core.Canonical {
+		var err error
+		if data, err = canonicalOutput(data); err != nil {
+			return false, err
+		}
+	}
+
+	if core.DryRun {
+		reportDryRunOutput(data, path, console)
+		return false, nil
+	}
+
+	if console || path == "" {
+		os.Stdout.Write(data)
+		return false, nil
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// canonicalOutput normalizes decrypted exports for --canonical. Other output, such as encrypted exports or YAML, is
+// returned unchanged.
+func canonicalOutput(data []byte) ([]byte, error) {
+	if !gjson.ValidBytes(data) || !gjson.GetBytes(data, "format").Exists() {
+		return data, nil
+	}
+	if util.IsEncrypted(data) {
+		fmt.Fprintln(os.Stderr, "Warning: --canonical has no effect on encrypted exports")
+		return data, nil
+	}
+
+	return util.NormalizeExport(data, false)
+}
+
+// reportDryRun prints the changes between the input and output exports instead of writing the output, then exits.
+// The exit status is dryRunChangesExitCode if there are any changes, or 0 otherwise.
+func reportDryRun(input []byte, outputData []byte, password string) {
+	changes, err := util.DiffExportFiles(input, outputData, password)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("No changes would be made")
+		os.Exit(0)
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	os.Exit(dryRunChangesExitCode)
+}
+
+// reportDryRunOutput prints what writeOutput would write in a dry run. Commands changing an export report the changed
+// preferences with reportDryRun before, so this reports any other output, such as converted files, charts or patches.
+func reportDryRunOutput(data []byte, path string, console bool) {
+	if console || path == "" {
+		fmt.Printf("Would write %d bytes to stdout\n", len(data))
+		dryRunSkippedOutput = true
+		return
+	}
+
+	absolutePath, _ := filepath.Abs(path)
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		fmt.Printf("No changes would be made to \"%s\"\n", absolutePath)
+		return
+	}
+	fmt.Printf("Would write %d bytes to \"%s\"\n", len(data), absolutePath)
+	dryRunSkippedOutput = true
+}
+
+// exitWithError prints the message to stderr and exits with a non-zero status, so failures are noticed in pipelines
+func exitWithError(a ...interface{}) {
+	fmt.Fprintln(os.Stderr, a...)
+	os.Exit(1)
+}
+
+// getPassword returns the password given by flag, falling back to the PasswordEnv environment variable and finally
+// prompting for it
+func getPassword(flag string) (string, error) {
+	if flag != "" {
+		return flag, nil
+	}
+	if env, ok := os.LookupEnv(PasswordEnv); ok {
+		return env, nil
+	}
+	if command, ok := os.LookupEnv(PasswordCommandEnv); ok {
+		output, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %w", PasswordCommandEnv, err)
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+	}
+	if readStdin {
+		return "", fmt.Errorf("a password can't be prompted for when reading from stdin, use --password, %s or %s", PasswordEnv, PasswordCommandEnv)
+	}
+	return displayPasswordPrompt()
 }
 
 func displayPasswordPrompt() (string, error) {
@@ -60,10 +296,40 @@ func displayPasswordPrompt() (string, error) {
 	prompt := &survey.Password{
 		Message: "Enter your master password:",
 	}
-	err := survey.AskOne(prompt, &password)
+	// the prompt is written to stderr to keep stdout clean for exports
+	err := survey.AskOne(prompt, &password, survey.WithStdio(os.Stdin, os.Stderr, os.Stderr))
 	if err != nil {
 		return "", err
 	}
 
 	return password, nil
 }
+
+// displayNewPasswordPrompt asks for a new password twice, to make sure there's no typo in it
+func displayNewPasswordPrompt(message string) (string, error) {
+	qs := []*survey.Question{
+		{
+			Name:     "password",
+			Prompt:   &survey.Password{Message: message},
+			Validate: survey.Required,
+		},
+		{
+			Name:   "confirm",
+			Prompt: &survey.Password{Message: "Confirm the password:"},
+		},
+	}
+	answers := struct {
+		Password string
+		Confirm  string
+	}{}
+
+	err := survey.Ask(qs, &answers, survey.WithStdio(os.Stdin, os.Stderr, os.Stderr))
+	if err != nil {
+		return "", err
+	}
+	if answers.Password != answers.Confirm {
+		return "", errors.New("the passwords do not match")
+	}
+
+	return answers.Password, nil
+}
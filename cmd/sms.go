@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	SmsPassword       string
+	SmsConsole        bool
+	SmsOutput         string
+	SmsRemoteCommands bool
+	SmsBolusDistance  int
+	SmsOtpPin         string
+	SmsResetOtp       bool
+)
+
+// smsCmd represents the sms command
+var smsCmd = &cobra.Command{
+	Use:   "sms",
+	Short: "View and manage the phone numbers and remote commands of the SMS communicator",
+	Long: `View and manage the SMS communicator: the phone numbers which may send commands, whether remote commands are
+allowed, the minimum time between remote boluses and the one-time password setup.
+
+AAPS stores the allowed numbers as one semicolon separated preference, and only accepts commands from numbers which
+match exactly, so a mistyped number locks its owner out. Numbers are validated in E.164 format, a plus followed by the
+country code and number, such as +420123456789, and duplicates are rejected. Spaces are removed.
+
+Examples:
+aaps-export-tool sms show export.json
+aaps-export-tool sms add export.json +420123456789 "+44 7700 900123"
+aaps-export-tool sms remove export.json +420123456789
+aaps-export-tool sms set export.json --remote-commands --bolus-distance 20`,
+}
+
+var smsShowCmd = &cobra.Command{
+	Use:   "show <file>",
+	Short: "Shows the SMS communicator settings and the remote bolus prerequisites",
+	Long: `Shows the allowed phone numbers, the remote command settings and whether one-time passwords are set up, then
+checks the prerequisites of remote boluses which are stored in the preferences. The PIN and the authenticator secret
+are never printed.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, _ := loadExport(args[0], SmsPassword)
+		prefs := export.Preferences()
+
+		fmt.Println("Allowed numbers:")
+		numbers := util.SmsAllowedNumbers(prefs)
+		if len(numbers) == 0 {
+			fmt.Println("  none")
+		}
+		for i, number := range numbers {
+			fmt.Printf("%3d %s\n", i+1, number)
+		}
+		for _, err := range util.SmsNumberProblems(numbers) {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		}
+
+		remoteCommands, _ := util.GetPreference(prefs, util.SmsRemoteCommandsKey)
+		distance, ok := util.GetPreference(prefs, util.SmsBolusDistanceKey)
+		distance += " min"
+		pref := util.LookupPreference(util.SmsBolusDistanceKey, util.ExportVersion(export.Data))
+		if !ok && pref != nil && pref.Default != nil {
+			distance = *pref.Default + " min (default)"
+		}
+		fmt.Println()
+		fmt.Printf("%-24s %s\n", "Remote commands:", formatAllowed(remoteCommands == "true"))
+		fmt.Printf("%-24s %s\n", "Min. bolus distance:", distance)
+		fmt.Printf("%-24s %s\n", "One-time password PIN:", formatIsSet(util.SmsOtpPinKey, prefs))
+		fmt.Printf("%-24s %s\n", "Authenticator secret:", formatIsSet(util.SmsOtpSecretKey, prefs))
+
+		fmt.Println()
+		fmt.Println("Remote bolus prerequisites:")
+		for _, check := range util.SmsRemoteBolusChecks(prefs, util.ExportVersion(export.Data)) {
+			if check.OK {
+				fmt.Printf("  [x] %s\n", check.Description)
+			} else {
+				fmt.Printf("  [ ] %s: %s\n", check.Description, check.Detail)
+			}
+		}
+	},
+}
+
+var smsAddCmd = &cobra.Command{
+	Use:   "add <file> <number>...",
+	Short: "Adds phone numbers which may send commands",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], SmsPassword)
+		numbers := util.SmsAllowedNumbers(export.Preferences())
+
+		var added []string
+		for _, arg := range args[1:] {
+			if err := util.ValidatePhoneNumber(arg); err != nil {
+				exitWithError(err.Error())
+			}
+			number := util.NormalizePhoneNumber(arg)
+			if containsName(numbers, number) {
+				exitWithError(fmt.Sprintf("%s is already allowed", number))
+			}
+			numbers = append(numbers, number)
+			added = append(added, number)
+		}
+
+		setSmsAllowedNumbers(export, numbers)
+		writeExport(export, data, args[0], SmsOutput, SmsConsole, "_sms", fmt.Sprintf("Added %s", strings.Join(added, ", ")))
+	},
+}
+
+var smsRemoveCmd = &cobra.Command{
+	Use:   "remove <file> <number>...",
+	Short: "Removes phone numbers which may send commands",
+	Long: `Removes phone numbers which may send commands. Numbers are matched ignoring spaces, so invalid numbers
+can be removed as well.`,
+	Args: cobra.MatchAll(cobra.MinimumNArgs(2), pathArg),
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], SmsPassword)
+		numbers := util.SmsAllowedNumbers(export.Preferences())
+
+		var removed []string
+		for _, arg := range args[1:] {
+			number := util.NormalizePhoneNumber(arg)
+			if !containsName(numbers, number) {
+				exitWithError(fmt.Sprintf("%s is not an allowed number", number))
+			}
+
+			var kept []string
+			for _, n := range numbers {
+				if n != number {
+					kept = append(kept, n)
+				}
+			}
+			numbers = kept
+			removed = append(removed, number)
+		}
+
+		setSmsAllowedNumbers(export, numbers)
+		writeExport(export, data, args[0], SmsOutput, SmsConsole, "_sms", fmt.Sprintf("Removed %s", strings.Join(removed, ", ")))
+	},
+}
+
+var smsSetCmd = &cobra.Command{
+	Use:   "set <file>",
+	Short: "Changes the remote command and one-time password settings",
+	Long: `Changes the remote command settings, changing only the given values.
+
+The one-time password PIN is prepended to the code of the authenticator app when confirming a command. --reset-otp
+removes the authenticator secret, so a new one has to be generated and scanned in the SMS communicator of AAPS before
+remote commands can be confirmed again.`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		if !flags.Changed("remote-commands") && !flags.Changed("bolus-distance") && !flags.Changed("otp-pin") && !SmsResetOtp {
+			return fmt.Errorf("--remote-commands, --bolus-distance, --otp-pin or --reset-otp is required")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		export, data := loadExport(args[0], SmsPassword)
+		prefs := export.Preferences()
+		version := util.ExportVersion(export.Data)
+		original := prefs
+
+		if cmd.Flags().Changed("remote-commands") {
+			prefs = util.SetPreference(prefs, util.SmsRemoteCommandsKey, strconv.FormatBool(SmsRemoteCommands))
+		}
+		if cmd.Flags().Changed("bolus-distance") {
+			value := strconv.Itoa(SmsBolusDistance)
+			if pref := util.LookupPreference(util.SmsBolusDistanceKey, version); pref != nil {
+				if err := pref.Validate(value); err != nil {
+					exitWithError(fmt.Sprintf("Invalid --bolus-distance: %s", err))
+				}
+			}
+			prefs = util.SetPreference(prefs, util.SmsBolusDistanceKey, value)
+		}
+		if cmd.Flags().Changed("otp-pin") {
+			if err := util.ValidateSmsOtpPin(SmsOtpPin); err != nil {
+				exitWithError(err.Error())
+			}
+			prefs = util.SetPreference(prefs, util.SmsOtpPinKey, SmsOtpPin)
+		}
+		if SmsResetOtp {
+			prefs = util.DeletePreference(prefs, util.SmsOtpSecretKey)
+		}
+
+		changes := util.DiffObjects(original, prefs)
+		if core.Verbose {
+			for _, change := range changes {
+				log.Println(change)
+			}
+		}
+		warnSmsLockout(prefs)
+
+		export.SetPreferences(prefs)
+		writeExport(export, data, args[0], SmsOutput, SmsConsole, "_sms", fmt.Sprintf("Changed %d SMS communicator preference(s)", len(changes)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(smsCmd)
+	smsCmd.AddCommand(smsShowCmd, smsAddCmd, smsRemoveCmd, smsSetCmd)
+
+	smsCmd.PersistentFlags().StringVarP(&SmsPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	smsSetCmd.Flags().BoolVar(&SmsRemoteCommands, "remote-commands", false, "Allow remote commands via SMS, use --remote-commands=false to disallow them")
+	smsSetCmd.Flags().IntVar(&SmsBolusDistance, "bolus-distance", 0, "Minimum time between remote boluses in minutes")
+	smsSetCmd.Flags().StringVar(&SmsOtpPin, "otp-pin", "", "PIN prepended to one-time passwords")
+	smsSetCmd.Flags().BoolVar(&SmsResetOtp, "reset-otp", false, "Remove the authenticator secret, which then has to be set up again in AAPS")
+
+	for _, c := range []*cobra.Command{smsAddCmd, smsRemoveCmd, smsSetCmd} {
+		c.Flags().BoolVarP(&SmsConsole, "console", "c", false, "Write export to stdout")
+		c.Flags().StringVarP(&SmsOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_sms' before file extension)")
+		c.MarkFlagsMutuallyExclusive("console", "out")
+	}
+}
+
+func setSmsAllowedNumbers(export *util.Export, numbers []string) {
+	prefs := util.SetSmsAllowedNumbers(export.Preferences(), numbers)
+	for _, err := range util.SmsNumberProblems(numbers) {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+	warnSmsLockout(prefs)
+	export.SetPreferences(prefs)
+}
+
+// warnSmsLockout warns when remote commands are allowed but no number can send them
+func warnSmsLockout(prefs []byte) {
+	remoteCommands, _ := util.GetPreference(prefs, util.SmsRemoteCommandsKey)
+	if remoteCommands == "true" && len(util.SmsAllowedNumbers(prefs)) == 0 {
+		fmt.Fprintln(os.Stderr, "warning: remote commands are allowed, but no phone number may send them")
+	}
+}
+
+func formatAllowed(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "not allowed"
+}
+
+func formatIsSet(key string, prefs []byte) string {
+	if value, _ := util.GetPreference(prefs, key); value != "" {
+		return "set"
+	}
+	return "not set"
+}
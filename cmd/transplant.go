@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"aaps-export-tool/core"
+	"aaps-export-tool/util"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	TransplantFrom         string
+	TransplantGroups       []string
+	TransplantGroupsConfig string
+	TransplantPassword     string
+	TransplantConsole      bool
+	TransplantOutput       string
+)
+
+// transplantCmd represents the transplant command
+var transplantCmd = &cobra.Command{
+	Use:   "transplant --from <source> --groups <groups> <file>",
+	Short: "Copies selected groups of preferences from one export into another",
+	Long: `Copies selected groups of preferences from a source export into another export, for example when moving to a
+new phone or pump where only some settings should be carried over.
+
+Groups are matched by preference key prefixes. The built-in groups can be extended, and new groups added, with a YAML
+file given by --groups-config:
+
+groups:
+  - name: aps
+    prefixes: [my_custom_aps_]
+  - name: clinic
+    description: Clinic specific settings
+    keys: [patient_name]
+
+Built-in groups:
+` + groupsHelp() + `
+Examples:
+aaps-export-tool transplant --from old-phone.json --groups aps,profiles,automation new-phone.json
+aaps-export-tool transplant --from old-phone.json --groups profiles new-phone.json --out "new-phone-profiles.json"`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), pathArg),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return pathArgs(2)(cmd, []string{TransplantFrom, args[0]})
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		groups := util.PreferenceGroups
+		if TransplantGroupsConfig != "" {
+			config, err := readInput(TransplantGroupsConfig)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			groups, err = util.LoadPreferenceGroups(config)
+			if err != nil {
+				exitWithError(fmt.Sprintf("Invalid groups config: %s", err))
+			}
+		}
+
+		var selected []*util.PreferenceGroup
+		for _, name := range TransplantGroups {
+			group := util.FindPreferenceGroup(groups, name)
+			if group == nil {
+				exitWithError(fmt.Sprintf("Unknown preference group \"%s\"", name))
+			}
+			selected = append(selected, group)
+		}
+
+		source, _ := loadExport(TransplantFrom, TransplantPassword)
+		destination, data := loadExport(args[0], TransplantPassword)
+
+		prefs, changes := util.TransplantPreferences(source.Preferences(), destination.Preferences(), selected)
+		destination.SetPreferences(prefs)
+
+		outputData, err := destination.Bytes()
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		if core.DryRun {
+			reportDryRun(data, outputData, destination.Password)
+		}
+
+		path := outputPath(args[0], TransplantOutput, "_transplant")
+		wroteFile, err := writeOutput(outputData, path, TransplantConsole)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		// the report goes to stderr when stdout is carrying the export
+		var report io.Writer = os.Stdout
+		if !wroteFile {
+			report = os.Stderr
+		}
+
+		copied, overwritten := 0, 0
+		for _, change := range changes {
+			if change.Added {
+				copied++
+				fmt.Fprintf(report, "Copied %s = %q\n", change.Key, change.NewValue)
+			} else {
+				overwritten++
+				fmt.Fprintf(report, "Overwrote %s: %q -> %q\n", change.Key, change.OldValue, change.NewValue)
+			}
+		}
+		fmt.Fprintf(report, "Copied %d and overwrote %d preference(s) from groups %s\n", copied, overwritten, strings.Join(TransplantGroups, ", "))
+
+		if wroteFile {
+			absolutePath, _ := filepath.Abs(path)
+			fmt.Printf("Export was written to \"%s\"\n", absolutePath)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(transplantCmd)
+
+	transplantCmd.Flags().StringVar(&TransplantFrom, "from", "", "The export to copy preferences from")
+	transplantCmd.MarkFlagRequired("from")
+	transplantCmd.Flags().StringSliceVarP(&TransplantGroups, "groups", "g", []string{}, "Comma-separated preference group(s) to copy. May be specified multiple times")
+	transplantCmd.MarkFlagRequired("groups")
+	transplantCmd.Flags().StringVar(&TransplantGroupsConfig, "groups-config", "", "YAML file with additional preference groups")
+
+	transplantCmd.Flags().StringVarP(&TransplantPassword, "password", "p", "", "Manually specify encryption password (only use if necessary, like in shell scripts)")
+
+	transplantCmd.Flags().BoolVarP(&TransplantConsole, "console", "c", false, "Write export to stdout")
+	transplantCmd.Flags().StringVarP(&TransplantOutput, "out", "o", "", "Write output to the specified file (default: original filename with '_transplant' before file extension)")
+	transplantCmd.MarkFlagsMutuallyExclusive("console", "out")
+}
+
+// groupsHelp lists the built-in preference groups for help texts
+func groupsHelp() string {
+	var sb strings.Builder
+	for _, group := range util.PreferenceGroups {
+		sb.WriteString(fmt.Sprintf("  %-12s %s\n", group.Name, group.Description))
+	}
+	return sb.String()
+}
@@ -3,3 +3,7 @@ package core
 const Version = ""
 
 var Verbose bool
+
+var DryRun bool
+
+var Canonical bool
@@ -0,0 +1,325 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+	"strings"
+)
+
+// AutomationEventsKey is the preference in which the automation plugin stores its rules as a JSON array.
+// Every rule is an object, but its trigger and actions are JSON serialized into strings again, as are the triggers
+// inside trigger connectors.
+const AutomationEventsKey = "AUTOMATION_EVENTS"
+
+// AutomationEvent is an automation rule. Raw is the compact JSON object of the rule as AAPS stores it, the other
+// fields are read from it.
+type AutomationEvent struct {
+	Raw      string
+	Title    string
+	Enabled  bool
+	ReadOnly bool
+}
+
+// AutomationEvents reads the automation rules of the preferences, in the order AAPS shows them
+func AutomationEvents(prefs []byte) ([]AutomationEvent, error) {
+	value, ok := GetPreference(prefs, AutomationEventsKey)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	if !gjson.Valid(value) || !gjson.Parse(value).IsArray() {
+		return nil, fmt.Errorf("preference \"%s\" is not a JSON array", AutomationEventsKey)
+	}
+
+	var events []AutomationEvent
+	var err error
+	gjson.Parse(value).ForEach(func(_, rule gjson.Result) bool {
+		var event *AutomationEvent
+		event, err = newAutomationEvent(rule)
+		if err != nil {
+			err = fmt.Errorf("automation %d: %w", len(events)+1, err)
+			return false
+		}
+		events = append(events, *event)
+		return true
+	})
+	return events, err
+}
+
+// SetAutomationEvents stores the automation rules in the preferences, serialized like AAPS does
+func SetAutomationEvents(prefs []byte, events []AutomationEvent) []byte {
+	raws := make([]string, len(events))
+	for i, event := range events {
+		raws[i] = event.Raw
+	}
+	return SetPreference(prefs, AutomationEventsKey, "["+strings.Join(raws, ",")+"]")
+}
+
+// ParseAutomationEvents reads automation rules from a rule file, which contains a single rule or an array of rules.
+// Triggers and actions may be expanded into objects, they are serialized into strings again.
+func ParseAutomationEvents(data []byte) ([]AutomationEvent, error) {
+	if !gjson.ValidBytes(data) {
+		return nil, errors.New("invalid JSON")
+	}
+	rules := gjson.ParseBytes(data)
+	if rules.IsObject() {
+		event, err := newAutomationEvent(rules)
+		if err != nil {
+			return nil, err
+		}
+		return []AutomationEvent{*event}, nil
+	}
+	if !rules.IsArray() {
+		return nil, errors.New("expected an automation rule or an array of rules")
+	}
+
+	var events []AutomationEvent
+	var err error
+	rules.ForEach(func(_, rule gjson.Result) bool {
+		var event *AutomationEvent
+		event, err = newAutomationEvent(rule)
+		if err != nil {
+			err = fmt.Errorf("rule %d: %w", len(events)+1, err)
+			return false
+		}
+		events = append(events, *event)
+		return true
+	})
+	return events, err
+}
+
+// FindAutomationEvent returns the index of the rule with the given title, or -1
+func FindAutomationEvent(events []AutomationEvent, title string) int {
+	for i := range events {
+		if events[i].Title == title {
+			return i
+		}
+	}
+	return -1
+}
+
+func newAutomationEvent(rule gjson.Result) (*AutomationEvent, error) {
+	if !rule.IsObject() {
+		return nil, errors.New("expected a JSON object")
+	}
+	if rule.Get("title").Type != gjson.String {
+		return nil, errors.New("missing title")
+	}
+	if !rule.Get("actions").IsArray() {
+		return nil, errors.New("missing actions")
+	}
+
+	// rules read from AAPS are kept as they are, only expanded triggers and actions are serialized into strings
+	raw := string(pretty.Ugly([]byte(rule.Raw)))
+	trigger, err := collapseAutomationTrigger(rule.Get("trigger"))
+	if err != nil {
+		return nil, fmt.Errorf("trigger: %w", err)
+	}
+	if rule.Get("trigger").Type != gjson.String {
+		raw, _ = sjson.SetRaw(raw, "trigger", string(jsonString(trigger)))
+	}
+
+	var actions []string
+	expanded := false
+	rule.Get("actions").ForEach(func(_, action gjson.Result) bool {
+		var collapsed string
+		collapsed, err = collapseAutomationElement(action)
+		if err != nil {
+			err = fmt.Errorf("action %d: %w", len(actions)+1, err)
+			return false
+		}
+		expanded = expanded || action.Type != gjson.String
+		actions = append(actions, string(jsonString(collapsed)))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expanded {
+		raw, _ = sjson.SetRaw(raw, "actions", "["+strings.Join(actions, ",")+"]")
+	}
+
+	return &AutomationEvent{
+		Raw:      raw,
+		Title:    rule.Get("title").String(),
+		Enabled:  rule.Get("enabled").Bool(),
+		ReadOnly: rule.Get("readOnly").Bool(),
+	}, nil
+}
+
+// SetEnabled enables or disables the rule
+func (e *AutomationEvent) SetEnabled(enabled bool) {
+	e.Raw, _ = sjson.Set(e.Raw, "enabled", enabled)
+	e.Enabled = enabled
+}
+
+// Expanded returns the rule as indented JSON with its trigger and actions expanded into objects, which is easier to
+// read and edit. ParseAutomationEvents accepts it again.
+func (e *AutomationEvent) Expanded() []byte {
+	rule := gjson.Parse(e.Raw)
+	raw, _ := sjson.SetRaw(e.Raw, "trigger", expandAutomationTrigger(rule.Get("trigger")))
+
+	var actions []string
+	rule.Get("actions").ForEach(func(_, action gjson.Result) bool {
+		actions = append(actions, expandAutomationElement(action))
+		return true
+	})
+	raw, _ = sjson.SetRaw(raw, "actions", "["+strings.Join(actions, ",")+"]")
+	return pretty.Pretty([]byte(raw))
+}
+
+// Describe renders the rule readably, with nested trigger connectors indented
+func (e *AutomationEvent) Describe() string {
+	rule := gjson.Parse(e.Raw)
+
+	var out strings.Builder
+	state := "enabled"
+	if !e.Enabled {
+		state = "disabled"
+	}
+	if e.ReadOnly {
+		state += ", read-only"
+	}
+	fmt.Fprintf(&out, "%s (%s)\n", e.Title, state)
+
+	out.WriteString("  if ")
+	describeAutomationTrigger(&out, gjson.Parse(expandAutomationTrigger(rule.Get("trigger"))), "  ")
+
+	out.WriteString("  then:\n")
+	rule.Get("actions").ForEach(func(_, action gjson.Result) bool {
+		out.WriteString("    - ")
+		out.WriteString(describeAutomationElement(gjson.Parse(expandAutomationElement(action))))
+		out.WriteString("\n")
+		return true
+	})
+	return out.String()
+}
+
+func describeAutomationTrigger(out *strings.Builder, trigger gjson.Result, indent string) {
+	if !isAutomationConnector(trigger) {
+		out.WriteString(describeAutomationElement(trigger))
+		out.WriteString("\n")
+		return
+	}
+
+	switch connector := trigger.Get("data.connectorType").String(); connector {
+	case "AND":
+		out.WriteString("all of:\n")
+	case "OR":
+		out.WriteString("any of:\n")
+	case "XOR":
+		out.WriteString("exactly one of:\n")
+	default:
+		fmt.Fprintf(out, "%s of:\n", connector)
+	}
+	trigger.Get("data.triggerList").ForEach(func(_, item gjson.Result) bool {
+		out.WriteString(indent + "  - ")
+		describeAutomationTrigger(out, item, indent+"  ")
+		return true
+	})
+}
+
+// describeAutomationElement renders a trigger or action as its type followed by its data, e.g.
+// "Bg: bg=80, comparator=IS_LESSER, units=mg/dl"
+func describeAutomationElement(element gjson.Result) string {
+	name := automationTypeName(element.Get("type").String())
+	var data []string
+	element.Get("data").ForEach(func(key, value gjson.Result) bool {
+		text := value.Raw
+		if value.Type == gjson.String {
+			text = value.String()
+		}
+		data = append(data, key.String()+"="+text)
+		return true
+	})
+	if len(data) == 0 {
+		return name
+	}
+	return name + ": " + strings.Join(data, ", ")
+}
+
+// automationTypeName shortens the type of a trigger or action, which older AAPS versions store as a full class name,
+// e.g. "info.nightscout.androidaps.plugins.general.automation.triggers.TriggerBg" becomes "Bg"
+func automationTypeName(typ string) string {
+	typ = typ[strings.LastIndex(typ, ".")+1:]
+	for _, prefix := range []string{"Trigger", "Action"} {
+		if strings.HasPrefix(typ, prefix) && len(typ) > len(prefix) {
+			return strings.TrimPrefix(typ, prefix)
+		}
+	}
+	return typ
+}
+
+func isAutomationConnector(trigger gjson.Result) bool {
+	return strings.HasSuffix(trigger.Get("type").String(), "TriggerConnector")
+}
+
+// collapseAutomationTrigger serializes a trigger and the triggers of connectors inside it into the strings AAPS
+// stores them as
+func collapseAutomationTrigger(trigger gjson.Result) (string, error) {
+	if trigger.Type == gjson.String {
+		if !gjson.Valid(trigger.String()) {
+			return "", errors.New("invalid JSON")
+		}
+		trigger = gjson.Parse(trigger.String())
+	}
+	if !trigger.IsObject() || trigger.Get("type").Type != gjson.String {
+		return "", errors.New("expected an object with a type")
+	}
+
+	raw := string(pretty.Ugly([]byte(trigger.Raw)))
+	if isAutomationConnector(trigger) {
+		var list []string
+		var err error
+		trigger.Get("data.triggerList").ForEach(func(_, item gjson.Result) bool {
+			var collapsed string
+			collapsed, err = collapseAutomationTrigger(item)
+			list = append(list, string(jsonString(collapsed)))
+			return err == nil
+		})
+		if err != nil {
+			return "", err
+		}
+		raw, _ = sjson.SetRaw(raw, "data.triggerList", "["+strings.Join(list, ",")+"]")
+	}
+	return raw, nil
+}
+
+func collapseAutomationElement(element gjson.Result) (string, error) {
+	if element.Type == gjson.String {
+		if !gjson.Valid(element.String()) {
+			return "", errors.New("invalid JSON")
+		}
+		element = gjson.Parse(element.String())
+	}
+	if !element.IsObject() || element.Get("type").Type != gjson.String {
+		return "", errors.New("expected an object with a type")
+	}
+	return string(pretty.Ugly([]byte(element.Raw))), nil
+}
+
+// expandAutomationTrigger parses a trigger serialized into a string, and the triggers of connectors inside it
+func expandAutomationTrigger(trigger gjson.Result) string {
+	raw := expandAutomationElement(trigger)
+	parsed := gjson.Parse(raw)
+	if !isAutomationConnector(parsed) {
+		return raw
+	}
+
+	var list []string
+	parsed.Get("data.triggerList").ForEach(func(_, item gjson.Result) bool {
+		list = append(list, expandAutomationTrigger(item))
+		return true
+	})
+	raw, _ = sjson.SetRaw(raw, "data.triggerList", "["+strings.Join(list, ",")+"]")
+	return raw
+}
+
+func expandAutomationElement(element gjson.Result) string {
+	if element.Type == gjson.String {
+		return element.String()
+	}
+	return element.Raw
+}
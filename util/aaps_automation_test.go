@@ -0,0 +1,97 @@
+package util
+
+import (
+	"testing"
+)
+
+// testAutomationRule returns a rule serialized like AAPS does: the trigger and actions are strings, and so are the
+// triggers inside the trigger connector
+func testAutomationRule(title string, enabled string) string {
+	bg := `{"type":"app.aaps.plugins.automation.triggers.TriggerBg","data":{"bg":80.0,"comparator":"IS_LESSER","units":"mg/dl"}}`
+	trigger := `{"type":"app.aaps.plugins.automation.triggers.TriggerConnector","data":{"connectorType":"AND","triggerList":[` +
+		string(jsonString(bg)) + `]}}`
+	action := `{"type":"app.aaps.plugins.automation.actions.ActionStartTempTarget","data":{"reason":"Hypo","value":{"value":120.0,"units":"mg/dl"},"durationInMinutes":60}}`
+	return `{"title":"` + title + `","enabled":` + enabled + `,"readOnly":false,"autoRemove":false,"userAction":false,` +
+		`"trigger":` + string(jsonString(trigger)) + `,"actions":[` + string(jsonString(action)) + `],"position":0}`
+}
+
+func TestAutomationEvents(t *testing.T) {
+	rules := "[" + testAutomationRule("Low", "true") + "," + testAutomationRule("High", "false") + "]"
+	prefs := SetPreference([]byte(`{}`), AutomationEventsKey, rules)
+
+	events, err := AutomationEvents(prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Title != "Low" || !events[0].Enabled || events[1].Title != "High" || events[1].Enabled {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if FindAutomationEvent(events, "High") != 1 || FindAutomationEvent(events, "missing") != -1 {
+		t.Errorf("FindAutomationEvent didn't find the rules")
+	}
+
+	// rules read from AAPS are stored again byte for byte
+	if got, _ := GetPreference(SetAutomationEvents(prefs, events), AutomationEventsKey); got != rules {
+		t.Errorf("got %s, want %s", got, rules)
+	}
+
+	events[1].SetEnabled(true)
+	saved, err := AutomationEvents(SetAutomationEvents(prefs, events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved[1].Enabled {
+		t.Errorf("the rule wasn't enabled")
+	}
+}
+
+func TestAutomationEventExpanded(t *testing.T) {
+	raw := testAutomationRule("Low", "true")
+	events, err := ParseAutomationEvents([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"expanded rule", events[0].Expanded()},
+		{"array of expanded rules", []byte("[" + string(events[0].Expanded()) + "]")},
+		{"rule as AAPS stores it", []byte(raw)},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseAutomationEvents(test.data)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(parsed) != 1 || parsed[0].Raw != raw {
+			t.Errorf("%s: got %v, want %s", test.name, parsed, raw)
+		}
+	}
+}
+
+func TestParseAutomationEventsErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid JSON":      `{`,
+		"not a rule":        `1`,
+		"missing title":     `{"actions":[],"trigger":{"type":"T"}}`,
+		"missing actions":   `{"title":"a","trigger":{"type":"T"}}`,
+		"trigger type":      `{"title":"a","actions":[],"trigger":{}}`,
+		"invalid trigger":   `{"title":"a","actions":[],"trigger":"{"}`,
+		"action type":       `{"title":"a","actions":[{}],"trigger":{"type":"T"}}`,
+		"second rule":       `[{"title":"a","actions":[],"trigger":{"type":"T"}},{"title":"b"}]`,
+		"connector trigger": `{"title":"a","actions":[],"trigger":{"type":"TriggerConnector","data":{"triggerList":[{}]}}}`,
+	}
+
+	for name, data := range tests {
+		if _, err := ParseAutomationEvents([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	if _, err := AutomationEvents(SetPreference([]byte(`{}`), AutomationEventsKey, `{}`)); err == nil {
+		t.Errorf("a preference which isn't an array was accepted")
+	}
+}
@@ -0,0 +1,244 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MgdlPerMmol converts glucose values from mmol/l to mg/dl, as in AAPS
+const MgdlPerMmol = 18.0
+
+const secondsPerDay = 24 * 3600
+
+// AutotuneResult contains the recommendations of an oref0 autotune profile.json.
+// Autotune always calculates the ISF in mg/dl.
+type AutotuneResult struct {
+	Basal     ProfileSchedule
+	ISF       ProfileSchedule
+	CarbRatio float64
+}
+
+// ProfileChange is a recommended change of a profile schedule in the block from TimeAsSeconds until EndAsSeconds
+type ProfileChange struct {
+	// Schedule is "basal", "isf" or "ic"
+	Schedule      string
+	TimeAsSeconds int
+	// EndAsSeconds is the end of the block, 86400 for the last block of the day
+	EndAsSeconds int
+	Old          float64
+	New          float64
+	// Capped is set when New was limited by the maximum change
+	Capped bool
+}
+
+// Percent returns the relative change in percent
+func (c *ProfileChange) Percent() float64 {
+	if c.Old == 0 {
+		return 0
+	}
+	return (c.New - c.Old) / c.Old * 100
+}
+
+// ParseAutotuneProfile parses the profile.json written by oref0 autotune
+func ParseAutotuneProfile(data []byte) (*AutotuneResult, error) {
+	var profile struct {
+		BasalProfile []struct {
+			Minutes *int     `json:"minutes"`
+			Start   string   `json:"start"`
+			Rate    *float64 `json:"rate"`
+		} `json:"basalprofile"`
+		IsfProfile struct {
+			Sensitivities []struct {
+				Offset      *int     `json:"offset"`
+				Start       string   `json:"start"`
+				Sensitivity *float64 `json:"sensitivity"`
+			} `json:"sensitivities"`
+		} `json:"isfProfile"`
+		CarbRatio *float64 `json:"carb_ratio"`
+	}
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, err
+	}
+
+	minutesOf := func(minutes *int, start string) (int, error) {
+		if minutes != nil {
+			return *minutes, nil
+		}
+		if len(start) >= 5 {
+			seconds, err := ParseTimeOfDay(start[:5])
+			return seconds / 60, err
+		}
+		return 0, fmt.Errorf("invalid start \"%s\"", start)
+	}
+
+	result := &AutotuneResult{}
+	for _, entry := range profile.BasalProfile {
+		minutes, err := minutesOf(entry.Minutes, entry.Start)
+		if err != nil {
+			return nil, fmt.Errorf("basalprofile: %w", err)
+		}
+		if entry.Rate == nil {
+			return nil, fmt.Errorf("basalprofile: missing rate at %s", FormatTimeOfDay(minutes*60))
+		}
+		result.Basal = append(result.Basal, ProfileBlock{Time: FormatTimeOfDay(minutes * 60), TimeAsSeconds: minutes * 60, Value: *entry.Rate})
+	}
+	for _, entry := range profile.IsfProfile.Sensitivities {
+		minutes, err := minutesOf(entry.Offset, entry.Start)
+		if err != nil {
+			return nil, fmt.Errorf("isfProfile: %w", err)
+		}
+		if entry.Sensitivity == nil {
+			return nil, fmt.Errorf("isfProfile: missing sensitivity at %s", FormatTimeOfDay(minutes*60))
+		}
+		result.ISF = append(result.ISF, ProfileBlock{Time: FormatTimeOfDay(minutes * 60), TimeAsSeconds: minutes * 60, Value: *entry.Sensitivity})
+	}
+	if profile.CarbRatio != nil {
+		result.CarbRatio = *profile.CarbRatio
+	}
+
+	if len(result.Basal) == 0 && len(result.ISF) == 0 && result.CarbRatio == 0 {
+		return nil, errors.New("no basalprofile, isfProfile or carb_ratio found")
+	}
+	result.Basal = sortedSchedule(result.Basal)
+	result.ISF = sortedSchedule(result.ISF)
+	return result, nil
+}
+
+// AutotuneChanges compares a local profile with the autotune recommendations, returning a change for every block in
+// which a value would change. Autotune calculates a single ISF and carb ratio: without circadian they replace the
+// whole schedule, with circadian the existing schedule is scaled so its average matches the recommendation.
+// A maxChangePercent above 0 limits every change to that percentage of the old value.
+func AutotuneChanges(profile *LocalProfile, result *AutotuneResult, circadian bool, maxChangePercent float64) []ProfileChange {
+	var changes []ProfileChange
+	add := func(schedule string, times []int, i int, old float64, new float64, decimals int) {
+		capped := false
+		if maxChangePercent > 0 {
+			limit := old * maxChangePercent / 100
+			if clamped := math.Max(old-limit, math.Min(old+limit, new)); clamped != new {
+				new, capped = clamped, true
+			}
+		}
+		new = roundTo(new, decimals)
+		if new != old {
+			end := secondsPerDay
+			if i+1 < len(times) {
+				end = times[i+1]
+			}
+			changes = append(changes, ProfileChange{Schedule: schedule, TimeAsSeconds: times[i], EndAsSeconds: end, Old: old, New: new, Capped: capped})
+		}
+	}
+
+	if len(result.Basal) > 0 {
+		times := ScheduleTimes(profile.Basal, result.Basal)
+		for i, t := range times {
+			add("basal", times, i, profile.Basal.ValueAt(t), result.Basal.ValueAt(t), 2)
+		}
+	}
+
+	if len(result.ISF) > 0 {
+		isf := result.ISF
+		decimals := 1
+		if !profile.Mgdl {
+			isf = scaleSchedule(isf, 1/MgdlPerMmol)
+			decimals = 2
+		}
+		if circadian {
+			times := ScheduleTimes(profile.ISF)
+			for i, t := range times {
+				add("isf", times, i, profile.ISF.ValueAt(t), profile.ISF.ValueAt(t)*isf.Total()/profile.ISF.Total(), decimals)
+			}
+		} else {
+			times := ScheduleTimes(profile.ISF, isf)
+			for i, t := range times {
+				add("isf", times, i, profile.ISF.ValueAt(t), isf.ValueAt(t), decimals)
+			}
+		}
+	}
+
+	if result.CarbRatio > 0 {
+		times := ScheduleTimes(profile.IC)
+		for i, t := range times {
+			new := result.CarbRatio
+			if circadian {
+				new = profile.IC.ValueAt(t) * result.CarbRatio * 24 / profile.IC.Total()
+			}
+			add("ic", times, i, profile.IC.ValueAt(t), new, 1)
+		}
+	}
+
+	return changes
+}
+
+// ApplyProfileChanges sets the new values of the changes in their blocks of the schedules of the profile. Only the
+// block of a change is set, so the rest of the schedule keeps its old values when only some of the recommended changes
+// are applied. Blocks which end up with the same value as the block before them are merged.
+func ApplyProfileChanges(profile *LocalProfile, changes []ProfileChange) {
+	for name, schedule := range map[string]*ProfileSchedule{"basal": &profile.Basal, "isf": &profile.ISF, "ic": &profile.IC} {
+		var scheduleChanges []ProfileChange
+		for _, change := range changes {
+			if change.Schedule == name {
+				scheduleChanges = append(scheduleChanges, change)
+			}
+		}
+		if len(scheduleChanges) == 0 {
+			continue
+		}
+
+		times := ScheduleTimes(*schedule)
+		for _, change := range scheduleChanges {
+			times = append(times, change.TimeAsSeconds)
+			if change.EndAsSeconds < secondsPerDay {
+				times = append(times, change.EndAsSeconds)
+			}
+		}
+		sort.Ints(times)
+
+		var updated ProfileSchedule
+		for _, t := range times {
+			value := schedule.ValueAt(t)
+			for _, change := range scheduleChanges {
+				if change.TimeAsSeconds <= t && t < change.EndAsSeconds {
+					value = change.New
+				}
+			}
+			if len(updated) > 0 && (updated[len(updated)-1].TimeAsSeconds == t || updated[len(updated)-1].Value == value) {
+				continue
+			}
+			updated = append(updated, ProfileBlock{Time: FormatTimeOfDay(t), TimeAsSeconds: t, Value: value})
+		}
+		*schedule = updated
+	}
+}
+
+// ScheduleTimes returns the sorted, distinct block times of the schedules
+func ScheduleTimes(schedules ...ProfileSchedule) []int {
+	seen := map[int]bool{}
+	var times []int
+	for _, schedule := range schedules {
+		for _, block := range schedule {
+			if !seen[block.TimeAsSeconds] {
+				seen[block.TimeAsSeconds] = true
+				times = append(times, block.TimeAsSeconds)
+			}
+		}
+	}
+	sort.Ints(times)
+	return times
+}
+
+func scaleSchedule(schedule ProfileSchedule, factor float64) ProfileSchedule {
+	scaled := make(ProfileSchedule, len(schedule))
+	for i, block := range schedule {
+		block.Value *= factor
+		scaled[i] = block
+	}
+	return scaled
+}
+
+func roundTo(value float64, decimals int) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Round(value*factor) / factor
+}
@@ -0,0 +1,170 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAutotuneProfile(t *testing.T) {
+	data := `{"basalprofile":[{"start":"06:00:00","minutes":360,"rate":1.2},{"start":"00:00:00","rate":0.8}],
+		"isfProfile":{"sensitivities":[{"offset":0,"sensitivity":45}]},"carb_ratio":9.5}`
+
+	result, err := ParseAutotuneProfile([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Basal, testSchedule(0, 0.8, 6, 1.2)) {
+		t.Errorf("got basal %v", result.Basal)
+	}
+	if !reflect.DeepEqual(result.ISF, testSchedule(0, 45)) || result.CarbRatio != 9.5 {
+		t.Errorf("got ISF %v and carb ratio %v", result.ISF, result.CarbRatio)
+	}
+
+	tests := map[string]string{
+		"not JSON":            `{`,
+		"no recommendations":  `{"dia":5}`,
+		"missing rate":        `{"basalprofile":[{"minutes":0}]}`,
+		"missing sensitivity": `{"isfProfile":{"sensitivities":[{"offset":0}]}}`,
+		"invalid start":       `{"basalprofile":[{"start":"6","rate":1}]}`,
+	}
+	for name, data := range tests {
+		if _, err := ParseAutotuneProfile([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestAutotuneChanges(t *testing.T) {
+	profile := testLocalProfile("Default")
+	profile.ISF = testSchedule(0, 40, 12, 60)
+	profile.IC = testSchedule(0, 10, 12, 20)
+
+	tests := []struct {
+		name      string
+		mgdl      bool
+		result    AutotuneResult
+		circadian bool
+		max       float64
+		want      []ProfileChange
+	}{
+		{
+			name:   "basal blocks of both schedules",
+			mgdl:   true,
+			result: AutotuneResult{Basal: testSchedule(0, 1.1, 3, 1, 6, 1.2)},
+			want: []ProfileChange{
+				{Schedule: "basal", TimeAsSeconds: 0, EndAsSeconds: 3 * 3600, Old: 1, New: 1.1},
+			},
+		},
+		{
+			name:   "capped",
+			mgdl:   true,
+			result: AutotuneResult{Basal: testSchedule(0, 2, 6, 1.2)},
+			max:    20,
+			want: []ProfileChange{
+				{Schedule: "basal", TimeAsSeconds: 0, EndAsSeconds: 6 * 3600, Old: 1, New: 1.2, Capped: true},
+			},
+		},
+		{
+			name:   "single ISF replaces the schedule",
+			mgdl:   true,
+			result: AutotuneResult{ISF: testSchedule(0, 50)},
+			want: []ProfileChange{
+				{Schedule: "isf", TimeAsSeconds: 0, EndAsSeconds: 12 * 3600, Old: 40, New: 50},
+				{Schedule: "isf", TimeAsSeconds: 12 * 3600, EndAsSeconds: secondsPerDay, Old: 60, New: 50},
+			},
+		},
+		{
+			name:   "ISF in mmol",
+			result: AutotuneResult{ISF: testSchedule(0, 54)},
+			want: []ProfileChange{
+				{Schedule: "isf", TimeAsSeconds: 0, EndAsSeconds: 12 * 3600, Old: 40, New: 3},
+				{Schedule: "isf", TimeAsSeconds: 12 * 3600, EndAsSeconds: secondsPerDay, Old: 60, New: 3},
+			},
+		},
+		{
+			name:      "circadian scales the schedules",
+			mgdl:      true,
+			result:    AutotuneResult{ISF: testSchedule(0, 60), CarbRatio: 18},
+			circadian: true,
+			want: []ProfileChange{
+				{Schedule: "isf", TimeAsSeconds: 0, EndAsSeconds: 12 * 3600, Old: 40, New: 48},
+				{Schedule: "isf", TimeAsSeconds: 12 * 3600, EndAsSeconds: secondsPerDay, Old: 60, New: 72},
+				{Schedule: "ic", TimeAsSeconds: 0, EndAsSeconds: 12 * 3600, Old: 10, New: 12},
+				{Schedule: "ic", TimeAsSeconds: 12 * 3600, EndAsSeconds: secondsPerDay, Old: 20, New: 24},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		profile := profile
+		profile.Mgdl = test.mgdl
+		got := AutotuneChanges(&profile, &test.result, test.circadian, test.max)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestApplyProfileChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		basal    ProfileSchedule
+		result   ProfileSchedule
+		accepted []int
+		want     ProfileSchedule
+	}{
+		{
+			name:     "only the accepted block",
+			basal:    testSchedule(0, 1),
+			result:   testSchedule(0, 1.2, 1, 0.9),
+			accepted: []int{0},
+			want:     testSchedule(0, 1.2, 1, 1),
+		},
+		{
+			name:     "only the later block",
+			basal:    testSchedule(0, 1),
+			result:   testSchedule(0, 1.2, 1, 0.9),
+			accepted: []int{1},
+			want:     testSchedule(0, 1, 1, 0.9),
+		},
+		{
+			name:     "unchanged block between changes",
+			basal:    testSchedule(0, 1),
+			result:   testSchedule(0, 1.2, 6, 1, 12, 0.8),
+			accepted: []int{0},
+			want:     testSchedule(0, 1.2, 6, 1),
+		},
+		{
+			name:     "all changes",
+			basal:    testSchedule(0, 1, 12, 1.5),
+			result:   testSchedule(0, 1.2, 6, 1.2, 12, 0.8),
+			accepted: []int{0, 1, 2},
+			want:     testSchedule(0, 1.2, 12, 0.8),
+		},
+		{
+			name:     "blocks with the same value are merged",
+			basal:    testSchedule(0, 1, 6, 1.2),
+			result:   testSchedule(0, 1.2),
+			accepted: []int{0},
+			want:     testSchedule(0, 1.2),
+		},
+	}
+
+	for _, test := range tests {
+		profile := testLocalProfile("Default")
+		profile.Basal = test.basal
+		changes := AutotuneChanges(&profile, &AutotuneResult{Basal: test.result}, false, 0)
+		var accepted []ProfileChange
+		for _, i := range test.accepted {
+			accepted = append(accepted, changes[i])
+		}
+
+		ApplyProfileChanges(&profile, accepted)
+		if !reflect.DeepEqual(profile.Basal, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, profile.Basal, test.want)
+		}
+		if !reflect.DeepEqual(profile.ISF, testLocalProfile("Default").ISF) {
+			t.Errorf("%s: the ISF was changed", test.name)
+		}
+	}
+}
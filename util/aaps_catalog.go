@@ -0,0 +1,313 @@
+package util
+
+import (
+	_ "embed"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PreferenceType describes how AAPS interprets the string value of a preference
+type PreferenceType string
+
+const (
+	TypeBool   PreferenceType = "bool"
+	TypeInt    PreferenceType = "int"
+	TypeDouble PreferenceType = "double"
+	TypeString PreferenceType = "string"
+	// TypeJson is JSON serialized inside the string value
+	TypeJson PreferenceType = "json"
+)
+
+// KnownPreference describes a preference which is known to be used by AAPS
+type KnownPreference struct {
+	// Key is the preference key. Keys containing '*' are patterns matching any characters.
+	Key         string         `yaml:"key"`
+	Type        PreferenceType `yaml:"type"`
+	Units       string         `yaml:"units"`
+	Default     *string        `yaml:"default"`
+	Min         *float64       `yaml:"min"`
+	Max         *float64       `yaml:"max"`
+	Allowed     []string       `yaml:"allowed"`
+	Description string         `yaml:"description"`
+	// Since is the AAPS version the preference was introduced in
+	Since string `yaml:"since"`
+	// Removed is the AAPS version the preference was removed in
+	Removed string `yaml:"removed"`
+}
+
+//go:embed catalog/preferences.yaml
+var catalogYaml []byte
+
+// PreferenceCatalog contains all known preferences: the embedded catalog, and the preferences of the Objectives
+var PreferenceCatalog = loadCatalog()
+
+func loadCatalog() []KnownPreference {
+	catalog := struct {
+		Preferences []KnownPreference `yaml:"preferences"`
+	}{}
+	if err := yaml.Unmarshal(catalogYaml, &catalog); err != nil {
+		panic(fmt.Errorf("invalid preference catalog: %w", err))
+	}
+
+	return append(catalog.Preferences, objectivePreferences()...)
+}
+
+// objectivePreferences generates the catalog entries for the preferences of the Objectives
+func objectivePreferences() []KnownPreference {
+	var prefs []KnownPreference
+	zero := "0"
+
+	for i := range Objectives {
+		obj := &Objectives[i]
+		prefs = append(prefs,
+			KnownPreference{
+				Key:         obj.StartedPrefKey(),
+				Type:        TypeInt,
+				Units:       "ms since epoch",
+				Default:     &zero,
+				Description: fmt.Sprintf("When objective %d (%s) was started", obj.Number, obj.Name),
+			},
+			KnownPreference{
+				Key:         obj.AccomplishedPrefKey(),
+				Type:        TypeInt,
+				Units:       "ms since epoch",
+				Default:     &zero,
+				Description: fmt.Sprintf("When objective %d (%s) was accomplished", obj.Number, obj.Name),
+			},
+		)
+
+		for _, task := range obj.Tasks {
+			defaultValue := fmt.Sprintf("%v", task.defaultValue)
+			pref := KnownPreference{
+				Key:         task.key,
+				Type:        TypeString,
+				Default:     &defaultValue,
+				Description: fmt.Sprintf("Task of objective %d (%s)", obj.Number, obj.Name),
+			}
+			switch task.defaultValue.(type) {
+			case bool:
+				pref.Type = TypeBool
+			case int:
+				pref.Type = TypeInt
+			}
+			if strings.HasPrefix(task.key, "DisabledTo_") {
+				pref.Units = "ms since epoch"
+			}
+			prefs = append(prefs, pref)
+		}
+	}
+
+	return prefs
+}
+
+// LookupPreference finds the catalog entry of a preference key which applies to the given AAPS version.
+// Exact keys take precedence over patterns. An empty version matches every entry.
+func LookupPreference(key string, version string) *KnownPreference {
+	var patternMatch *KnownPreference
+	for i := range PreferenceCatalog {
+		pref := &PreferenceCatalog[i]
+		if !pref.AvailableIn(version) {
+			continue
+		}
+		if pref.Key == key {
+			return pref
+		}
+		if patternMatch == nil && strings.Contains(pref.Key, "*") && matchPattern(pref.Key, key) {
+			patternMatch = pref
+		}
+	}
+	return patternMatch
+}
+
+// AvailableIn checks whether the preference exists in the given AAPS version. An empty version is always available.
+func (p *KnownPreference) AvailableIn(version string) bool {
+	if version == "" {
+		return true
+	}
+	if p.Since != "" && CompareVersions(version, p.Since) < 0 {
+		return false
+	}
+	if p.Removed != "" && CompareVersions(version, p.Removed) >= 0 {
+		return false
+	}
+	return true
+}
+
+// Parse converts the string value of the preference to its native type: bool, int64, float64, string, or the
+// decoded JSON for json preferences
+func (p *KnownPreference) Parse(value string) (interface{}, error) {
+	switch p.Type {
+	case TypeBool:
+		// AAPS writes booleans as "true" or "false", and doesn't read other spellings such as "1" as booleans
+		switch value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid bool")
+	case TypeInt:
+		return strconv.ParseInt(value, 10, 64)
+	case TypeDouble:
+		return strconv.ParseFloat(value, 64)
+	case TypeJson:
+		if !gjson.Valid(value) {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+		return gjson.Parse(value).Value(), nil
+	default:
+		return value, nil
+	}
+}
+
+// IsDefault checks whether the value is the default value of the preference. Values are compared by their type,
+// so "1" and "1.0" are equal for a double. It's false if the preference has no known default.
+func (p *KnownPreference) IsDefault(value string) bool {
+	if p.Default == nil {
+		return false
+	}
+	if value == *p.Default {
+		return true
+	}
+
+	parsedValue, err := p.Parse(value)
+	if err != nil {
+		return false
+	}
+	parsedDefault, err := p.Parse(*p.Default)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(parsedValue, parsedDefault)
+}
+
+// ChangedPreferences returns the sorted keys of the preferences which differ from their default value for the AAPS
+// version. Preferences without a known default, which includes all unknown preferences, can't be compared and are
+// returned separately.
+func ChangedPreferences(prefs []byte, version string) (changed []string, withoutDefault []string) {
+	for _, key := range PreferenceKeys(prefs) {
+		value, _ := GetPreference(prefs, key)
+		pref := LookupPreference(key, version)
+		switch {
+		case pref == nil || pref.Default == nil:
+			withoutDefault = append(withoutDefault, key)
+		case !pref.IsDefault(value):
+			changed = append(changed, key)
+		}
+	}
+	return changed, withoutDefault
+}
+
+// Validate checks whether the value has the type of the preference, and is within its allowed values and range
+func (p *KnownPreference) Validate(value string) error {
+	parsed, err := p.Parse(value)
+	if err != nil {
+		return fmt.Errorf("\"%s\" is not a valid %s", value, p.Type)
+	}
+
+	if len(p.Allowed) > 0 {
+		allowed := false
+		for _, a := range p.Allowed {
+			if a == value {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("\"%s\" is not one of %s", value, strings.Join(p.Allowed, ", "))
+		}
+	}
+
+	var number float64
+	switch v := parsed.(type) {
+	case int64:
+		number = float64(v)
+	case float64:
+		number = v
+	default:
+		return nil
+	}
+	if p.Min != nil && number < *p.Min {
+		return fmt.Errorf("%s is below the minimum of %s", value, formatNumber(*p.Min))
+	}
+	if p.Max != nil && number > *p.Max {
+		return fmt.Errorf("%s is above the maximum of %s", value, formatNumber(*p.Max))
+	}
+	return nil
+}
+
+// ExportVersion returns the AAPS version an export was created with
+func ExportVersion(exportJson []byte) string {
+	return gjson.GetBytes(exportJson, "metadata.aaps_version").String()
+}
+
+// CompareVersions compares two dotted AAPS versions such as "3.1.0.3", returning -1, 0 or 1.
+// Missing parts count as zero, and any suffix after the numbers of a part (like "-dev") is ignored.
+func CompareVersions(a string, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aNum, bNum := versionPart(aParts, i), versionPart(bParts, i)
+		if aNum < bNum {
+			return -1
+		}
+		if aNum > bNum {
+			return 1
+		}
+	}
+	return 0
+}
+
+// CompareVersionsTo compares a version with a target version, only looking at as many components as the target has.
+// The target 3.2 is so equal to 3.2.0 and 3.2.1.3.
+func CompareVersionsTo(version string, target string) int {
+	parts := strings.Split(version, ".")
+	if n := len(strings.Split(target, ".")); len(parts) > n {
+		parts = parts[:n]
+	}
+	return CompareVersions(strings.Join(parts, "."), target)
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	digits := parts[i]
+	for j, c := range digits {
+		if c < '0' || c > '9' {
+			digits = digits[:j]
+			break
+		}
+	}
+	num, _ := strconv.Atoi(digits)
+	return num
+}
+
+// matchPattern matches a key against a pattern where '*' matches any characters
+func matchPattern(pattern string, key string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(key, parts[0]) {
+		return false
+	}
+	key = key[len(parts[0]):]
+
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(key, part)
+		}
+		index := strings.Index(key, part)
+		if index < 0 {
+			return false
+		}
+		key = key[index+len(part):]
+	}
+	return key == ""
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
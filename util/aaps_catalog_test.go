@@ -0,0 +1,94 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKnownPreferenceValidate(t *testing.T) {
+	min, max := 3.0, 60.0
+	tests := []struct {
+		pref  KnownPreference
+		value string
+		valid bool
+	}{
+		{KnownPreference{Type: TypeBool}, "true", true},
+		{KnownPreference{Type: TypeBool}, "false", true},
+		{KnownPreference{Type: TypeBool}, "1", false},
+		{KnownPreference{Type: TypeBool}, "t", false},
+		{KnownPreference{Type: TypeBool}, "TRUE", false},
+		{KnownPreference{Type: TypeBool}, "", false},
+		{KnownPreference{Type: TypeInt, Min: &min, Max: &max}, "15", true},
+		{KnownPreference{Type: TypeInt, Min: &min, Max: &max}, "2", false},
+		{KnownPreference{Type: TypeInt, Min: &min, Max: &max}, "61", false},
+		{KnownPreference{Type: TypeInt}, "1.5", false},
+		{KnownPreference{Type: TypeDouble, Min: &min}, "3.5", true},
+		{KnownPreference{Type: TypeString, Allowed: []string{"mg/dl", "mmol"}}, "mmol", true},
+		{KnownPreference{Type: TypeString, Allowed: []string{"mg/dl", "mmol"}}, "mmol/l", false},
+		{KnownPreference{Type: TypeJson}, `{"a":1}`, true},
+		{KnownPreference{Type: TypeJson}, `{"a":`, false},
+	}
+
+	for _, test := range tests {
+		err := test.pref.Validate(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("Validate(%q) of %s = %v, want valid %v", test.value, test.pref.Type, err, test.valid)
+		}
+	}
+}
+
+func TestKnownPreferenceIsDefault(t *testing.T) {
+	one, yes := "1", "true"
+	tests := []struct {
+		pref  KnownPreference
+		value string
+		want  bool
+	}{
+		{KnownPreference{Type: TypeDouble, Default: &one}, "1.0", true},
+		{KnownPreference{Type: TypeDouble, Default: &one}, "1.5", false},
+		{KnownPreference{Type: TypeBool, Default: &yes}, "true", true},
+		{KnownPreference{Type: TypeBool, Default: &yes}, "TRUE", false},
+		{KnownPreference{Type: TypeString}, "", false},
+	}
+
+	for _, test := range tests {
+		if got := test.pref.IsDefault(test.value); got != test.want {
+			t.Errorf("IsDefault(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"danars_pairingkey_*", "danars_pairingkey_UHH", true},
+		{"danars_pairingkey_*", "danars_address", false},
+		{"combo_*address*", "combo_bt_address_key", true},
+		{"*_Enabled", "ConfigBuilder_PUMP_X_Enabled", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "acb", false},
+		{"exact", "exact", true},
+		{"exact", "exact_not", false},
+	}
+
+	for _, test := range tests {
+		if got := matchPattern(test.pattern, test.key); got != test.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", test.pattern, test.key, got, test.want)
+		}
+	}
+}
+
+func TestChangedPreferences(t *testing.T) {
+	prefs := []byte(`{"units":"mmol","age":"adult","loop_openmode_min_change":"30","LocalProfile_0_name":"Default","my_key":"x"}`)
+
+	changed, withoutDefault := ChangedPreferences(prefs, "")
+	if !reflect.DeepEqual(changed, []string{"units"}) {
+		t.Errorf("got changed %v", changed)
+	}
+	if !reflect.DeepEqual(withoutDefault, []string{"LocalProfile_0_name", "my_key"}) {
+		t.Errorf("got without default %v", withoutDefault)
+	}
+}
@@ -0,0 +1,122 @@
+package util
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"gopkg.in/yaml.v3"
+)
+
+// DeviceBoundDriver lists the preferences of a pump driver which are bound to the device it's paired with
+type DeviceBoundDriver struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Keys are preference keys, or patterns with '*' matching any characters
+	Keys []string `yaml:"keys"`
+}
+
+//go:embed catalog/devicebound.yaml
+var deviceBoundYaml []byte
+
+// DeviceBoundDrivers contains the built-in drivers with device-bound preferences
+var DeviceBoundDrivers = loadDeviceBoundDrivers()
+
+func loadDeviceBoundDrivers() []DeviceBoundDriver {
+	catalog := struct {
+		Drivers []DeviceBoundDriver `yaml:"drivers"`
+	}{}
+	if err := yaml.Unmarshal(deviceBoundYaml, &catalog); err != nil {
+		panic(fmt.Errorf("invalid device-bound preference catalog: %w", err))
+	}
+	return catalog.Drivers
+}
+
+// LoadDeviceBoundDrivers parses a YAML file of drivers and combines them with the built-in DeviceBoundDrivers.
+// A driver with the same name as a built-in driver extends it with additional keys.
+//
+// Example:
+//
+//	drivers:
+//	  - name: dana
+//	    keys: [danars_custom_key]
+//	  - name: mypump
+//	    description: My pump pairing
+//	    keys: [mypump_address]
+func LoadDeviceBoundDrivers(data []byte) ([]DeviceBoundDriver, error) {
+	config := struct {
+		Drivers []DeviceBoundDriver `yaml:"drivers"`
+	}{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	drivers := make([]DeviceBoundDriver, len(DeviceBoundDrivers))
+	copy(drivers, DeviceBoundDrivers)
+
+	for _, custom := range config.Drivers {
+		if custom.Name == "" {
+			return nil, fmt.Errorf("drivers must have a name")
+		}
+
+		existing := findDeviceBoundDriver(drivers, custom.Name)
+		if existing == nil {
+			drivers = append(drivers, custom)
+			continue
+		}
+
+		existing.Keys = append(append([]string{}, existing.Keys...), custom.Keys...)
+		if custom.Description != "" {
+			existing.Description = custom.Description
+		}
+	}
+
+	return drivers, nil
+}
+
+func findDeviceBoundDriver(drivers []DeviceBoundDriver, name string) *DeviceBoundDriver {
+	for i := range drivers {
+		if drivers[i].Name == name {
+			return &drivers[i]
+		}
+	}
+	return nil
+}
+
+// Matches checks whether a preference key is bound to the device of the driver
+func (d *DeviceBoundDriver) Matches(key string) bool {
+	for _, pattern := range d.Keys {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceBoundChange is a device-bound preference removed by StripDeviceBoundPreferences
+type DeviceBoundChange struct {
+	Driver string
+	Change
+}
+
+// StripDeviceBoundPreferences removes the preferences which are bound to the device of any of the drivers, returning
+// the removed preferences with the first driver they matched
+func StripDeviceBoundPreferences(prefs []byte, drivers []DeviceBoundDriver) ([]byte, []DeviceBoundChange) {
+	var changes []DeviceBoundChange
+	for _, key := range PreferenceKeys(prefs) {
+		for i := range drivers {
+			if !drivers[i].Matches(key) {
+				continue
+			}
+			value, _ := GetPreference(prefs, key)
+			changes = append(changes, DeviceBoundChange{
+				Driver: drivers[i].Name,
+				Change: Change{Key: key, OldValue: value, Removed: true},
+			})
+			prefs = DeletePreference(prefs, key)
+			break
+		}
+	}
+	return prefs, changes
+}
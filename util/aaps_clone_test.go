@@ -0,0 +1,172 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestDeviceBoundDrivers(t *testing.T) {
+	tests := []struct {
+		driver string
+		// bound are keys the driver stores for the device it's paired with
+		bound []string
+		// settings are keys of the driver which aren't bound to a device
+		settings []string
+	}{
+		{
+			driver: "dana",
+			bound: []string{
+				"danar_bt_name",
+				"danars_address",
+				"danars_name",
+				"danars_pairingkey_UHH00002TI",
+				"danars_v3_pairingkey_UHH00002TI",
+				"danars_v3_randompairingkey_UHH00002TI",
+				"danars_v3_randomsynckey_UHH00002TI",
+				"dana_ble5_pairingkeyUHH00002TI",
+			},
+			settings: []string{"danars_bolusspeed", "danar_useextended", "danar_visualizeextendedaspercentage"},
+		},
+		{
+			driver: "combo",
+			bound: []string{
+				"combov2-bt-address-key",
+				"combov2-nonce-key",
+				"combov2-cp-key-key",
+				"combov2-pc-key-key",
+				"combov2-key-response-address-key",
+				"combov2-pump-id-key",
+			},
+			settings: []string{"combov2_verbose_logging", "combov2_automatic_reservoir_entry"},
+		},
+		{
+			driver: "insight",
+			bound: []string{
+				"insight_paired",
+				"insight_mac_address",
+				"insight_incoming_key",
+				"insight_outgoing_key",
+				"insight_last_nonce_sent",
+				"insight_last_nonce_received",
+				"insight_system_identification_serial_number",
+				"insight_system_identification_system_id_appendix",
+				"insight_firmware_versions_release_software_version",
+			},
+			settings: []string{"insight_log_reservoir_changes", "insight_enable_tbr_emulation", "insight_disconnect_delay"},
+		},
+		{
+			driver:   "rileylink",
+			bound:    []string{"pref_rileylink_mac_address", "pref_rileylink_name"},
+			settings: []string{"pref_medtronic_encoding", "pref_show_riley_link_battery"},
+		},
+		{
+			driver:   "medtronic",
+			bound:    []string{"pref_medtronic_serial"},
+			settings: []string{"pref_medtronic_pump_type", "pref_medtronic_frequency", "pref_medtronic_max_basal"},
+		},
+		{
+			driver:   "omnipod",
+			bound:    []string{"AAPS.Omnipod.pod_state", "AAPS.OmnipodDash.pod_state"},
+			settings: []string{"AAPS.Omnipod.bolus_beeps_enabled", "AAPS.Omnipod.tbr_beeps_enabled"},
+		},
+		{
+			driver:   "diaconn",
+			bound:    []string{"diaconn_g8_address", "diaconn_g8_name"},
+			settings: []string{"diaconn_g8_loginsulinchange", "diaconn_g8_bolusspeed"},
+		},
+		{
+			driver: "eopatch",
+			bound: []string{
+				"eopatch_patch_config",
+				"eopatch_patch_state",
+				"eopatch_bolus_current",
+				"eopatch_normal_basal",
+				"eopatch_temp_basal",
+				"eopatch_alarms",
+			},
+			settings: []string{"eopatch_low_reservoir_reminders", "eopatch_expiration_reminders", "eopatch_buzzer_reminders"},
+		},
+		{
+			driver: "medtrum",
+			bound: []string{
+				"medtrum_sn_input",
+				"medtrum_patch_id",
+				"medtrum_session_token",
+				"medtrum_pump_state",
+				"medtrum_current_sequence_number",
+				"medtrum_sync_sequence_number",
+			},
+			settings: []string{"medtrum_alarm_setting", "medtrum_hourly_max_insulin", "medtrum_daily_max_insulin"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.driver, func(t *testing.T) {
+			driver := findDeviceBoundDriver(DeviceBoundDrivers, test.driver)
+			if driver == nil {
+				t.Fatalf("driver %s is missing", test.driver)
+			}
+			for _, key := range test.bound {
+				if !driver.Matches(key) {
+					t.Errorf("%s doesn't match device-bound key %s", test.driver, key)
+				}
+			}
+			for _, key := range test.settings {
+				for i := range DeviceBoundDrivers {
+					if DeviceBoundDrivers[i].Matches(key) {
+						t.Errorf("%s matches setting %s", DeviceBoundDrivers[i].Name, key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStripDeviceBoundPreferences(t *testing.T) {
+	prefs := []byte(`{"danars_address":"AA:BB:CC:DD:EE:FF","danars_pairingkey_UHH00002TI":"0102","danars_bolusspeed":"0","combov2-nonce-key":"42","units":"mg/dl"}`)
+
+	stripped, changes := StripDeviceBoundPreferences(prefs, DeviceBoundDrivers)
+
+	want := map[string]string{
+		"danars_address":               "dana",
+		"danars_pairingkey_UHH00002TI": "dana",
+		"combov2-nonce-key":            "combo",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %v", len(changes), len(want), changes)
+	}
+	for _, change := range changes {
+		if want[change.Key] != change.Driver || !change.Removed {
+			t.Errorf("unexpected change %+v", change)
+		}
+		if _, ok := GetPreference(stripped, change.Key); ok {
+			t.Errorf("%s wasn't removed", change.Key)
+		}
+	}
+	for _, key := range []string{"danars_bolusspeed", "units"} {
+		if _, ok := GetPreference(stripped, key); !ok {
+			t.Errorf("%s was removed", key)
+		}
+	}
+}
+
+func TestLoadDeviceBoundDrivers(t *testing.T) {
+	drivers, err := LoadDeviceBoundDrivers([]byte("drivers:\n  - name: dana\n    keys: [danars_custom]\n  - name: mypump\n    keys: [mypump_*]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dana := findDeviceBoundDriver(drivers, "dana")
+	if !dana.Matches("danars_custom") || !dana.Matches("danars_address") {
+		t.Errorf("dana wasn't extended: %v", dana.Keys)
+	}
+	if builtIn := findDeviceBoundDriver(DeviceBoundDrivers, "dana"); builtIn.Matches("danars_custom") {
+		t.Errorf("the built-in dana driver was modified")
+	}
+	if mypump := findDeviceBoundDriver(drivers, "mypump"); mypump == nil || !mypump.Matches("mypump_address") {
+		t.Errorf("mypump wasn't added")
+	}
+
+	if _, err := LoadDeviceBoundDrivers([]byte("drivers:\n  - keys: [x]\n")); err == nil {
+		t.Errorf("a driver without a name was accepted")
+	}
+}
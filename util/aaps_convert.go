@@ -0,0 +1,340 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+// editableExport prepares a decrypted export for conversion to another representation: the preferences are stored as
+// an object of plain strings
+func editableExport(exportJson []byte) ([]byte, error) {
+	if IsEncrypted(exportJson) {
+		return nil, errors.New("the export is encrypted, decrypt it first")
+	}
+	if !IsPreferencesObject(exportJson) {
+		exportJson = ConvertPreferencesToObject(exportJson)
+	}
+	content := UntypePreferences([]byte(gjson.GetBytes(exportJson, "content").Raw))
+	exportJson, _ = sjson.SetRawBytes(exportJson, "content", content)
+	return exportJson, nil
+}
+
+// ExportToYaml converts a decrypted export to YAML. The comments of an earlier YAML version of the export are carried
+// over to the same keys, and with describe, known preferences without a comment get their catalog description.
+func ExportToYaml(exportJson []byte, comments []byte, describe bool) ([]byte, error) {
+	exportJson, err := editableExport(exportJson)
+	if err != nil {
+		return nil, err
+	}
+
+	root := jsonToYamlNode(gjson.ParseBytes(exportJson))
+
+	if len(comments) > 0 {
+		var previous yaml.Node
+		if err := yaml.Unmarshal(comments, &previous); err != nil {
+			return nil, fmt.Errorf("cannot read comments: %w", err)
+		}
+		if len(previous.Content) > 0 {
+			copyYamlComments(previous.Content[0], root)
+		}
+	}
+
+	if describe {
+		version := ExportVersion(exportJson)
+		if content := yamlMappingValue(root, "content"); content != nil {
+			for i := 0; i+1 < len(content.Content); i += 2 {
+				key := content.Content[i]
+				pref := LookupPreference(key.Value, version)
+				if key.HeadComment != "" || pref == nil || pref.Description == "" {
+					continue
+				}
+				key.HeadComment = pref.Description
+				if pref.Units != "" {
+					key.HeadComment += " (" + pref.Units + ")"
+				}
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// ExportFromYaml converts a YAML export back to the JSON export AAPS imports, with the preferences stored as a string.
+// Every preference value is used as a string exactly as written, and nested mappings and sequences are serialized
+// into compact JSON strings.
+func ExportFromYaml(data []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("expected a mapping at the top level")
+	}
+	root := document.Content[0]
+
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], resolveYamlAlias(root.Content[i+1])
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(jsonString(key.Value))
+		out.WriteByte(':')
+
+		if key.Value != "content" {
+			raw, err := yamlNodeToJson(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key.Value, err)
+			}
+			out.Write(raw)
+			continue
+		}
+
+		if value.Kind != yaml.MappingNode {
+			return nil, errors.New("content: expected a mapping of preferences")
+		}
+		out.WriteByte('{')
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			prefKey, prefValue := value.Content[j], resolveYamlAlias(value.Content[j+1])
+			if j > 0 {
+				out.WriteByte(',')
+			}
+			out.Write(jsonString(prefKey.Value))
+			out.WriteByte(':')
+
+			if prefValue.Kind == yaml.ScalarNode {
+				out.Write(jsonString(prefValue.Value))
+				continue
+			}
+			raw, err := yamlNodeToJson(prefValue)
+			if err != nil {
+				return nil, fmt.Errorf("preference \"%s\": %w", prefKey.Value, err)
+			}
+			out.Write(jsonString(string(raw)))
+		}
+		out.WriteByte('}')
+	}
+	out.WriteByte('}')
+
+	return finishConvertedExport(out.Bytes())
+}
+
+// ExportToToml converts a decrypted export to TOML
+func ExportToToml(exportJson []byte) ([]byte, error) {
+	exportJson, err := editableExport(exportJson)
+	if err != nil {
+		return nil, err
+	}
+
+	var export map[string]interface{}
+	if err := json.Unmarshal(exportJson, &export); err != nil {
+		return nil, err
+	}
+	return toml.Marshal(export)
+}
+
+// ExportFromToml converts a TOML export back to the JSON export AAPS imports, with the preferences stored as a string.
+// Preference values must be strings, booleans or integers. Floats are rejected because TOML doesn't keep their exact
+// text, which AAPS relies on.
+func ExportFromToml(data []byte) ([]byte, error) {
+	var export map[string]interface{}
+	if err := toml.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	content, ok := export["content"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("content: expected a table of preferences")
+	}
+	for key, value := range content {
+		switch v := value.(type) {
+		case string:
+		case bool:
+			content[key] = strconv.FormatBool(v)
+		case int64:
+			content[key] = strconv.FormatInt(v, 10)
+		default:
+			return nil, fmt.Errorf("preference \"%s\": expected a string, write the value in quotes", key)
+		}
+	}
+
+	exportJson, err := marshalJson(export)
+	if err != nil {
+		return nil, err
+	}
+	return finishConvertedExport(exportJson)
+}
+
+// finishConvertedExport stores the preferences of a converted export as a string and recalculates the file hash
+func finishConvertedExport(exportJson []byte) ([]byte, error) {
+	if !json.Valid(exportJson) {
+		return nil, errors.New("the converted export is not valid JSON")
+	}
+	if IsEncrypted(exportJson) {
+		return nil, errors.New("encrypted exports cannot be converted, decrypt them first")
+	}
+	return ConvertPreferencesToString(pretty.Pretty(exportJson)), nil
+}
+
+func jsonToYamlNode(value gjson.Result) *yaml.Node {
+	switch {
+	case value.IsObject():
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		value.ForEach(func(k, v gjson.Result) bool {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.String()}, jsonToYamlNode(v))
+			return true
+		})
+		return node
+	case value.IsArray():
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		value.ForEach(func(_, v gjson.Result) bool {
+			node.Content = append(node.Content, jsonToYamlNode(v))
+			return true
+		})
+		return node
+	}
+
+	switch value.Type {
+	case gjson.Number:
+		tag := "!!int"
+		if strings.ContainsAny(value.Raw, ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value.Raw}
+	case gjson.True, gjson.False:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: value.Raw}
+	case gjson.Null:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value.String()}
+	}
+}
+
+func yamlNodeToJson(node *yaml.Node) ([]byte, error) {
+	node = resolveYamlAlias(node)
+
+	var out bytes.Buffer
+	switch node.Kind {
+	case yaml.MappingNode:
+		out.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			value, err := yamlNodeToJson(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			out.Write(jsonString(node.Content[i].Value))
+			out.WriteByte(':')
+			out.Write(value)
+		}
+		out.WriteByte('}')
+	case yaml.SequenceNode:
+		out.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			value, err := yamlNodeToJson(item)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(value)
+		}
+		out.WriteByte(']')
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!int", "!!float":
+			if !json.Valid([]byte(node.Value)) {
+				return nil, fmt.Errorf("\"%s\" is not a JSON number", node.Value)
+			}
+			out.WriteString(node.Value)
+		case "!!bool":
+			out.WriteString(strings.ToLower(node.Value))
+		case "!!null":
+			out.WriteString("null")
+		default:
+			out.Write(jsonString(node.Value))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported YAML node at line %d", node.Line)
+	}
+	return out.Bytes(), nil
+}
+
+func resolveYamlAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// copyYamlComments copies the comments of the keys and values of a mapping to the same keys of another mapping,
+// recursing into nested mappings
+func copyYamlComments(from *yaml.Node, to *yaml.Node) {
+	to.HeadComment, to.FootComment = from.HeadComment, from.FootComment
+	if from.Kind != yaml.MappingNode || to.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(to.Content); i += 2 {
+		key := to.Content[i]
+		for j := 0; j+1 < len(from.Content); j += 2 {
+			if from.Content[j].Value != key.Value {
+				continue
+			}
+			fromKey, fromValue := from.Content[j], from.Content[j+1]
+			key.HeadComment, key.LineComment, key.FootComment = fromKey.HeadComment, fromKey.LineComment, fromKey.FootComment
+			to.Content[i+1].LineComment = fromValue.LineComment
+			if fromValue.Kind == yaml.MappingNode {
+				copyYamlComments(fromValue, to.Content[i+1])
+			}
+			break
+		}
+	}
+}
+
+// marshalJson encodes JSON without escaping HTML characters, so strings keep their exact text
+func marshalJson(v interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+func jsonString(s string) []byte {
+	out, _ := marshalJson(s)
+	return out
+}
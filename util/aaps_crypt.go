@@ -81,6 +81,17 @@ func ParseAAPSEncoding(content string) (nonce []byte, cipherText []byte, err err
 }
 
 func Encrypt(passphrase []byte, salt []byte, rawData []byte) ([]byte, error) {
+	nonce := make([]byte, IvLengthByte)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return EncryptWithNonce(passphrase, salt, nonce, rawData)
+}
+
+// EncryptWithNonce encrypts like Encrypt, but with the given nonce instead of a random one.
+// A nonce must never be reused with the same passphrase and salt for different data.
+func EncryptWithNonce(passphrase []byte, salt []byte, nonce []byte, rawData []byte) ([]byte, error) {
 	key := DeriveKey(passphrase, salt)
 
 	block, err := aes.NewCipher(key)
@@ -88,11 +99,6 @@ func Encrypt(passphrase []byte, salt []byte, rawData []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	nonce := make([]byte, IvLengthByte)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
-
 	aesgcm, err := cipher.NewGCMWithTagSize(block, TagLengthBit/8)
 	if err != nil {
 		return nil, err
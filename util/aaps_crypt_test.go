@@ -0,0 +1,81 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, SaltSizeByte)
+	nonce := bytes.Repeat([]byte{2}, IvLengthByte)
+
+	tests := []struct {
+		name    string
+		encrypt func(data []byte) ([]byte, error)
+	}{
+		{"random nonce", func(data []byte) ([]byte, error) { return Encrypt([]byte("secret"), salt, data) }},
+		{"given nonce", func(data []byte) ([]byte, error) { return EncryptWithNonce([]byte("secret"), salt, nonce, data) }},
+	}
+
+	for _, test := range tests {
+		for _, data := range []string{"x", testPrefs} {
+			encrypted, err := test.encrypt([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decrypted, err := Decrypt([]byte("secret"), salt, string(encrypted))
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			if string(decrypted) != data {
+				t.Errorf("%s: got %s, want %s", test.name, decrypted, data)
+			}
+
+			if _, err := Decrypt([]byte("wrong"), salt, string(encrypted)); err == nil {
+				t.Errorf("%s: decrypting with a wrong password succeeded", test.name)
+			}
+			if _, err := Decrypt([]byte("secret"), bytes.Repeat([]byte{3}, SaltSizeByte), string(encrypted)); err == nil {
+				t.Errorf("%s: decrypting with a wrong salt succeeded", test.name)
+			}
+		}
+	}
+}
+
+func TestEncryptWithNonce(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, SaltSizeByte)
+	nonce := bytes.Repeat([]byte{2}, IvLengthByte)
+
+	first, err := EncryptWithNonce([]byte("secret"), salt, nonce, []byte(testPrefs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := EncryptWithNonce([]byte("secret"), salt, nonce, []byte(testPrefs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("encrypting with the same nonce gave different output")
+	}
+
+	gotNonce, _, err := ParseAAPSEncoding(string(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotNonce, nonce) {
+		t.Errorf("got nonce %x, want %x", gotNonce, nonce)
+	}
+}
+
+func TestParseAAPSEncodingErrors(t *testing.T) {
+	tests := map[string]string{
+		"not base64": "not base64!",
+		"empty":      "",
+	}
+
+	for name, content := range tests {
+		if _, _, err := ParseAAPSEncoding(content); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"sort"
+)
+
+// Change is a single difference between two flat JSON objects, such as the preferences or metadata of an export
+type Change struct {
+	Key      string
+	OldValue string
+	NewValue string
+	// Added is set when the key only exists in the new object
+	Added bool
+	// Removed is set when the key only exists in the old object
+	Removed bool
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Added:
+		return fmt.Sprintf("+ %s = %q", c.Key, c.NewValue)
+	case c.Removed:
+		return fmt.Sprintf("- %s = %q", c.Key, c.OldValue)
+	default:
+		return fmt.Sprintf("~ %s: %q -> %q", c.Key, c.OldValue, c.NewValue)
+	}
+}
+
+// DiffObjects compares the values of two flat JSON objects and returns the changes sorted by key.
+// Nested values are compared by their raw JSON.
+func DiffObjects(oldJson []byte, newJson []byte) []Change {
+	oldValues := objectValues(oldJson)
+	newValues := objectValues(newJson)
+
+	var changes []Change
+	for key, oldValue := range oldValues {
+		newValue, ok := newValues[key]
+		if !ok {
+			changes = append(changes, Change{Key: key, OldValue: oldValue, Removed: true})
+		} else if oldValue != newValue {
+			changes = append(changes, Change{Key: key, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	for key, newValue := range newValues {
+		if _, ok := oldValues[key]; !ok {
+			changes = append(changes, Change{Key: key, NewValue: newValue, Added: true})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// DiffExports compares the preferences and metadata of two unencrypted exports.
+// Metadata keys are prefixed with "metadata." to tell them apart from preferences. Typed and expanded preferences are
+// compared by the strings AAPS stores them as.
+func DiffExports(oldExportJson []byte, newExportJson []byte) []Change {
+	changes := DiffObjects(
+		UntypePreferences([]byte(gjson.GetBytes(oldExportJson, "content").String())),
+		UntypePreferences([]byte(gjson.GetBytes(newExportJson, "content").String())),
+	)
+
+	for _, change := range DiffObjects(
+		[]byte(gjson.GetBytes(oldExportJson, "metadata").Raw),
+		[]byte(gjson.GetBytes(newExportJson, "metadata").Raw),
+	) {
+		change.Key = "metadata." + change.Key
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+func objectValues(objectJson []byte) map[string]string {
+	values := make(map[string]string)
+	gjson.ParseBytes(objectJson).ForEach(func(key, value gjson.Result) bool {
+		if value.IsObject() || value.IsArray() {
+			values[key.String()] = string(pretty.Ugly([]byte(value.Raw)))
+		} else {
+			values[key.String()] = value.String()
+		}
+		return true
+	})
+	return values
+}
+
+// DiffExportFiles compares two export files, decrypting them with the password when needed.
+// Besides preferences and metadata, changes to the storage of the export (format, encryption algorithm and
+// string, object, typed and expanded preferences) are included, as well as the file hash when it was invalid before.
+func DiffExportFiles(oldFileJson []byte, newFileJson []byte, password string) ([]Change, error) {
+	var changes []Change
+
+	storage := func(exportJson []byte) map[string]string {
+		contentStorage := "string"
+		if IsPreferencesObject(exportJson) {
+			content := []byte(gjson.GetBytes(exportJson, "content").Raw)
+			contentStorage = "object"
+			if HasExpandedPreferences(content) {
+				contentStorage = "expanded " + contentStorage
+			}
+			if HasTypedPreferences(content) {
+				contentStorage = "typed " + contentStorage
+			}
+		}
+		return map[string]string{
+			"format":             gjson.GetBytes(exportJson, "format").String(),
+			"security.algorithm": gjson.GetBytes(exportJson, "security.algorithm").String(),
+			"content (storage)":  contentStorage,
+		}
+	}
+	oldStorage := storage(oldFileJson)
+	newStorage := storage(newFileJson)
+	for _, key := range []string{"format", "security.algorithm", "content (storage)"} {
+		if oldStorage[key] != newStorage[key] {
+			changes = append(changes, Change{Key: key, OldValue: oldStorage[key], NewValue: newStorage[key]})
+		}
+	}
+
+	if !IsFileHashValid(oldFileJson) {
+		changes = append(changes, Change{
+			Key:      "security.file_hash",
+			OldValue: gjson.GetBytes(oldFileJson, "security.file_hash").String(),
+			NewValue: gjson.GetBytes(newFileJson, "security.file_hash").String(),
+		})
+	}
+
+	// the preferences can't have changed if the encrypted content is identical, so there's no need to decrypt
+	if IsEncrypted(oldFileJson) && IsEncrypted(newFileJson) &&
+		gjson.GetBytes(oldFileJson, "content").String() == gjson.GetBytes(newFileJson, "content").String() {
+		return changes, nil
+	}
+
+	var err error
+	if IsEncrypted(oldFileJson) {
+		oldFileJson, err = DecryptExport(oldFileJson, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if IsEncrypted(newFileJson) {
+		newFileJson, err = DecryptExport(newFileJson, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return append(changes, DiffExports(oldFileJson, newFileJson)...), nil
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffObjects(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []Change
+	}{
+		{"equal", `{"a":"1","b":"2"}`, `{"b":"2","a":"1"}`, nil},
+		{"changed", `{"a":"1"}`, `{"a":"2"}`, []Change{{Key: "a", OldValue: "1", NewValue: "2"}}},
+		{"added", `{}`, `{"a":"1"}`, []Change{{Key: "a", NewValue: "1", Added: true}}},
+		{"removed", `{"a":"1"}`, `{}`, []Change{{Key: "a", OldValue: "1", Removed: true}}},
+		{"nested values by raw JSON", `{"a":{"x": 1}}`, `{"a":{"x":1}}`, nil},
+		{
+			name: "sorted by key",
+			old:  `{"c":"1","a":"1"}`,
+			new:  `{"b":"1"}`,
+			want: []Change{
+				{Key: "a", OldValue: "1", Removed: true},
+				{Key: "b", NewValue: "1", Added: true},
+				{Key: "c", OldValue: "1", Removed: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if got := DiffObjects([]byte(test.old), []byte(test.new)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDiffExportFiles(t *testing.T) {
+	export := testExport(testPrefs)
+	encrypted, err := EncryptExport(export, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expanded, err := ConvertPreferencesToExpandedObject(ConvertPreferencesToString(export), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := testExport(`{"units":"mmol","use_smb":"true","openapsmb_max_iob":"3.0","QuickWizard":"[{\"buttonText\":\"Meal\",\"carbs\":30}]"}`)
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+		want []string
+	}{
+		{"same export", export, export, nil},
+		{"changed preference", export, changed, []string{"units"}},
+		{"string preferences", export, ConvertPreferencesToString(export), []string{"content (storage)"}},
+		{"typed preferences", export, ConvertPreferencesToTypedObject(export), []string{"content (storage)"}},
+		{"expanded preferences", ConvertPreferencesToTypedObject(export), expanded, []string{"content (storage)"}},
+		{"encrypted", export, encrypted, []string{"format", "security.algorithm", "content (storage)"}},
+		{"same encrypted export", encrypted, encrypted, nil},
+		{"invalid file hash", []byte(`{"format":"aaps_structured","security":{"file_hash":"x"},"content":{}}`), []byte(`{"format":"aaps_structured","content":{}}`), []string{"security.file_hash"}},
+	}
+
+	for _, test := range tests {
+		changes, err := DiffExportFiles(test.old, test.new, "secret")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var keys []string
+		for _, change := range changes {
+			keys = append(keys, change.Key)
+		}
+		if !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("%s: got changes %v, want %v", test.name, changes, test.want)
+		}
+	}
+
+	if _, err := DiffExportFiles(export, encrypted, "wrong"); err == nil {
+		t.Errorf("decrypting with a wrong password succeeded")
+	}
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"encoding/hex"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Export is a settings export which has been prepared for editing.
+// The preferences are always kept decrypted in memory, and the original storage format of the export (encryption and
+// string/object preferences) is restored by Bytes.
+type Export struct {
+	// Data is the export in unencrypted format, with the preferences stored as a JSON object
+	Data              []byte
+	Encrypted         bool
+	PreferencesObject bool
+	// PreferencesTyped is set when the preferences are stored with native JSON booleans and numbers.
+	// They're always strings in Data.
+	PreferencesTyped bool
+	// PreferencesExpanded is set when JSON serialized inside preference values is stored as objects and arrays.
+	// They're always strings in Data.
+	PreferencesExpanded bool
+	// Password is used to re-encrypt the export. It's only set when the export was encrypted.
+	Password string
+}
+
+// LoadExport prepares an export for editing, decrypting it if needed.
+// The password function is only called when the export is encrypted.
+func LoadExport(data []byte, password func() (string, error)) (*Export, error) {
+	export := &Export{
+		Encrypted:         IsEncrypted(data),
+		PreferencesObject: IsPreferencesObject(data),
+	}
+
+	if export.Encrypted {
+		var err error
+		export.Password, err = password()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = DecryptExport(data, export.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !IsPreferencesObject(data) {
+		data = ConvertPreferencesToObject(data)
+	}
+	export.Data = data
+
+	prefs := export.Preferences()
+	export.PreferencesTyped = HasTypedPreferences(prefs)
+	export.PreferencesExpanded = HasExpandedPreferences(prefs)
+	if export.PreferencesTyped || export.PreferencesExpanded {
+		if err := ValidateExpandedPreferences(prefs); err != nil {
+			return nil, err
+		}
+		export.SetPreferences(UntypePreferences(prefs))
+	}
+
+	return export, nil
+}
+
+// DecryptExport decrypts the preferences of an encrypted export and converts it to the unencrypted format
+func DecryptExport(encryptedExportJson []byte, password string) ([]byte, error) {
+	salt, _ := hex.DecodeString(gjson.GetBytes(encryptedExportJson, "security.salt").String())
+
+	decrypted, err := Decrypt([]byte(password), salt, gjson.GetBytes(encryptedExportJson, "content").String())
+	if err != nil {
+		return nil, err
+	}
+
+	return ConvertToUnencryptedFormat(encryptedExportJson, decrypted), nil
+}
+
+// EncryptExport encrypts the preferences of an unencrypted export with a newly generated salt
+func EncryptExport(exportJson []byte, password string) ([]byte, error) {
+	if IsPreferencesObject(exportJson) {
+		exportJson = ConvertPreferencesToString(exportJson)
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	content := []byte(gjson.GetBytes(exportJson, "content").String())
+	encrypted, err := Encrypt([]byte(password), salt, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConvertToEncryptedFormat(exportJson, salt, encrypted, Sha256(content)), nil
+}
+
+// EncryptExportDeterministic encrypts the preferences of an unencrypted export with a salt and nonce derived from the
+// password and the preferences, so encrypting the same export twice gives identical output. This is needed for
+// version control, but reveals whether two encrypted exports have the same preferences.
+func EncryptExportDeterministic(exportJson []byte, password string) ([]byte, error) {
+	if IsPreferencesObject(exportJson) {
+		exportJson = ConvertPreferencesToString(exportJson)
+	}
+
+	content := []byte(gjson.GetBytes(exportJson, "content").String())
+	salt, _ := hex.DecodeString(Hmac256(append([]byte("salt:"), content...), password))
+	nonceHash, _ := hex.DecodeString(Hmac256(append([]byte("nonce:"), content...), password))
+
+	encrypted, err := EncryptWithNonce([]byte(password), salt, nonceHash[:IvLengthByte], content)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConvertToEncryptedFormat(exportJson, salt, encrypted, Sha256(content)), nil
+}
+
+// Preferences returns the preferences of the export as a JSON object
+func (e *Export) Preferences() []byte {
+	return []byte(gjson.GetBytes(e.Data, "content").Raw)
+}
+
+// SetPreferences replaces the preferences of the export with the given JSON object
+func (e *Export) SetPreferences(prefs []byte) {
+	e.Data, _ = sjson.SetRawBytes(e.Data, "content", prefs)
+}
+
+// Bytes restores the original storage format of the export, re-encrypting it if needed, and recalculates the file hash
+func (e *Export) Bytes() ([]byte, error) {
+	if e.Encrypted {
+		return EncryptExport(e.Data, e.Password)
+	}
+
+	if e.PreferencesObject && e.PreferencesExpanded {
+		return ConvertPreferencesToExpandedObject(e.Data, e.PreferencesTyped)
+	}
+	if e.PreferencesObject && e.PreferencesTyped {
+		return ConvertPreferencesToTypedObject(e.Data), nil
+	}
+	if e.PreferencesObject {
+		return ConvertPreferencesToObject(e.Data), nil
+	}
+	return ConvertPreferencesToString(e.Data), nil
+}
@@ -0,0 +1,176 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const testPrefs = `{"units":"mg/dl","use_smb":"true","openapsmb_max_iob":"3.0","QuickWizard":"[{\"buttonText\":\"Meal\",\"carbs\":30}]"}`
+
+func testExport(content string) []byte {
+	return CalculateFileHash([]byte(`{"metadata":{"device_name":"Pixel","aaps_version":"3.2.0.4"},"format":"aaps_structured",` +
+		`"security":{"file_hash":"` + FileHashPlaceholder + `","algorithm":"none"},"content":` + content + `}`))
+}
+
+func testPassword(password string) func() (string, error) {
+	return func() (string, error) {
+		return password, nil
+	}
+}
+
+func TestLoadExportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		export   func(t *testing.T) []byte
+		password string
+		// want is the expected storage format: object, typed, expanded and encrypted
+		want [4]bool
+	}{
+		{
+			name:   "string",
+			export: func(t *testing.T) []byte { return testExport(string(jsonString(testPrefs))) },
+		},
+		{
+			name:   "object",
+			export: func(t *testing.T) []byte { return testExport(testPrefs) },
+			want:   [4]bool{true, false, false, false},
+		},
+		{
+			name:   "typed",
+			export: func(t *testing.T) []byte { return ConvertPreferencesToTypedObject(testExport(testPrefs)) },
+			want:   [4]bool{true, true, false, false},
+		},
+		{
+			name: "expanded",
+			export: func(t *testing.T) []byte {
+				export, err := ConvertPreferencesToExpandedObject(testExport(testPrefs), false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return export
+			},
+			want: [4]bool{true, false, true, false},
+		},
+		{
+			name: "typed and expanded",
+			export: func(t *testing.T) []byte {
+				export, err := ConvertPreferencesToExpandedObject(testExport(testPrefs), true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return export
+			},
+			want: [4]bool{true, true, true, false},
+		},
+		{
+			name: "encrypted",
+			export: func(t *testing.T) []byte {
+				export, err := EncryptExport(testExport(testPrefs), "secret")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return export
+			},
+			password: "secret",
+			want:     [4]bool{false, false, false, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			export, err := LoadExport(test.export(t), testPassword(test.password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExport(t, export, test.want)
+
+			data, err := export.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.want[3] && !IsFileHashValid(data) {
+				t.Errorf("invalid file hash")
+			}
+
+			reloaded, err := LoadExport(data, testPassword(test.password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExport(t, reloaded, test.want)
+		})
+	}
+}
+
+func checkExport(t *testing.T, export *Export, want [4]bool) {
+	t.Helper()
+
+	got := [4]bool{export.PreferencesObject, export.PreferencesTyped, export.PreferencesExpanded, export.Encrypted}
+	if got != want {
+		t.Errorf("got storage format %v, want %v", got, want)
+	}
+	if !jsonEqual(export.Preferences(), []byte(testPrefs)) {
+		t.Errorf("got preferences %s, want %s", export.Preferences(), testPrefs)
+	}
+}
+
+func TestLoadExportPasswordError(t *testing.T) {
+	encrypted, err := EncryptExport(testExport(testPrefs), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadExport(encrypted, testPassword("wrong")); err == nil {
+		t.Errorf("decrypting with a wrong password succeeded")
+	}
+
+	cancelled := errors.New("cancelled")
+	if _, err := LoadExport(encrypted, func() (string, error) { return "", cancelled }); err != cancelled {
+		t.Errorf("got %v, want the error of the password function", err)
+	}
+
+	// the password is only asked for when the export is encrypted
+	if _, err := LoadExport(testExport(testPrefs), nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncryptExportDeterministic(t *testing.T) {
+	export := testExport(testPrefs)
+	other := testExport(`{"units":"mmol"}`)
+
+	encrypt := func(export []byte, password string) []byte {
+		encrypted, err := EncryptExportDeterministic(export, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encrypted
+	}
+
+	tests := []struct {
+		name  string
+		a, b  []byte
+		equal bool
+	}{
+		{"same export", encrypt(export, "secret"), encrypt(export, "secret"), true},
+		{"other password", encrypt(export, "secret"), encrypt(export, "other"), false},
+		{"other preferences", encrypt(export, "secret"), encrypt(other, "secret"), false},
+	}
+	for _, test := range tests {
+		if bytes.Equal(test.a, test.b) != test.equal {
+			t.Errorf("%s: equal is %v, want %v", test.name, !test.equal, test.equal)
+		}
+	}
+
+	decrypted, err := DecryptExport(encrypt(export, "secret"), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadExport(decrypted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonEqual(loaded.Preferences(), []byte(testPrefs)) {
+		t.Errorf("got preferences %s, want %s", loaded.Preferences(), testPrefs)
+	}
+}
@@ -0,0 +1,276 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// PreferenceGroup is a named set of related preferences, such as all settings of the APS algorithm.
+// A preference belongs to the group if its key starts with one of the prefixes, or is one of the keys.
+type PreferenceGroup struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Prefixes    []string `yaml:"prefixes"`
+	Keys        []string `yaml:"keys"`
+}
+
+// Matches checks whether the preference key belongs to the group
+func (g *PreferenceGroup) Matches(key string) bool {
+	for _, k := range g.Keys {
+		if key == k {
+			return true
+		}
+	}
+	for _, prefix := range g.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchingKeys returns the sorted keys of the preferences which belong to the group
+func (g *PreferenceGroup) MatchingKeys(prefs []byte) []string {
+	var keys []string
+	for _, key := range PreferenceKeys(prefs) {
+		if g.Matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// LoadPreferenceGroups parses a YAML file of preference groups and combines them with the built-in PreferenceGroups.
+// A group with the same name as a built-in group extends it with additional prefixes and keys.
+//
+// Example:
+//
+//	groups:
+//	  - name: aps
+//	    prefixes: [my_custom_aps_]
+//	  - name: clinic
+//	    description: Clinic specific settings
+//	    keys: [patient_name]
+func LoadPreferenceGroups(data []byte) ([]PreferenceGroup, error) {
+	config := struct {
+		Groups []PreferenceGroup `yaml:"groups"`
+	}{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	groups := make([]PreferenceGroup, len(PreferenceGroups))
+	copy(groups, PreferenceGroups)
+
+	for _, custom := range config.Groups {
+		if custom.Name == "" {
+			return nil, fmt.Errorf("preference groups must have a name")
+		}
+
+		existing := FindPreferenceGroup(groups, custom.Name)
+		if existing == nil {
+			groups = append(groups, custom)
+			continue
+		}
+
+		existing.Prefixes = append(append([]string{}, existing.Prefixes...), custom.Prefixes...)
+		existing.Keys = append(append([]string{}, existing.Keys...), custom.Keys...)
+		if custom.Description != "" {
+			existing.Description = custom.Description
+		}
+	}
+
+	return groups, nil
+}
+
+// FindPreferenceGroup returns the group with the given name, or nil if there is none
+func FindPreferenceGroup(groups []PreferenceGroup, name string) *PreferenceGroup {
+	for i := range groups {
+		if groups[i].Name == name {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// GroupOfPreference returns the first group the preference key belongs to, or nil if it doesn't belong to any
+func GroupOfPreference(groups []PreferenceGroup, key string) *PreferenceGroup {
+	for i := range groups {
+		if groups[i].Matches(key) {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// TransplantPreferences copies the preferences belonging to any of the groups from the source to the destination
+// preferences. The returned changes list every preference which was added to or overwritten in the destination.
+func TransplantPreferences(source []byte, destination []byte, groups []*PreferenceGroup) ([]byte, []Change) {
+	out := destination
+	var changes []Change
+
+	for _, key := range PreferenceKeys(source) {
+		matched := false
+		for _, group := range groups {
+			if group.Matches(key) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		newValue, _ := GetPreference(source, key)
+		oldValue, exists := GetPreference(out, key)
+		if exists && oldValue == newValue {
+			continue
+		}
+
+		out = SetPreference(out, key, newValue)
+		changes = append(changes, Change{Key: key, OldValue: oldValue, NewValue: newValue, Added: !exists})
+	}
+
+	return out, changes
+}
+
+// ResetPreferencesToDefaults sets the preferences belonging to the group to their default values for the given AAPS
+// version. Preferences without a known default are left unchanged and returned as skipped.
+func ResetPreferencesToDefaults(prefs []byte, group *PreferenceGroup, version string) ([]byte, []Change, []string) {
+	var changes []Change
+	var skipped []string
+
+	for _, key := range group.MatchingKeys(prefs) {
+		pref := LookupPreference(key, version)
+		if pref == nil || pref.Default == nil {
+			skipped = append(skipped, key)
+			continue
+		}
+
+		value, _ := GetPreference(prefs, key)
+		if pref.IsDefault(value) {
+			continue
+		}
+		prefs = SetPreference(prefs, key, *pref.Default)
+		changes = append(changes, Change{Key: key, OldValue: value, NewValue: *pref.Default})
+	}
+
+	return prefs, changes, skipped
+}
+
+var (
+	// PreferenceGroups declares the built-in groups of related preferences.
+	// The keys are the values of the `key_*` string resources in AAPS.
+	PreferenceGroups = []PreferenceGroup{
+		{
+			Name:        "general",
+			Description: "Units, language and patient information",
+			Keys:        []string{"units", "language", "patient_name", "age", "key_units", "key_language"},
+		},
+		{
+			Name:        "aps",
+			Description: "APS algorithm settings, including SMB, UAM, autosens and loop mode",
+			Prefixes: []string{
+				"openapsma_",
+				"openapsama_",
+				"openapssmb_",
+				"openapsmb_",
+				"enableSMB_",
+				"smbinterval",
+				"smbmaxminutes",
+				"uammaxminutes",
+				"autosens_",
+				"loop_openmode_",
+				"key_openapsma_",
+				"key_openapsama_",
+				"key_openapssmb_",
+			},
+			Keys: []string{
+				"aps_mode",
+				"use_smb",
+				"use_uam",
+				"sensitivity_raises_target",
+				"resistance_lowers_target",
+				"high_temptarget_raises_sensitivity",
+				"low_temptarget_lowers_sensitivity",
+				"always_use_shortavg",
+				"carbsReqThreshold",
+				"key_use_smb",
+				"key_use_uam",
+			},
+		},
+		{
+			Name:        "insulin",
+			Description: "Insulin type and peak time",
+			Prefixes:    []string{"insulin_"},
+		},
+		{
+			Name:        "profiles",
+			Description: "Local profiles",
+			Prefixes:    []string{"LocalProfile"},
+		},
+		{
+			Name:        "automation",
+			Description: "Automation rules",
+			Keys:        []string{"AUTOMATION_EVENTS"},
+		},
+		{
+			Name:        "quickwizard",
+			Description: "QuickWizard buttons",
+			Keys:        []string{"QuickWizard"},
+		},
+		{
+			Name:        "temptargets",
+			Description: "Temporary target presets",
+			Prefixes:    []string{"eatingsoon_", "activity_", "hypo_"},
+		},
+		{
+			Name:        "overview",
+			Description: "Overview screen, graph ranges and alerts",
+			Prefixes:    []string{"overview_", "low_mark", "high_mark", "key_overview_"},
+		},
+		{
+			Name:        "nightscout",
+			Description: "Nightscout URL, API secret and synchronization",
+			Prefixes:    []string{"nsclient", "ns_", "key_ns_"},
+		},
+		{
+			Name:        "sms",
+			Description: "SMS communicator phone numbers, remote commands and OTP",
+			Prefixes:    []string{"smscommunicator_"},
+		},
+		{
+			Name:        "plugins",
+			Description: "Enabled and visible plugins in the config builder",
+			Prefixes:    []string{"ConfigBuilder_"},
+		},
+		{
+			Name:        "objectives",
+			Description: "Objective and exam progress",
+			Prefixes:    []string{"Objectives", "ExamTask_", "DisabledTo_"},
+		},
+		{
+			Name:        "pump",
+			Description: "Pump driver settings and pairing",
+			Prefixes: []string{
+				"danar_",
+				"danars_",
+				"danarv2_",
+				"combo_",
+				"insight_",
+				"medtronic_",
+				"pref_medtronic_",
+				"AAPS.Omnipod",
+				"omnipod_",
+				"diaconn_",
+				"eopatch_",
+				"medtrum_",
+				"virtualpump_",
+			},
+		},
+	}
+)
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreferenceGroupMatches(t *testing.T) {
+	tests := []struct {
+		group string
+		key   string
+		want  bool
+	}{
+		{"general", "units", true},
+		{"general", "units_extra", false},
+		{"aps", "openapsmb_max_iob", true},
+		{"aps", "use_smb", true},
+		{"profiles", "LocalProfile_0_basal", true},
+		{"automation", "AUTOMATION_EVENTS", true},
+		{"automation", "AUTOMATION_EVENTS_OLD", false},
+		{"nightscout", "nsclientinternal_url", true},
+		{"pump", "nsclientinternal_url", false},
+	}
+
+	for _, test := range tests {
+		group := FindPreferenceGroup(PreferenceGroups, test.group)
+		if group == nil {
+			t.Fatalf("no built-in group %s", test.group)
+		}
+		if got := group.Matches(test.key); got != test.want {
+			t.Errorf("%s matches %s: got %v, want %v", test.group, test.key, got, test.want)
+		}
+	}
+
+	if FindPreferenceGroup(PreferenceGroups, "unknown") != nil {
+		t.Errorf("found an unknown group")
+	}
+}
+
+func TestLoadPreferenceGroups(t *testing.T) {
+	groups, err := LoadPreferenceGroups([]byte(`
+groups:
+  - name: aps
+    prefixes: [my_custom_aps_]
+  - name: clinic
+    description: Clinic specific settings
+    keys: [patient_name]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aps := FindPreferenceGroup(groups, "aps")
+	if !aps.Matches("my_custom_aps_value") || !aps.Matches("use_smb") {
+		t.Errorf("the aps group wasn't extended: %+v", aps)
+	}
+	if builtin := FindPreferenceGroup(PreferenceGroups, "aps"); builtin.Matches("my_custom_aps_value") {
+		t.Errorf("the built-in aps group was changed")
+	}
+
+	clinic := FindPreferenceGroup(groups, "clinic")
+	if clinic == nil || clinic.Description != "Clinic specific settings" || !clinic.Matches("patient_name") {
+		t.Errorf("the clinic group wasn't added: %+v", clinic)
+	}
+	if len(groups) != len(PreferenceGroups)+1 {
+		t.Errorf("got %d groups, want %d", len(groups), len(PreferenceGroups)+1)
+	}
+
+	tests := map[string]string{
+		"missing name":  "groups:\n  - prefixes: [a_]",
+		"unknown field": "groups:\n  - name: a\n    prefix: [a_]",
+		"not YAML":      "groups: [",
+	}
+	for name, data := range tests {
+		if _, err := LoadPreferenceGroups([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestTransplantPreferences(t *testing.T) {
+	source := []byte(`{"units":"mmol","use_smb":"true","openapsmb_max_iob":"5","QuickWizard":"[]","nsclientinternal_url":"https://old"}`)
+	destination := []byte(`{"units":"mg/dl","use_smb":"true","nsclientinternal_url":"https://new","language":"de"}`)
+	groups := []*PreferenceGroup{FindPreferenceGroup(PreferenceGroups, "aps"), FindPreferenceGroup(PreferenceGroups, "general")}
+
+	prefs, changes := TransplantPreferences(source, destination, groups)
+
+	want := map[string]string{
+		"units":                "mmol",
+		"use_smb":              "true",
+		"openapsmb_max_iob":    "5",
+		"nsclientinternal_url": "https://new",
+		"language":             "de",
+	}
+	if keys := PreferenceKeys(prefs); len(keys) != len(want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+	for key, value := range want {
+		if got, _ := GetPreference(prefs, key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	// unchanged preferences aren't reported
+	wantChanges := []Change{
+		{Key: "openapsmb_max_iob", NewValue: "5", Added: true},
+		{Key: "units", OldValue: "mg/dl", NewValue: "mmol"},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("got changes %+v, want %+v", changes, wantChanges)
+	}
+}
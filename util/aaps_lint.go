@@ -0,0 +1,380 @@
+package util
+
+import (
+	"aaps-export-tool/core"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity of a lint finding
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// Rank orders severities from info (1) to error (3), with unknown severities as 0
+func (s Severity) Rank() int {
+	switch s {
+	case SeverityError:
+		return 3
+	case SeverityWarning:
+		return 2
+	case SeverityInfo:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// AtLeast checks whether the severity is at least as severe as the minimum. Nothing reaches an unknown minimum, such
+// as "none".
+func (s Severity) AtLeast(minimum Severity) bool {
+	return minimum.Rank() > 0 && s.Rank() >= minimum.Rank()
+}
+
+// LintFinding is a problem found by a LintRule
+type LintFinding struct {
+	RuleID   string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Key is the preference the finding is about, if any
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintRule checks the preferences of an export for unsafe or inconsistent settings
+type LintRule struct {
+	ID          string
+	Severity    Severity
+	Description string
+	check       func(export *lintExport) []LintFinding
+}
+
+// lintExport is the data a LintRule checks
+type lintExport struct {
+	prefs   []byte
+	version string
+}
+
+func (e *lintExport) get(key string) (string, bool) {
+	return GetPreference(e.prefs, key)
+}
+
+func (e *lintExport) getFloat(key string) (float64, bool) {
+	value, ok := e.get(key)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, err == nil
+}
+
+// PatientAge returns the patient type of the preferences, which determines the hard limits of safety settings
+func PatientAge(prefs []byte) string {
+	age, ok := GetPreference(prefs, "age")
+	if !ok || age == "" {
+		return "adult"
+	}
+	return age
+}
+
+// hard limits per patient type (child, teenage, adult, resistant adult, pregnant), from `HardLimits.kt` in AAPS
+var (
+	ageIndex = map[string]int{"child": 0, "teenage": 1, "adult": 2, "resistantadult": 3, "pregnant": 4}
+
+	HardLimitMaxBasal  = [5]float64{2, 5, 10, 12, 25}
+	HardLimitMaxIobAMA = [5]float64{3, 5, 7, 12, 25}
+	HardLimitMaxIobSMB = [5]float64{7, 13, 22, 30, 70}
+)
+
+// HardLimit returns the limit for the patient type, defaulting to adult for unknown types
+func HardLimit(limits [5]float64, age string) float64 {
+	index, ok := ageIndex[age]
+	if !ok {
+		index = ageIndex["adult"]
+	}
+	return limits[index]
+}
+
+// smbPlugins are the APS plugins which support SMB
+var smbPlugins = []string{"OpenAPSSMBPlugin", "OpenAPSSMBDynamicISFPlugin", "OpenAPSAutoISFPlugin"}
+
+// nsClientPlugins are the plugins which synchronize with Nightscout
+var nsClientPlugins = []string{"NSClientPlugin", "NSClientV3Plugin"}
+
+// LintRules declares all rules of the lint command
+var LintRules = []LintRule{
+	{
+		ID:          "invalid-value",
+		Severity:    SeverityError,
+		Description: "A known preference has a value of the wrong type or outside of its allowed range",
+		check: func(e *lintExport) []LintFinding {
+			var findings []LintFinding
+			for _, key := range PreferenceKeys(e.prefs) {
+				pref := LookupPreference(key, e.version)
+				if pref == nil {
+					continue
+				}
+				value, _ := e.get(key)
+				if err := pref.Validate(value); err != nil {
+					findings = append(findings, LintFinding{Key: key, Message: err.Error()})
+				}
+			}
+			return findings
+		},
+	},
+	{
+		ID:          "max-basal-limit",
+		Severity:    SeverityError,
+		Description: "Max basal is above the hard limit for the patient age",
+		check: func(e *lintExport) []LintFinding {
+			return checkHardLimit(e, "openapsma_max_basal", HardLimitMaxBasal, "max basal")
+		},
+	},
+	{
+		ID:          "max-iob-limit",
+		Severity:    SeverityError,
+		Description: "Max IOB is above the hard limit for the patient age",
+		check: func(e *lintExport) []LintFinding {
+			return append(
+				checkHardLimit(e, "openapsma_max_iob", HardLimitMaxIobAMA, "max IOB (AMA)"),
+				checkHardLimit(e, "openapsmb_max_iob", HardLimitMaxIobSMB, "max IOB (SMB)")...,
+			)
+		},
+	},
+	{
+		ID:          "smb-without-aps",
+		Severity:    SeverityWarning,
+		Description: "SMB is enabled, but no APS plugin supporting SMB is enabled",
+		check: func(e *lintExport) []LintFinding {
+			if value, _ := e.get("use_smb"); value != "true" {
+				return nil
+			}
+			for _, plugin := range EnabledPlugins(e.prefs, "APS") {
+				if containsString(smbPlugins, plugin) {
+					return nil
+				}
+			}
+			return []LintFinding{{Key: "use_smb", Message: "SMB is enabled, but no APS plugin supporting SMB is enabled"}}
+		},
+	},
+	{
+		ID:          "multiple-plugins",
+		Severity:    SeverityError,
+		Description: "More than one plugin is enabled for a plugin type which only allows one",
+		check: func(e *lintExport) []LintFinding {
+			var findings []LintFinding
+			for _, pluginType := range ExclusivePluginTypes {
+				enabled := EnabledPlugins(e.prefs, pluginType)
+				if len(enabled) > 1 {
+					findings = append(findings, LintFinding{
+						Key:     PluginEnabledKey(pluginType, enabled[1]),
+						Message: fmt.Sprintf("%d %s plugins are enabled at once: %s", len(enabled), pluginType, strings.Join(enabled, ", ")),
+					})
+				}
+			}
+			return findings
+		},
+	},
+	{
+		ID:          "profile-units-mismatch",
+		Severity:    SeverityWarning,
+		Description: "A local profile uses different glucose units than the app",
+		check: func(e *lintExport) []LintFinding {
+			units, ok := e.get("units")
+			if !ok {
+				return nil
+			}
+
+			var findings []LintFinding
+			for _, key := range PreferenceKeys(e.prefs) {
+				if !matchPattern("LocalProfile_*_mgdl", key) {
+					continue
+				}
+				value, _ := e.get(key)
+				profileUnits := "mmol"
+				if value == "true" {
+					profileUnits = "mg/dl"
+				}
+				if profileUnits != units {
+					name, _ := e.get(strings.TrimSuffix(key, "_mgdl") + "_name")
+					findings = append(findings, LintFinding{
+						Key:     key,
+						Message: fmt.Sprintf("Local profile \"%s\" uses %s, but the app uses %s", name, profileUnits, units),
+					})
+				}
+			}
+			return findings
+		},
+	},
+	{
+		ID:          "nightscout-url-missing",
+		Severity:    SeverityWarning,
+		Description: "Nightscout synchronization is enabled without a Nightscout URL",
+		check: func(e *lintExport) []LintFinding {
+			for _, plugin := range nsClientPlugins {
+				if !IsPluginEnabled(e.prefs, "SYNC", plugin) && !IsPluginEnabled(e.prefs, "GENERAL", plugin) {
+					continue
+				}
+				if url, _ := e.get("nsclientinternal_url"); strings.TrimSpace(url) == "" {
+					return []LintFinding{{Key: "nsclientinternal_url", Message: fmt.Sprintf("%s is enabled, but the Nightscout URL is empty", plugin)}}
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// Lint checks the preferences of an export against all LintRules.
+// Findings are suppressed by their rule ID ("max-iob-limit"), or by rule ID and preference key
+// ("max-iob-limit:openapsmb_max_iob"). The findings are sorted by severity (most severe first), then by rule and key.
+func Lint(export *Export, suppressed []string) []LintFinding {
+	data := &lintExport{prefs: export.Preferences(), version: ExportVersion(export.Data)}
+
+	var findings []LintFinding
+	for _, rule := range LintRules {
+		if containsString(suppressed, rule.ID) {
+			continue
+		}
+		for _, finding := range rule.check(data) {
+			if containsString(suppressed, rule.ID+":"+finding.Key) {
+				continue
+			}
+			finding.RuleID = rule.ID
+			finding.Severity = rule.Severity
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity.Rank() != b.Severity.Rank() {
+			return a.Severity.Rank() > b.Severity.Rank()
+		}
+		if a.RuleID != b.RuleID {
+			return a.RuleID < b.RuleID
+		}
+		return a.Key < b.Key
+	})
+	return findings
+}
+
+// FindLintRule returns the rule with the given ID, or nil if there is none
+func FindLintRule(id string) *LintRule {
+	for i := range LintRules {
+		if LintRules[i].ID == id {
+			return &LintRules[i]
+		}
+	}
+	return nil
+}
+
+// SarifReport converts lint findings to a SARIF 2.1.0 log, for use in code review and CI tools
+func SarifReport(path string, findings []LintFinding) interface{} {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID                   string            `json:"id"`
+		ShortDescription     message           `json:"shortDescription"`
+		DefaultConfiguration map[string]string `json:"defaultConfiguration"`
+	}
+	type logicalLocation struct {
+		FullyQualifiedName string `json:"fullyQualifiedName"`
+		Kind               string `json:"kind"`
+	}
+	type location struct {
+		PhysicalLocation map[string]interface{} `json:"physicalLocation"`
+		LogicalLocations []logicalLocation      `json:"logicalLocations,omitempty"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+
+	level := func(severity Severity) string {
+		if severity == SeverityInfo {
+			return "note"
+		}
+		return string(severity)
+	}
+
+	rules := make([]rule, len(LintRules))
+	for i, r := range LintRules {
+		rules[i] = rule{
+			ID:                   r.ID,
+			ShortDescription:     message{Text: r.Description},
+			DefaultConfiguration: map[string]string{"level": level(r.Severity)},
+		}
+	}
+
+	results := make([]result, len(findings))
+	for i, finding := range findings {
+		loc := location{
+			PhysicalLocation: map[string]interface{}{
+				"artifactLocation": map[string]string{"uri": path},
+			},
+		}
+		if finding.Key != "" {
+			loc.LogicalLocations = []logicalLocation{{FullyQualifiedName: "content." + finding.Key, Kind: "member"}}
+		}
+		results[i] = result{
+			RuleID:    finding.RuleID,
+			Level:     level(finding.Severity),
+			Message:   message{Text: finding.Message},
+			Locations: []location{loc},
+		}
+	}
+
+	driver := map[string]interface{}{
+		"name":           "aaps-export-tool",
+		"informationUri": "https://github.com/p5nbTgip0r/aaps-export-tool",
+		"rules":          rules,
+	}
+	if core.Version != "" {
+		driver["version"] = core.Version
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool":    map[string]interface{}{"driver": driver},
+				"results": results,
+			},
+		},
+	}
+}
+
+func checkHardLimit(e *lintExport, key string, limits [5]float64, name string) []LintFinding {
+	value, ok := e.getFloat(key)
+	if !ok {
+		return nil
+	}
+
+	age := PatientAge(e.prefs)
+	limit := HardLimit(limits, age)
+	if value <= limit {
+		return nil
+	}
+	return []LintFinding{{
+		Key:     key,
+		Message: fmt.Sprintf("The %s of %s is above the limit of %s for patient age \"%s\"", name, formatNumber(value), formatNumber(limit), age),
+	}}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
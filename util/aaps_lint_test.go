@@ -0,0 +1,207 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// lintTestFindings lints the preferences, returning every finding as "rule:key"
+func lintTestFindings(t *testing.T, prefs string, suppressed []string) []string {
+	t.Helper()
+
+	export := &Export{Data: []byte(`{"content":` + prefs + `}`)}
+	var findings []string
+	for _, finding := range Lint(export, suppressed) {
+		findings = append(findings, finding.RuleID+":"+finding.Key)
+	}
+	return findings
+}
+
+func TestLintRules(t *testing.T) {
+	smbPlugin := `"ConfigBuilder_APS_OpenAPSSMBPlugin_Enabled":"true"`
+
+	tests := []struct {
+		name  string
+		prefs string
+		want  []string
+	}{
+		{
+			name:  "no findings",
+			prefs: `{"units":"mg/dl","use_smb":"true",` + smbPlugin + `}`,
+		},
+		{
+			name:  "invalid value",
+			prefs: `{"units":"mmol/l","use_smb":"yes"}`,
+			want:  []string{"invalid-value:units", "invalid-value:use_smb"},
+		},
+		{
+			name:  "SMB without an APS plugin supporting it",
+			prefs: `{"use_smb":"true","ConfigBuilder_APS_OpenAPSAMAPlugin_Enabled":"true"}`,
+			want:  []string{"smb-without-aps:use_smb"},
+		},
+		{
+			name:  "multiple exclusive plugins",
+			prefs: `{"ConfigBuilder_APS_OpenAPSAMAPlugin_Enabled":"true",` + smbPlugin + `}`,
+			want:  []string{"multiple-plugins:ConfigBuilder_APS_OpenAPSSMBPlugin_Enabled"},
+		},
+		{
+			name:  "profile units",
+			prefs: `{"units":"mmol","LocalProfile_0_mgdl":"true","LocalProfile_1_mgdl":"false"}`,
+			want:  []string{"profile-units-mismatch:LocalProfile_0_mgdl"},
+		},
+		{
+			name:  "Nightscout without URL",
+			prefs: `{"ConfigBuilder_SYNC_NSClientV3Plugin_Enabled":"true","nsclientinternal_url":" "}`,
+			want:  []string{"nightscout-url-missing:nsclientinternal_url"},
+		},
+		{
+			name:  "errors come before warnings",
+			prefs: `{"use_smb":"true","openapsma_max_basal":"11"}`,
+			want:  []string{"max-basal-limit:openapsma_max_basal", "smb-without-aps:use_smb"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := lintTestFindings(t, test.prefs, nil); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLintHardLimits(t *testing.T) {
+	tests := []struct {
+		age   string
+		key   string
+		value string
+		want  bool
+	}{
+		{"", "openapsma_max_basal", "10", false},
+		{"", "openapsma_max_basal", "10.5", true},
+		{"child", "openapsma_max_basal", "2.5", true},
+		{"pregnant", "openapsma_max_basal", "20", false},
+		{"unknown", "openapsma_max_basal", "10", false},
+		{"teenage", "openapsma_max_iob", "6", true},
+		{"teenage", "openapsmb_max_iob", "13", false},
+		{"resistantadult", "openapsmb_max_iob", "31", true},
+	}
+
+	for _, test := range tests {
+		prefs := SetPreference([]byte(`{}`), test.key, test.value)
+		if test.age != "" {
+			prefs = SetPreference(prefs, "age", test.age)
+		}
+
+		var found bool
+		for _, finding := range lintTestFindings(t, string(prefs), []string{"invalid-value"}) {
+			found = found || finding == "max-basal-limit:"+test.key || finding == "max-iob-limit:"+test.key
+		}
+		if found != test.want {
+			t.Errorf("%s=%s for age %q: got finding %v, want %v", test.key, test.value, test.age, found, test.want)
+		}
+	}
+}
+
+func TestLintSuppress(t *testing.T) {
+	prefs := `{"age":"child","openapsma_max_iob":"4","openapsmb_max_iob":"8","use_smb":"true"}`
+
+	tests := []struct {
+		suppressed []string
+		want       []string
+	}{
+		{nil, []string{"max-iob-limit:openapsma_max_iob", "max-iob-limit:openapsmb_max_iob", "smb-without-aps:use_smb"}},
+		{[]string{"max-iob-limit"}, []string{"smb-without-aps:use_smb"}},
+		{[]string{"max-iob-limit:openapsmb_max_iob", "smb-without-aps"}, []string{"max-iob-limit:openapsma_max_iob"}},
+		{[]string{"max-iob-limit:use_smb"}, []string{"max-iob-limit:openapsma_max_iob", "max-iob-limit:openapsmb_max_iob", "smb-without-aps:use_smb"}},
+	}
+
+	for _, test := range tests {
+		if got := lintTestFindings(t, prefs, test.suppressed); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("suppressing %v: got %v, want %v", test.suppressed, got, test.want)
+		}
+	}
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	tests := []struct {
+		severity Severity
+		minimum  Severity
+		want     bool
+	}{
+		{SeverityError, SeverityError, true},
+		{SeverityWarning, SeverityError, false},
+		{SeverityError, SeverityWarning, true},
+		{SeverityWarning, SeverityWarning, true},
+		{SeverityInfo, SeverityWarning, false},
+		{SeverityInfo, SeverityInfo, true},
+		{SeverityError, "none", false},
+		{SeverityInfo, "none", false},
+	}
+
+	for _, test := range tests {
+		if got := test.severity.AtLeast(test.minimum); got != test.want {
+			t.Errorf("%s.AtLeast(%s) = %v, want %v", test.severity, test.minimum, got, test.want)
+		}
+	}
+}
+
+func TestSarifReport(t *testing.T) {
+	findings := []LintFinding{
+		{RuleID: "max-iob-limit", Severity: SeverityError, Key: "openapsmb_max_iob", Message: "too high"},
+		{RuleID: "multiple-plugins", Severity: SeverityInfo, Message: "no key"},
+	}
+
+	data, err := json.Marshal(SarifReport("export.json", findings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+					LogicalLocations []struct {
+						FullyQualifiedName string `json:"fullyQualifiedName"`
+					} `json:"logicalLocations"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Version != "2.1.0" || len(report.Runs) != 1 {
+		t.Fatalf("unexpected report %s", data)
+	}
+	run := report.Runs[0]
+	if len(run.Tool.Driver.Rules) != len(LintRules) || len(run.Results) != 2 {
+		t.Fatalf("got %d rules and %d results", len(run.Tool.Driver.Rules), len(run.Results))
+	}
+
+	first, second := run.Results[0], run.Results[1]
+	if first.RuleID != "max-iob-limit" || first.Level != "error" || first.Locations[0].PhysicalLocation.ArtifactLocation.URI != "export.json" {
+		t.Errorf("unexpected result %+v", first)
+	}
+	if names := first.Locations[0].LogicalLocations; len(names) != 1 || names[0].FullyQualifiedName != "content.openapsmb_max_iob" {
+		t.Errorf("got logical locations %+v", names)
+	}
+	// SARIF has no info level, and findings without a key have no logical location
+	if second.Level != "note" || len(second.Locations[0].LogicalLocations) != 0 {
+		t.Errorf("unexpected result %+v", second)
+	}
+}
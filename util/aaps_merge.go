@@ -0,0 +1,90 @@
+package util
+
+import (
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// MergeValue is the value of a preference in one of the exports of a merge
+type MergeValue struct {
+	// Raw is the compact JSON of the value
+	Raw    string
+	Exists bool
+}
+
+func (v MergeValue) String() string {
+	if !v.Exists {
+		return "(removed)"
+	}
+	return gjson.Parse(v.Raw).String()
+}
+
+func (v MergeValue) equal(other MergeValue) bool {
+	if v.Exists != other.Exists {
+		return false
+	}
+	return !v.Exists || jsonEqual([]byte(v.Raw), []byte(other.Raw))
+}
+
+// MergeConflict is a preference which was changed differently in both exports of a merge
+type MergeConflict struct {
+	Key    string
+	Base   MergeValue
+	Ours   MergeValue
+	Theirs MergeValue
+}
+
+// MergePreferences performs a three-way merge of preferences, key by key.
+// A preference which was only changed on one side since the base is taken from that side. Conflicting preferences
+// (changed differently on both sides) are left with our value in the merged preferences, and returned so they can be
+// resolved with ResolveConflict.
+func MergePreferences(base []byte, ours []byte, theirs []byte) ([]byte, []MergeConflict) {
+	merged := ours
+	var conflicts []MergeConflict
+
+	for _, key := range unionKeys(base, ours, theirs) {
+		baseValue := mergeValue(base, key)
+		oursValue := mergeValue(ours, key)
+		theirsValue := mergeValue(theirs, key)
+
+		switch {
+		case oursValue.equal(theirsValue), theirsValue.equal(baseValue):
+			// both made the same change, or only we changed it
+			continue
+		case oursValue.equal(baseValue):
+			// only they changed it
+			merged = setMergeValue(merged, key, theirsValue)
+		default:
+			conflicts = append(conflicts, MergeConflict{
+				Key:    key,
+				Base:   baseValue,
+				Ours:   oursValue,
+				Theirs: theirsValue,
+			})
+		}
+	}
+
+	return merged, conflicts
+}
+
+// ResolveConflict sets the conflicting preference to their value if useTheirs is set, or our value otherwise
+func ResolveConflict(merged []byte, conflict MergeConflict, useTheirs bool) []byte {
+	if useTheirs {
+		return setMergeValue(merged, conflict.Key, conflict.Theirs)
+	}
+	return setMergeValue(merged, conflict.Key, conflict.Ours)
+}
+
+func mergeValue(prefs []byte, key string) MergeValue {
+	result := gjson.GetBytes(prefs, PreferencePath(key))
+	return MergeValue{Raw: string(compactRaw(result)), Exists: result.Exists()}
+}
+
+func setMergeValue(prefs []byte, key string, value MergeValue) []byte {
+	if !value.Exists {
+		out, _ := sjson.DeleteBytes(prefs, PreferencePath(key))
+		return out
+	}
+	out, _ := sjson.SetRawBytes(prefs, PreferencePath(key), []byte(value.Raw))
+	return out
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergePreferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts []string
+	}{
+		{"unchanged", `{"a":"1"}`, `{"a":"1"}`, `{"a":"1"}`, `{"a":"1"}`, nil},
+		{"changed by us", `{"a":"1"}`, `{"a":"2"}`, `{"a":"1"}`, `{"a":"2"}`, nil},
+		{"changed by them", `{"a":"1"}`, `{"a":"1"}`, `{"a":"2"}`, `{"a":"2"}`, nil},
+		{"same change", `{"a":"1"}`, `{"a":"2"}`, `{"a":"2"}`, `{"a":"2"}`, nil},
+		{"added by them", `{}`, `{}`, `{"a":"1"}`, `{"a":"1"}`, nil},
+		{"removed by them", `{"a":"1","b":"1"}`, `{"a":"1","b":"1"}`, `{"b":"1"}`, `{"b":"1"}`, nil},
+		{"both changed", `{"a":"1"}`, `{"a":"2"}`, `{"a":"3"}`, `{"a":"2"}`, []string{"a"}},
+		{"changed by us, removed by them", `{"a":"1"}`, `{"a":"2"}`, `{}`, `{"a":"2"}`, []string{"a"}},
+		{"added differently", `{}`, `{"a":"1"}`, `{"a":"2"}`, `{"a":"1"}`, []string{"a"}},
+		{"values compared as JSON", `{"a":{"x":1}}`, `{"a":{"x":1}}`, `{"a":{ "x": 1 }}`, `{"a":{"x":1}}`, nil},
+	}
+
+	for _, test := range tests {
+		merged, conflicts := MergePreferences([]byte(test.base), []byte(test.ours), []byte(test.theirs))
+		if !jsonEqual(merged, []byte(test.want)) {
+			t.Errorf("%s: got %s, want %s", test.name, merged, test.want)
+		}
+		var keys []string
+		for _, conflict := range conflicts {
+			keys = append(keys, conflict.Key)
+		}
+		if !reflect.DeepEqual(keys, test.conflicts) {
+			t.Errorf("%s: got conflicts %v, want %v", test.name, keys, test.conflicts)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	base := []byte(`{"a":"1","b":"1"}`)
+	ours := []byte(`{"a":"2","b":"2"}`)
+	theirs := []byte(`{"a":"3"}`)
+
+	merged, conflicts := MergePreferences(base, ours, theirs)
+	if len(conflicts) != 2 {
+		t.Fatalf("got %d conflicts, want 2", len(conflicts))
+	}
+	if got := conflicts[1].Theirs.String(); got != "(removed)" {
+		t.Errorf("got %s for a removed value", got)
+	}
+
+	tests := []struct {
+		useTheirs bool
+		want      string
+	}{
+		{false, `{"a":"2","b":"2"}`},
+		{true, `{"a":"3"}`},
+	}
+	for _, test := range tests {
+		resolved := merged
+		for _, conflict := range conflicts {
+			resolved = ResolveConflict(resolved, conflict, test.useTheirs)
+		}
+		if !jsonEqual(resolved, []byte(test.want)) {
+			t.Errorf("useTheirs %v: got %s, want %s", test.useTheirs, resolved, test.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tidwall/sjson"
+	"gopkg.in/yaml.v3"
+	"sort"
+)
+
+// Migration converts the preferences of an export to a newer AAPS version, in which preferences were renamed,
+// changed their values or were removed.
+//
+// Example:
+//
+//	version: 3.2.0
+//	description: AAPS 3.2.0
+//	steps:
+//	  - rename:
+//	      old_key: new_key
+//	    overwrite: false
+//	  - convert:
+//	      key: aps_mode
+//	      values:
+//	        lgs: open
+//	  - drop: [obsolete_*]
+type Migration struct {
+	Version     string          `yaml:"version"`
+	Description string          `yaml:"description"`
+	Steps       []MigrationStep `yaml:"steps"`
+}
+
+// MigrationStep is a single operation of a Migration. Exactly one of Rename, Convert and Drop must be set.
+type MigrationStep struct {
+	// Rename maps old keys to new keys. The renames of a step happen at once, and fail when a new key already exists,
+	// unless Overwrite is set.
+	Rename    map[string]string `yaml:"rename"`
+	Overwrite bool              `yaml:"overwrite"`
+	Convert   *MigrationConvert `yaml:"convert"`
+	// Drop lists the preferences to remove. Keys containing '*' are patterns matching any characters.
+	Drop []string `yaml:"drop"`
+}
+
+// MigrationConvert replaces the values of a preference
+type MigrationConvert struct {
+	Key    string            `yaml:"key"`
+	Values map[string]string `yaml:"values"`
+}
+
+// ParseMigration parses and validates a YAML migration
+func ParseMigration(data []byte) (*Migration, error) {
+	migration := &Migration{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(migration); err != nil {
+		return nil, err
+	}
+
+	if migration.Version == "" {
+		return nil, fmt.Errorf("missing version")
+	}
+	for i, step := range migration.Steps {
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+
+	return migration, nil
+}
+
+// SortMigrations sorts migrations by their version
+func SortMigrations(migrations []Migration) {
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return CompareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+}
+
+func (step *MigrationStep) validate() error {
+	operations := 0
+	for _, set := range []bool{step.Rename != nil, step.Convert != nil, step.Drop != nil} {
+		if set {
+			operations++
+		}
+	}
+	if operations != 1 {
+		return fmt.Errorf("expected exactly one operation, found %d", operations)
+	}
+	if step.Convert != nil && step.Convert.Key == "" {
+		return fmt.Errorf("convert is missing a key")
+	}
+	if step.Overwrite && step.Rename == nil {
+		return fmt.Errorf("overwrite is only allowed with rename")
+	}
+	renamed := map[string]string{}
+	for _, oldKey := range sortedKeys(step.Rename) {
+		newKey := step.Rename[oldKey]
+		if other, ok := renamed[newKey]; ok {
+			return fmt.Errorf("\"%s\" and \"%s\" are both renamed to \"%s\"", other, oldKey, newKey)
+		}
+		renamed[newKey] = oldKey
+	}
+	return nil
+}
+
+// MigrationsBetween returns the migrations which are needed to go from one AAPS version to another:
+// every migration newer than the first version, up to and including the second version. A second version with fewer
+// components includes all versions starting with it, so 3.2 includes a migration to 3.2.1.
+func MigrationsBetween(migrations []Migration, from string, to string) []Migration {
+	var needed []Migration
+	for _, migration := range migrations {
+		if CompareVersions(migration.Version, from) > 0 && CompareVersionsTo(migration.Version, to) <= 0 {
+			needed = append(needed, migration)
+		}
+	}
+	return needed
+}
+
+// Migrate applies the migration to the preferences. The returned changes list every preference which was
+// renamed, converted or removed, in the order of the steps. Preferences which are missing are not renamed, but renaming
+// to a preference which already exists fails, unless the step allows overwriting it.
+func (m *Migration) Migrate(prefs []byte) ([]byte, []Change, error) {
+	var changes []Change
+
+	for _, step := range m.Steps {
+		var oldKeys, values []string
+		for _, oldKey := range sortedKeys(step.Rename) {
+			if value, ok := GetPreference(prefs, oldKey); ok {
+				oldKeys = append(oldKeys, oldKey)
+				values = append(values, value)
+			}
+		}
+		for _, oldKey := range oldKeys {
+			newKey := step.Rename[oldKey]
+			if _, renamed := step.Rename[newKey]; step.Overwrite || renamed {
+				continue
+			}
+			if existing, ok := GetPreference(prefs, newKey); ok {
+				return nil, nil, fmt.Errorf("migration %s: cannot rename \"%s\" to \"%s\", which already exists with the value \"%s\"",
+					m.Version, oldKey, newKey, existing)
+			}
+		}
+		for _, oldKey := range oldKeys {
+			prefs = DeletePreference(prefs, oldKey)
+		}
+		for i, oldKey := range oldKeys {
+			newKey := step.Rename[oldKey]
+			overwritten, exists := GetPreference(prefs, newKey)
+			prefs = SetPreference(prefs, newKey, values[i])
+			changes = append(changes, Change{Key: oldKey, OldValue: values[i], Removed: true})
+			if exists {
+				changes = append(changes, Change{Key: newKey, OldValue: overwritten, NewValue: values[i]})
+			} else {
+				changes = append(changes, Change{Key: newKey, NewValue: values[i], Added: true})
+			}
+		}
+
+		if step.Convert != nil {
+			value, ok := GetPreference(prefs, step.Convert.Key)
+			if newValue, convert := step.Convert.Values[value]; ok && convert && newValue != value {
+				prefs = SetPreference(prefs, step.Convert.Key, newValue)
+				changes = append(changes, Change{Key: step.Convert.Key, OldValue: value, NewValue: newValue})
+			}
+		}
+
+		for _, key := range PreferenceKeys(prefs) {
+			for _, pattern := range step.Drop {
+				if key == pattern || matchPattern(pattern, key) {
+					value, _ := GetPreference(prefs, key)
+					prefs = DeletePreference(prefs, key)
+					changes = append(changes, Change{Key: key, OldValue: value, Removed: true})
+					break
+				}
+			}
+		}
+	}
+
+	return prefs, changes, nil
+}
+
+// MigrateExport applies the migrations to the preferences of the export, and sets the AAPS version in its metadata.
+// The export is not changed when a migration fails.
+func MigrateExport(export *Export, migrations []Migration, version string) ([]Change, error) {
+	prefs := export.Preferences()
+
+	var changes []Change
+	for i := range migrations {
+		var migrationChanges []Change
+		var err error
+		prefs, migrationChanges, err = migrations[i].Migrate(prefs)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, migrationChanges...)
+	}
+	export.SetPreferences(prefs)
+
+	oldVersion := ExportVersion(export.Data)
+	if oldVersion != version {
+		export.Data, _ = sjson.SetBytes(export.Data, "metadata.aaps_version", version)
+		changes = append(changes, Change{Key: "metadata.aaps_version", OldValue: oldVersion, NewValue: version})
+	}
+	return changes, nil
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersionsTo(t *testing.T) {
+	tests := []struct {
+		version string
+		target  string
+		want    int
+	}{
+		{"3.2.0.3", "3.2", 0},
+		{"3.2.0", "3.2", 0},
+		{"3.1.0.3", "3.2", -1},
+		{"3.3", "3.2", 1},
+		{"3.2", "3.2.1", -1},
+		{"3.2.1", "3.2.1", 0},
+	}
+
+	for _, test := range tests {
+		if got := CompareVersionsTo(test.version, test.target); got != test.want {
+			t.Errorf("CompareVersionsTo(%q, %q) = %d, want %d", test.version, test.target, got, test.want)
+		}
+	}
+}
+
+func TestMigrationsBetween(t *testing.T) {
+	migrations := []Migration{{Version: "3.1.0"}, {Version: "3.2.0"}, {Version: "3.2.1"}, {Version: "3.3.0"}}
+
+	tests := []struct {
+		from string
+		to   string
+		want []string
+	}{
+		{"3.0.0", "3.2", []string{"3.1.0", "3.2.0", "3.2.1"}},
+		{"3.1.0.3", "3.2.0", []string{"3.2.0"}},
+		{"3.2.0", "3.2", []string{"3.2.1"}},
+		{"3.3.0", "3.3", nil},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, migration := range MigrationsBetween(migrations, test.from, test.to) {
+			got = append(got, migration.Version)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("MigrationsBetween(%q, %q) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	migration, err := ParseMigration([]byte(`
+version: 3.2.0
+steps:
+  - rename:
+      old_key: new_key
+  - convert:
+      key: aps_mode
+      values:
+        lgs: open
+  - drop: [obsolete_*]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefs, changes, err := migration.Migrate([]byte(`{"old_key":"1","aps_mode":"lgs","obsolete_a":"x","kept":"y"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"new_key": "1", "aps_mode": "open", "kept": "y"}
+	for key, value := range want {
+		if got, _ := GetPreference(prefs, key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"old_key", "obsolete_a"} {
+		if _, ok := GetPreference(prefs, key); ok {
+			t.Errorf("%s wasn't removed", key)
+		}
+	}
+	if len(changes) != 4 {
+		t.Errorf("got %d changes, want 4: %v", len(changes), changes)
+	}
+}
+
+func TestParseMigrationErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing version":     "steps: []",
+		"two operations":      "version: 3.2.0\nsteps:\n  - drop: [a]\n    rename: {b: c}",
+		"convert without key": "version: 3.2.0\nsteps:\n  - convert: {values: {a: b}}",
+		"unknown field":       "version: 3.2.0\nunknown: 1",
+		"overwrite alone":     "version: 3.2.0\nsteps:\n  - drop: [a]\n    overwrite: true",
+		"duplicate target":    "version: 3.2.0\nsteps:\n  - rename: {a: c, b: c}",
+	}
+
+	for name, data := range tests {
+		if _, err := ParseMigration([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestMigrateRenameExisting(t *testing.T) {
+	prefs := []byte(`{"old_key":"1","new_key":"2"}`)
+
+	tests := []struct {
+		name  string
+		steps string
+		want  map[string]string
+	}{
+		{"existing target", "  - rename: {old_key: new_key}", nil},
+		{"overwrite", "  - rename: {old_key: new_key}\n    overwrite: true", map[string]string{"new_key": "1"}},
+		{"swap", "  - rename: {old_key: new_key, new_key: old_key}", map[string]string{"old_key": "2", "new_key": "1"}},
+		{"missing source", "  - rename: {missing: new_key}", map[string]string{"old_key": "1", "new_key": "2"}},
+	}
+
+	for _, test := range tests {
+		migration, err := ParseMigration([]byte("version: 3.2.0\nsteps:\n" + test.steps))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		migrated, _, err := migration.Migrate(prefs)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if keys := PreferenceKeys(migrated); len(keys) != len(test.want) {
+			t.Errorf("%s: got keys %v, want %v", test.name, keys, test.want)
+		}
+		for key, value := range test.want {
+			if got, _ := GetPreference(migrated, key); got != value {
+				t.Errorf("%s: %s = %q, want %q", test.name, key, got, value)
+			}
+		}
+	}
+}
+
+func TestMigrateExportFailure(t *testing.T) {
+	migration, err := ParseMigration([]byte("version: 3.2.0\nsteps:\n  - drop: [units]\n  - rename: {use_smb: openapsmb_max_iob}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := LoadExport(testExport(testPrefs), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(export.Data)
+	if _, err := MigrateExport(export, []Migration{*migration}, "3.2.0"); err == nil {
+		t.Fatalf("renaming to an existing preference succeeded")
+	}
+	if string(export.Data) != data {
+		t.Errorf("the failed migration changed the export")
+	}
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+// canonicalKeyOrder is the order of the top-level keys of a normalized export. Any other keys follow, sorted.
+var canonicalKeyOrder = []string{"metadata", "format", "security", "content"}
+
+var canonicalOptions = &pretty.Options{Width: 80, Prefix: "", Indent: "  ", SortKeys: true}
+
+// NormalizeExport converts a decrypted export to a canonical form, so exports with the same settings are byte for
+// byte identical regardless of the key order AAPS wrote them in. The top-level keys are in a fixed order, all other
+// keys are sorted and the indentation is stable. Preference values are kept as they are, unless deep is set, which
+// also serializes JSON inside preference values with sorted keys.
+// The storage format of the preferences (string, object, typed or expanded) is kept, and the file hash is recalculated.
+func NormalizeExport(exportJson []byte, deep bool) ([]byte, error) {
+	if IsEncrypted(exportJson) {
+		return nil, errors.New("encrypted exports cannot be normalized, decrypt them first")
+	}
+
+	isObject := IsPreferencesObject(exportJson)
+	if !isObject {
+		exportJson = ConvertPreferencesToObject(exportJson)
+	}
+
+	content, err := normalizePreferences([]byte(gjson.GetBytes(exportJson, "content").Raw), ExportVersion(exportJson), isObject, deep)
+	if err != nil {
+		return nil, err
+	}
+
+	root := gjson.ParseBytes(exportJson)
+	var keys []string
+	keys = append(keys, canonicalKeyOrder...)
+	for _, key := range PreferenceKeys(exportJson) {
+		if !containsString(canonicalKeyOrder, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteByte('{')
+	first := true
+	for _, key := range keys {
+		value := root.Get(PreferencePath(key))
+		if !value.Exists() {
+			continue
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+
+		out.Write(jsonString(key))
+		out.WriteByte(':')
+		if key == "content" {
+			out.Write(content)
+		} else {
+			out.Write(pretty.Ugly(pretty.PrettyOptions([]byte(value.Raw), canonicalOptions)))
+		}
+	}
+	out.WriteByte('}')
+
+	// the keys are already in canonical order, so only the layout is changed here
+	layout := *canonicalOptions
+	layout.SortKeys = false
+	return CalculateFileHash(pretty.PrettyOptions(out.Bytes(), &layout)), nil
+}
+
+// normalizePreferences sorts the preferences, and with deep the JSON inside their values, keeping them typed or
+// expanded if they were. It returns the preferences as an object, or as a JSON string if asObject is false.
+func normalizePreferences(prefs []byte, version string, asObject bool, deep bool) ([]byte, error) {
+	if !deep {
+		prefs = sortPreferences(prefs)
+	} else {
+		var err error
+		if prefs, err = sortPreferencesDeep(prefs, version); err != nil {
+			return nil, err
+		}
+	}
+
+	if asObject {
+		return prefs, nil
+	}
+	return jsonString(string(prefs)), nil
+}
+
+// sortPreferences sorts the preferences by key, leaving their values byte for byte identical apart from whitespace
+// outside of strings
+func sortPreferences(prefs []byte) []byte {
+	root := gjson.ParseBytes(prefs)
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, key := range PreferenceKeys(prefs) {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(jsonString(key))
+		out.WriteByte(':')
+		out.Write(pretty.Ugly([]byte(root.Get(PreferencePath(key)).Raw)))
+	}
+	out.WriteByte('}')
+	return out.Bytes()
+}
+
+// sortPreferencesDeep sorts the preferences and the keys of JSON inside their values
+func sortPreferencesDeep(prefs []byte, version string) ([]byte, error) {
+	typed := HasTypedPreferences(prefs)
+	expanded := HasExpandedPreferences(prefs)
+
+	prefs, err := ExpandPreferences(UntypePreferences(prefs), version)
+	if err != nil {
+		return nil, err
+	}
+	prefs = UntypePreferences(pretty.PrettyOptions(prefs, canonicalOptions))
+
+	if expanded {
+		if prefs, err = ExpandPreferences(prefs, version); err != nil {
+			return nil, err
+		}
+	}
+	if typed {
+		prefs = TypePreferences(prefs, version)
+	}
+
+	return pretty.Ugly(prefs), nil
+}
+
+// IsNormalized checks whether a decrypted export is already in the canonical form of NormalizeExport
+func IsNormalized(exportJson []byte, deep bool) (bool, error) {
+	normalized, err := NormalizeExport(exportJson, deep)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(bytes.TrimSpace(normalized), bytes.TrimSpace(exportJson)), nil
+}
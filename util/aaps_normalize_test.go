@@ -0,0 +1,94 @@
+package util
+
+import (
+	"bytes"
+	"github.com/tidwall/gjson"
+	"reflect"
+	"testing"
+)
+
+const normalizeTestExport = `{"security":{"file_hash":"x","algorithm":"none"},"format":"aaps_structured",` +
+	`"metadata":{"device_name":"Pixel","aaps_version":"3.2.0"},` +
+	`"content":"{\"units\":\"mg/dl\",\"QuickWizard\":\"[{\\\"carbs\\\":30,\\\"buttonText\\\":\\\"Meal\\\"}]\",\"age\":\"adult\"}"}`
+
+func TestNormalizeExport(t *testing.T) {
+	tests := []struct {
+		name        string
+		deep        bool
+		quickWizard string
+	}{
+		{"nested values are kept", false, `[{"carbs":30,"buttonText":"Meal"}]`},
+		{"deep sorts nested values", true, `[{"buttonText":"Meal","carbs":30}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := NormalizeExport([]byte(normalizeTestExport), test.deep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			export, err := LoadExport(normalized, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if export.PreferencesObject {
+				t.Errorf("the string storage format wasn't kept")
+			}
+			prefs := export.Preferences()
+			var keys []string
+			gjson.ParseBytes(prefs).ForEach(func(key, _ gjson.Result) bool {
+				keys = append(keys, key.String())
+				return true
+			})
+			if !reflect.DeepEqual(keys, []string{"QuickWizard", "age", "units"}) {
+				t.Errorf("preferences aren't sorted: %v", keys)
+			}
+			if got, _ := GetPreference(prefs, QuickWizardKey); got != test.quickWizard {
+				t.Errorf("QuickWizard = %s, want %s", got, test.quickWizard)
+			}
+			if !bytes.HasPrefix(normalized, []byte("{\n  \"metadata\"")) {
+				t.Errorf("metadata isn't the first key:\n%s", normalized)
+			}
+
+			again, err := NormalizeExport(normalized, test.deep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, normalized) {
+				t.Errorf("normalizing twice changed the export:\n%s\n%s", normalized, again)
+			}
+			if ok, _ := IsNormalized(normalized, test.deep); !ok {
+				t.Errorf("IsNormalized is false for a normalized export")
+			}
+		})
+	}
+
+	if ok, _ := IsNormalized([]byte(normalizeTestExport), false); ok {
+		t.Errorf("IsNormalized is true for an export which isn't normalized")
+	}
+}
+
+func TestNormalizeExportObject(t *testing.T) {
+	export := []byte(`{"format":"aaps_structured","metadata":{},"content":{"b":"2","a":"{\"y\":1,\"x\":2}"}}`)
+
+	normalized, err := NormalizeExport(export, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPreferencesObject(normalized) {
+		t.Errorf("the object storage format wasn't kept")
+	}
+	if !bytes.Contains(normalized, []byte(`"a": "{\"y\":1,\"x\":2}",`)) {
+		t.Errorf("unexpected output:\n%s", normalized)
+	}
+}
+
+func TestNormalizeExportEncrypted(t *testing.T) {
+	encrypted, err := EncryptExport([]byte(normalizeTestExport), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NormalizeExport(encrypted, false); err == nil {
+		t.Errorf("an encrypted export was normalized")
+	}
+}
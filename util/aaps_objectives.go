@@ -133,6 +133,26 @@ func (obj *Objective) Complete(preferencesJson []byte) []byte {
 	return out
 }
 
+// Reset marks the objective as not started and reverts its tasks to their default values
+func (obj *Objective) Reset(preferencesJson []byte) []byte {
+	out, _ := sjson.SetBytes(preferencesJson, obj.StartedPrefKey(), "0")
+	out, _ = sjson.SetBytes(out, obj.AccomplishedPrefKey(), "0")
+
+	if core.Verbose {
+		log.Printf("Reset objective \"%s\"", obj.Name)
+	}
+
+	for _, task := range obj.Tasks {
+		val := fmt.Sprintf("%v", task.defaultValue)
+		out, _ = sjson.SetBytes(out, task.key, val)
+		if core.Verbose {
+			log.Printf("Set task preference \"%s\": \"%s\"", task.key, val)
+		}
+	}
+
+	return out
+}
+
 // ObjectiveNumbersToObjects converts a slice of objective numbers to the equivalent Objective structs
 func ObjectiveNumbersToObjects(nums []int) []*Objective {
 	objs := make([]*Objective, len(nums))
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PatchOperation is a single operation of a JSON Patch (RFC 6902), applied to the preferences of an export
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchConflictError is returned when a `test` operation of a patch doesn't match the preferences it's applied to
+type PatchConflictError struct {
+	Index    int
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *PatchConflictError) Error() string {
+	return fmt.Sprintf("conflict in operation %d: expected %s to be %s, but it is %s", e.Index+1, e.Path, e.Expected, e.Actual)
+}
+
+// CreatePatch creates a JSON Patch which converts the old preferences into the new preferences.
+// Every replaced or removed preference is preceded by a `test` operation, so the patch won't apply to preferences
+// which have been changed in the meantime.
+func CreatePatch(oldPrefs []byte, newPrefs []byte) []PatchOperation {
+	oldObject := gjson.ParseBytes(oldPrefs)
+	newObject := gjson.ParseBytes(newPrefs)
+
+	var patch []PatchOperation
+	for _, key := range unionKeys(oldPrefs, newPrefs) {
+		path := "/" + escapePointerToken(key)
+		oldValue := oldObject.Get(PreferencePath(key))
+		newValue := newObject.Get(PreferencePath(key))
+
+		switch {
+		case !oldValue.Exists():
+			patch = append(patch, PatchOperation{Op: "add", Path: path, Value: compactRaw(newValue)})
+		case !newValue.Exists():
+			patch = append(patch,
+				PatchOperation{Op: "test", Path: path, Value: compactRaw(oldValue)},
+				PatchOperation{Op: "remove", Path: path},
+			)
+		case !jsonEqual(compactRaw(oldValue), compactRaw(newValue)):
+			patch = append(patch,
+				PatchOperation{Op: "test", Path: path, Value: compactRaw(oldValue)},
+				PatchOperation{Op: "replace", Path: path, Value: compactRaw(newValue)},
+			)
+		}
+	}
+
+	return patch
+}
+
+// ParsePatch parses a JSON Patch document
+func ParsePatch(data []byte) ([]PatchOperation, error) {
+	var patch []PatchOperation
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, err
+	}
+
+	for i, op := range patch {
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d (%s) is missing a value", i+1, op.Op)
+			}
+		case "move", "copy":
+			if op.From == "" {
+				return nil, fmt.Errorf("operation %d (%s) is missing 'from'", i+1, op.Op)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d has an unknown op \"%s\"", i+1, op.Op)
+		}
+	}
+
+	return patch, nil
+}
+
+// ApplyPatch applies a JSON Patch to the preferences. The patch is applied atomically: if any operation fails,
+// including a `test` operation which doesn't match, an error is returned and the preferences are left untouched.
+func ApplyPatch(prefs []byte, patch []PatchOperation) ([]byte, error) {
+	out := prefs
+
+	for i, op := range patch {
+		path, err := pointerToPath(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+		current := gjson.GetBytes(out, path)
+
+		switch op.Op {
+		case "test":
+			if !current.Exists() {
+				return nil, &PatchConflictError{Index: i, Path: op.Path, Expected: string(op.Value), Actual: "missing"}
+			}
+			if !jsonEqual(compactRaw(current), op.Value) {
+				return nil, &PatchConflictError{Index: i, Path: op.Path, Expected: string(op.Value), Actual: string(compactRaw(current))}
+			}
+		case "add":
+			out, err = sjson.SetRawBytes(out, path, op.Value)
+		case "replace":
+			if !current.Exists() {
+				return nil, fmt.Errorf("operation %d: cannot replace %s, it does not exist", i+1, op.Path)
+			}
+			out, err = sjson.SetRawBytes(out, path, op.Value)
+		case "remove":
+			if !current.Exists() {
+				return nil, fmt.Errorf("operation %d: cannot remove %s, it does not exist", i+1, op.Path)
+			}
+			out, err = sjson.DeleteBytes(out, path)
+		case "move", "copy":
+			var from string
+			from, err = pointerToPath(op.From)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i+1, err)
+			}
+			value := gjson.GetBytes(out, from)
+			if !value.Exists() {
+				return nil, fmt.Errorf("operation %d: cannot %s %s, it does not exist", i+1, op.Op, op.From)
+			}
+			if op.Op == "move" {
+				out, _ = sjson.DeleteBytes(out, from)
+			}
+			out, err = sjson.SetRawBytes(out, path, compactRaw(value))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+	}
+
+	return out, nil
+}
+
+// pointerToPath converts a JSON Pointer (RFC 6901) to a gjson/sjson path
+func pointerToPath(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("invalid path \"%s\", paths must start with '/'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, "~1", "/")
+		token = strings.ReplaceAll(token, "~0", "~")
+		if token == "-" {
+			// appending to an array
+			tokens[i] = "-1"
+		} else {
+			tokens[i] = PreferencePath(token)
+		}
+	}
+	if len(tokens) == 1 && tokens[0] == "" {
+		return "", errors.New("the preferences themselves can't be patched, only their keys")
+	}
+
+	return strings.Join(tokens, "."), nil
+}
+
+func escapePointerToken(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	return strings.ReplaceAll(token, "/", "~1")
+}
+
+// unionKeys returns the sorted keys which exist in any of the preference objects
+func unionKeys(prefs ...[]byte) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, object := range prefs {
+		for _, key := range PreferenceKeys(object) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func compactRaw(value gjson.Result) json.RawMessage {
+	return pretty.Ugly([]byte(value.Raw))
+}
+
+func jsonEqual(a []byte, b []byte) bool {
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCreateAndApplyPatch(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		ops  int
+	}{
+		{"unchanged", `{"a":"1"}`, `{"a":"1"}`, 0},
+		{"added", `{}`, `{"a":"1"}`, 1},
+		{"removed", `{"a":"1"}`, `{}`, 2},
+		{"replaced", `{"a":"1"}`, `{"a":"2"}`, 2},
+		{"typed values", `{"a":true,"b":1.5}`, `{"a":false,"b":1.5}`, 2},
+		{"keys with pointer and path syntax", `{"a/b":"1","c~d":"1"}`, `{"a/b":"2","c~d":"2","e.f":"1"}`, 5},
+	}
+
+	for _, test := range tests {
+		patch := CreatePatch([]byte(test.old), []byte(test.new))
+		if len(patch) != test.ops {
+			t.Errorf("%s: got %d operations, want %d: %v", test.name, len(patch), test.ops, patch)
+		}
+
+		// the patch survives being written and read again
+		data, err := json.Marshal(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patch, err = ParsePatch(data); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		got, err := ApplyPatch([]byte(test.old), patch)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !jsonEqual(got, []byte(test.new)) {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.new)
+		}
+	}
+}
+
+func TestApplyPatchConflict(t *testing.T) {
+	patch := CreatePatch([]byte(`{"a":"1","b":"1"}`), []byte(`{"a":"2","b":"2"}`))
+
+	tests := []struct {
+		name   string
+		prefs  string
+		actual string
+	}{
+		{"changed in the meantime", `{"a":"1","b":"3"}`, `"3"`},
+		{"removed in the meantime", `{"a":"1"}`, "missing"},
+	}
+
+	for _, test := range tests {
+		got, err := ApplyPatch([]byte(test.prefs), patch)
+		var conflict *PatchConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("%s: got %v, want a conflict", test.name, err)
+		}
+		if conflict.Path != "/b" || conflict.Expected != `"1"` || conflict.Actual != test.actual {
+			t.Errorf("%s: unexpected conflict %+v", test.name, conflict)
+		}
+		if got != nil {
+			t.Errorf("%s: got preferences %s after a conflict", test.name, got)
+		}
+	}
+}
+
+func TestApplyPatchOperations(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"move", `[{"op":"move","from":"/a","path":"/c"}]`, `{"b":"2","c":"1"}`},
+		{"copy", `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":"1","b":"2","c":"1"}`},
+		{"test", `[{"op":"test","path":"/a","value":"1"}]`, `{"a":"1","b":"2"}`},
+	}
+
+	for _, test := range tests {
+		patch, err := ParsePatch([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got, err := ApplyPatch([]byte(`{"a":"1","b":"2"}`), patch)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !jsonEqual(got, []byte(test.want)) {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	parseErrors := map[string]string{
+		"not an array":       `{"op":"add"}`,
+		"unknown op":         `[{"op":"merge","path":"/a"}]`,
+		"add without value":  `[{"op":"add","path":"/a"}]`,
+		"move without from":  `[{"op":"move","path":"/a"}]`,
+		"test without value": `[{"op":"test","path":"/a"}]`,
+	}
+	for name, data := range parseErrors {
+		if _, err := ParsePatch([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	applyErrors := map[string]string{
+		"relative path":        `[{"op":"remove","path":"a"}]`,
+		"whole preferences":    `[{"op":"remove","path":"/"}]`,
+		"replace missing":      `[{"op":"replace","path":"/missing","value":"1"}]`,
+		"remove missing":       `[{"op":"remove","path":"/missing"}]`,
+		"move missing":         `[{"op":"move","from":"/missing","path":"/b"}]`,
+		"fails after a change": `[{"op":"remove","path":"/a"},{"op":"remove","path":"/a"}]`,
+	}
+	for name, data := range applyErrors {
+		patch, err := ParsePatch([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := ApplyPatch([]byte(`{"a":"1"}`), patch); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
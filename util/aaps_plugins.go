@@ -0,0 +1,164 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AAPS stores the state of each plugin in the config builder as two preferences:
+//
+// Whether the plugin is enabled: `ConfigBuilder_[TYPE]_[PLUGIN]_Enabled = BOOLEAN`
+//
+// Whether the plugin is shown as a tab: `ConfigBuilder_[TYPE]_[PLUGIN]_Visible = BOOLEAN`
+//
+// TYPE is the plugin type (such as PUMP or APS), and PLUGIN is the class name of the plugin (such as DanaRSPlugin).
+const (
+	pluginKeyPrefix     = "ConfigBuilder_"
+	pluginEnabledSuffix = "_Enabled"
+	pluginVisibleSuffix = "_Visible"
+)
+
+// ExclusivePluginTypes are the plugin types of which only a single plugin may be enabled at once
+var ExclusivePluginTypes = []string{"PUMP", "APS", "BGSOURCE", "SENSITIVITY", "INSULIN", "PROFILE"}
+
+// PluginTypeNames are the readable names of the main plugin types, in the order the config builder shows them
+var PluginTypeNames = []struct {
+	Type string
+	Name string
+}{
+	{"PUMP", "Pump"},
+	{"BGSOURCE", "BG source"},
+	{"APS", "APS"},
+	{"SENSITIVITY", "Sensitivity"},
+	{"INSULIN", "Insulin"},
+	{"PROFILE", "Profile"},
+	{"SYNC", "Sync"},
+}
+
+// PluginEnabledKey returns the preference key storing whether a plugin is enabled
+func PluginEnabledKey(pluginType string, plugin string) string {
+	return pluginKeyPrefix + pluginType + "_" + plugin + pluginEnabledSuffix
+}
+
+// PluginVisibleKey returns the preference key storing whether a plugin is shown as a tab
+func PluginVisibleKey(pluginType string, plugin string) string {
+	return pluginKeyPrefix + pluginType + "_" + plugin + pluginVisibleSuffix
+}
+
+// Plugins returns the sorted names of all plugins of a type which have a state in the preferences
+func Plugins(prefs []byte, pluginType string) []string {
+	var plugins []string
+	prefix := pluginKeyPrefix + pluginType + "_"
+	for _, key := range PreferenceKeys(prefs) {
+		if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, pluginEnabledSuffix) {
+			plugins = append(plugins, strings.TrimSuffix(strings.TrimPrefix(key, prefix), pluginEnabledSuffix))
+		}
+	}
+	sort.Strings(plugins)
+	return plugins
+}
+
+// EnabledPlugins returns the sorted names of the enabled plugins of a type
+func EnabledPlugins(prefs []byte, pluginType string) []string {
+	var enabled []string
+	for _, plugin := range Plugins(prefs, pluginType) {
+		if IsPluginEnabled(prefs, pluginType, plugin) {
+			enabled = append(enabled, plugin)
+		}
+	}
+	return enabled
+}
+
+// IsPluginEnabled checks whether a plugin is enabled in the config builder
+func IsPluginEnabled(prefs []byte, pluginType string, plugin string) bool {
+	value, _ := GetPreference(prefs, PluginEnabledKey(pluginType, plugin))
+	return value == "true"
+}
+
+// IsExclusivePluginType checks whether only a single plugin of the type may be enabled at once
+func IsExclusivePluginType(pluginType string) bool {
+	for _, t := range ExclusivePluginTypes {
+		if t == pluginType {
+			return true
+		}
+	}
+	return false
+}
+
+// PluginTypes returns the sorted plugin types which have a plugin state in the preferences
+func PluginTypes(prefs []byte) []string {
+	seen := map[string]bool{}
+	var types []string
+	for _, key := range PreferenceKeys(prefs) {
+		if !strings.HasPrefix(key, pluginKeyPrefix) || !strings.HasSuffix(key, pluginEnabledSuffix) {
+			continue
+		}
+		pluginType, _, found := strings.Cut(strings.TrimPrefix(key, pluginKeyPrefix), "_")
+		if found && !seen[pluginType] {
+			seen[pluginType] = true
+			types = append(types, pluginType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// FindPlugin finds a plugin of a type by its class name, ignoring case and the "Plugin" suffix, or by an unambiguous
+// prefix of its class name, e.g. "OmnipodDash" finds "OmnipodDashPumpPlugin". Only plugins with a state in the
+// preferences are found.
+func FindPlugin(prefs []byte, pluginType string, name string) (string, error) {
+	plugins := Plugins(prefs, pluginType)
+	lower := strings.ToLower(name)
+
+	var prefixed []string
+	for _, plugin := range plugins {
+		class := strings.ToLower(plugin)
+		if class == lower || class == lower+"plugin" {
+			return plugin, nil
+		}
+		if strings.HasPrefix(class, lower) {
+			prefixed = append(prefixed, plugin)
+		}
+	}
+
+	switch len(prefixed) {
+	case 1:
+		return prefixed[0], nil
+	case 0:
+		if len(plugins) == 0 {
+			return "", fmt.Errorf("the export has no %s plugins", pluginType)
+		}
+		return "", fmt.Errorf("no %s plugin matches \"%s\", the plugins are: %s", pluginType, name, strings.Join(plugins, ", "))
+	default:
+		return "", fmt.Errorf("\"%s\" matches several %s plugins: %s", name, pluginType, strings.Join(prefixed, ", "))
+	}
+}
+
+// UsePlugin enables a plugin and shows its tab. For exclusive plugin types, all other plugins of the type are disabled
+// and their tabs hidden, so exactly one plugin is enabled.
+func UsePlugin(prefs []byte, pluginType string, plugin string) ([]byte, []Change) {
+	var changes []Change
+	set := func(key string, value string) {
+		old, ok := GetPreference(prefs, key)
+		if ok && old == value {
+			return
+		}
+		changes = append(changes, Change{Key: key, OldValue: old, NewValue: value, Added: !ok})
+		prefs = SetPreference(prefs, key, value)
+	}
+
+	if IsExclusivePluginType(pluginType) {
+		for _, other := range Plugins(prefs, pluginType) {
+			if other != plugin {
+				set(PluginEnabledKey(pluginType, other), "false")
+				if _, ok := GetPreference(prefs, PluginVisibleKey(pluginType, other)); ok {
+					set(PluginVisibleKey(pluginType, other), "false")
+				}
+			}
+		}
+	}
+	set(PluginEnabledKey(pluginType, plugin), "true")
+	set(PluginVisibleKey(pluginType, plugin), "true")
+	return prefs, changes
+}
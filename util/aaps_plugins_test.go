@@ -0,0 +1,96 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testPluginPrefs = `{
+	"ConfigBuilder_PUMP_DanaRSPlugin_Enabled":"true","ConfigBuilder_PUMP_DanaRSPlugin_Visible":"true",
+	"ConfigBuilder_PUMP_OmnipodDashPumpPlugin_Enabled":"false",
+	"ConfigBuilder_PUMP_OmnipodErosPumpPlugin_Enabled":"true","ConfigBuilder_PUMP_OmnipodErosPumpPlugin_Visible":"false",
+	"ConfigBuilder_SYNC_NSClientV3Plugin_Enabled":"true",
+	"ConfigBuilder_SYNC_TidepoolPlugin_Enabled":"false"}`
+
+func TestFindPlugin(t *testing.T) {
+	tests := []struct {
+		pluginType string
+		name       string
+		want       string
+	}{
+		{"PUMP", "DanaRSPlugin", "DanaRSPlugin"},
+		{"PUMP", "danars", "DanaRSPlugin"},
+		{"PUMP", "OmnipodDash", "OmnipodDashPumpPlugin"},
+		{"PUMP", "omnipode", "OmnipodErosPumpPlugin"},
+		{"PUMP", "Omnipod", ""},
+		{"PUMP", "Medtronic", ""},
+		{"APS", "OpenAPSSMB", ""},
+		{"SYNC", "tidepool", "TidepoolPlugin"},
+	}
+
+	for _, test := range tests {
+		got, err := FindPlugin([]byte(testPluginPrefs), test.pluginType, test.name)
+		if got != test.want || (err == nil) != (test.want != "") {
+			t.Errorf("FindPlugin(%s, %q) = %q, %v, want %q", test.pluginType, test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestUsePlugin(t *testing.T) {
+	tests := []struct {
+		name       string
+		pluginType string
+		plugin     string
+		enabled    []string
+		hidden     []string
+	}{
+		{
+			name:       "exclusive type",
+			pluginType: "PUMP",
+			plugin:     "OmnipodDashPumpPlugin",
+			enabled:    []string{"OmnipodDashPumpPlugin"},
+			hidden:     []string{"DanaRSPlugin", "OmnipodErosPumpPlugin"},
+		},
+		{
+			name:       "new plugin of an exclusive type",
+			pluginType: "PUMP",
+			plugin:     "VirtualPumpPlugin",
+			enabled:    []string{"VirtualPumpPlugin"},
+			hidden:     []string{"DanaRSPlugin", "OmnipodErosPumpPlugin"},
+		},
+		{
+			name:       "other plugins of a non-exclusive type are kept",
+			pluginType: "SYNC",
+			plugin:     "TidepoolPlugin",
+			enabled:    []string{"NSClientV3Plugin", "TidepoolPlugin"},
+		},
+	}
+
+	for _, test := range tests {
+		prefs, changes := UsePlugin([]byte(testPluginPrefs), test.pluginType, test.plugin)
+		if got := EnabledPlugins(prefs, test.pluginType); !reflect.DeepEqual(got, test.enabled) {
+			t.Errorf("%s: enabled plugins are %v, want %v", test.name, got, test.enabled)
+		}
+		if visible, _ := GetPreference(prefs, PluginVisibleKey(test.pluginType, test.plugin)); visible != "true" {
+			t.Errorf("%s: the tab of %s isn't shown", test.name, test.plugin)
+		}
+		for _, plugin := range test.hidden {
+			if visible, _ := GetPreference(prefs, PluginVisibleKey(test.pluginType, plugin)); visible != "false" {
+				t.Errorf("%s: the tab of %s is still shown", test.name, plugin)
+			}
+		}
+		if len(changes) == 0 {
+			t.Errorf("%s: no changes", test.name)
+		}
+
+		// plugins without a tab state don't get one
+		if _, ok := GetPreference(prefs, PluginVisibleKey("PUMP", "OmnipodDashPumpPlugin")); ok && test.plugin != "OmnipodDashPumpPlugin" {
+			t.Errorf("%s: a tab state was added to a disabled plugin", test.name)
+		}
+
+		// using the plugin again changes nothing
+		if _, again := UsePlugin(prefs, test.pluginType, test.plugin); len(again) != 0 {
+			t.Errorf("%s: using the plugin again changed %v", test.name, again)
+		}
+	}
+}
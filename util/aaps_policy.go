@@ -0,0 +1,235 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Policy is a set of rules restricting the allowed values of preferences, such as the rules of a clinic.
+//
+// Example:
+//
+//	rules:
+//	  - id: max-iob-new-loopers
+//	    description: Max IOB is limited until the autosens objective is completed
+//	    key: openapsmb_max_iob
+//	    max: 5
+//	    when:
+//	      objectives_not_completed: [8]
+//	  - id: no-smb-before-objective-9
+//	    key: use_smb
+//	    equals: "false"
+//	    when:
+//	      objectives_not_completed: [9]
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule restricts the value of a single preference. The rule only applies when all of its conditions are met.
+type PolicyRule struct {
+	ID          string          `yaml:"id"`
+	Description string          `yaml:"description"`
+	Severity    Severity        `yaml:"severity"`
+	When        PolicyCondition `yaml:"when"`
+	Key         string          `yaml:"key"`
+	// Required is set when the preference must exist
+	Required bool     `yaml:"required"`
+	Min      *float64 `yaml:"min"`
+	Max      *float64 `yaml:"max"`
+	Allowed  []string `yaml:"allowed"`
+	Equals   *string  `yaml:"equals"`
+}
+
+// PolicyCondition limits when a PolicyRule applies
+type PolicyCondition struct {
+	// ObjectivesCompleted requires all of these objectives to be completed
+	ObjectivesCompleted []int `yaml:"objectives_completed"`
+	// ObjectivesNotCompleted requires any of these objectives to not be completed
+	ObjectivesNotCompleted []int `yaml:"objectives_not_completed"`
+	// Preferences requires the preferences to have these values
+	Preferences map[string]string `yaml:"preferences"`
+}
+
+// PolicyViolation is a preference which violates a PolicyRule
+type PolicyViolation struct {
+	LintFinding
+	// Fix is the value which resolves the violation, if it can be fixed automatically
+	Fix *string `json:"fix,omitempty"`
+}
+
+// ParsePolicy parses and validates a YAML policy
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil {
+		return nil, err
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.ID == "" {
+			return nil, fmt.Errorf("rule %d: missing id", i+1)
+		}
+		if rule.Key == "" {
+			return nil, fmt.Errorf("rule %s: missing key", rule.ID)
+		}
+		if rule.Severity == "" {
+			rule.Severity = SeverityError
+		}
+		if rule.Severity.Rank() == 0 {
+			return nil, fmt.Errorf("rule %s: unknown severity \"%s\"", rule.ID, rule.Severity)
+		}
+		for _, num := range append(append([]int{}, rule.When.ObjectivesCompleted...), rule.When.ObjectivesNotCompleted...) {
+			if num < 1 || num > len(Objectives) {
+				return nil, fmt.Errorf("rule %s: unknown objective %d", rule.ID, num)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// Check evaluates the policy against the preferences of an export of the AAPS version, which determines the catalog
+// entries of the preferences
+func (p *Policy) Check(prefs []byte, version string) []PolicyViolation {
+	completed := GetCompletedObjectives(prefs)
+
+	var violations []PolicyViolation
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.When.matches(prefs, completed) {
+			continue
+		}
+		if violation := rule.check(prefs, version); violation != nil {
+			violations = append(violations, *violation)
+		}
+	}
+	return violations
+}
+
+// Fix sets every fixable violation to its fix, clamping numbers to the allowed range. The preferences are checked
+// again after each fix, since a fix can violate another rule on the same key or change the conditions of other rules.
+// A fix which was already applied once isn't applied again, so conflicting rules can't undo each other forever.
+// It returns the fixed preferences, the violations whose fix was applied and the violations which couldn't be fixed.
+func (p *Policy) Fix(prefs []byte, version string) ([]byte, []PolicyViolation, []PolicyViolation) {
+	applied := map[string]bool{}
+	var fixes []PolicyViolation
+	for {
+		fixed := false
+		for _, violation := range p.Check(prefs, version) {
+			if violation.Fix == nil {
+				continue
+			}
+			fix := violation.Key + "=" + *violation.Fix
+			if applied[fix] {
+				continue
+			}
+			applied[fix] = true
+			prefs = SetPreference(prefs, violation.Key, *violation.Fix)
+			fixes = append(fixes, violation)
+			fixed = true
+			break
+		}
+		if !fixed {
+			return prefs, fixes, p.Check(prefs, version)
+		}
+	}
+}
+
+func (c *PolicyCondition) matches(prefs []byte, completed []int) bool {
+	isCompleted := func(num int) bool {
+		for _, done := range completed {
+			if done == num {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, num := range c.ObjectivesCompleted {
+		if !isCompleted(num) {
+			return false
+		}
+	}
+	if len(c.ObjectivesNotCompleted) > 0 {
+		anyNotCompleted := false
+		for _, num := range c.ObjectivesNotCompleted {
+			if !isCompleted(num) {
+				anyNotCompleted = true
+			}
+		}
+		if !anyNotCompleted {
+			return false
+		}
+	}
+	for key, expected := range c.Preferences {
+		if value, _ := GetPreference(prefs, key); value != expected {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *PolicyRule) check(prefs []byte, version string) *PolicyViolation {
+	violation := func(message string, fix *string) *PolicyViolation {
+		if r.Description != "" {
+			message = fmt.Sprintf("%s (%s)", message, r.Description)
+		}
+		return &PolicyViolation{
+			LintFinding: LintFinding{RuleID: r.ID, Severity: r.Severity, Key: r.Key, Message: message},
+			Fix:         fix,
+		}
+	}
+
+	value, exists := GetPreference(prefs, r.Key)
+	if !exists {
+		if r.Required {
+			return violation("preference is missing", r.Equals)
+		}
+		// AAPS uses the default value for missing preferences
+		pref := LookupPreference(r.Key, version)
+		if pref == nil || pref.Default == nil {
+			return nil
+		}
+		value = *pref.Default
+	}
+
+	if r.Equals != nil && value != *r.Equals {
+		return violation(fmt.Sprintf("\"%s\" must be \"%s\"", value, *r.Equals), r.Equals)
+	}
+
+	if len(r.Allowed) > 0 && !containsString(r.Allowed, value) {
+		return violation(fmt.Sprintf("\"%s\" is not one of %s", value, strings.Join(r.Allowed, ", ")), nil)
+	}
+
+	if r.Min != nil || r.Max != nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return violation(fmt.Sprintf("\"%s\" is not a number", value), nil)
+		}
+		if r.Min != nil && number < *r.Min {
+			fix := r.bound(math.Ceil(*r.Min), *r.Min, version)
+			return violation(fmt.Sprintf("%s is below the minimum of %s", value, formatNumber(*r.Min)), &fix)
+		}
+		if r.Max != nil && number > *r.Max {
+			fix := r.bound(math.Floor(*r.Max), *r.Max, version)
+			return violation(fmt.Sprintf("%s is above the maximum of %s", value, formatNumber(*r.Max)), &fix)
+		}
+	}
+
+	return nil
+}
+
+// bound formats a bound of the rule as a value of its preference. Preferences of type int get the bound rounded into
+// the allowed range, since AAPS can't read a fraction from them.
+func (r *PolicyRule) bound(rounded float64, bound float64, version string) string {
+	if pref := LookupPreference(r.Key, version); pref != nil && pref.Type == TypeInt {
+		return formatNumber(rounded)
+	}
+	return formatNumber(bound)
+}
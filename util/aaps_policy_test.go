@@ -0,0 +1,138 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestPolicyFix(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		prefs     string
+		key       string
+		want      string
+		fixes     int
+		remaining int
+	}{
+		{
+			name:   "int preference is rounded into the range",
+			policy: "rules:\n  - {id: a, key: smscommunicator_remotebolusmindistance, max: 20.5}",
+			prefs:  `{"smscommunicator_remotebolusmindistance":"30"}`,
+			key:    "smscommunicator_remotebolusmindistance",
+			want:   "20",
+			fixes:  1,
+		},
+		{
+			name:   "int minimum is rounded up",
+			policy: "rules:\n  - {id: a, key: smscommunicator_remotebolusmindistance, min: 4.2}",
+			prefs:  `{"smscommunicator_remotebolusmindistance":"3"}`,
+			key:    "smscommunicator_remotebolusmindistance",
+			want:   "5",
+			fixes:  1,
+		},
+		{
+			name:   "double preference keeps fractions",
+			policy: "rules:\n  - {id: a, key: my_double, max: 2.5}",
+			prefs:  `{"my_double":"3"}`,
+			key:    "my_double",
+			want:   "2.5",
+			fixes:  1,
+		},
+		{
+			name:   "all rules on a key are satisfied",
+			policy: "rules:\n  - {id: a, key: my_value, max: 10}\n  - {id: b, key: my_value, max: 8}\n  - {id: c, key: my_value, min: 2}",
+			prefs:  `{"my_value":"20"}`,
+			key:    "my_value",
+			want:   "8",
+			fixes:  2,
+		},
+		{
+			name:   "a fix enabling another rule is fixed too",
+			policy: "rules:\n  - {id: a, key: use_smb, equals: \"true\"}\n  - {id: b, key: my_value, max: 5, when: {preferences: {use_smb: \"true\"}}}",
+			prefs:  `{"use_smb":"false","my_value":"9"}`,
+			key:    "my_value",
+			want:   "5",
+			fixes:  2,
+		},
+		{
+			name:      "conflicting rules remain",
+			policy:    "rules:\n  - {id: a, key: my_value, min: 10}\n  - {id: b, key: my_value, max: 5}",
+			prefs:     `{"my_value":"7"}`,
+			key:       "my_value",
+			want:      "5",
+			fixes:     2,
+			remaining: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := ParsePolicy([]byte(test.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prefs, fixes, remaining := policy.Fix([]byte(test.prefs), "")
+			if got, _ := GetPreference(prefs, test.key); got != test.want {
+				t.Errorf("%s = %q, want %q", test.key, got, test.want)
+			}
+			if len(fixes) != test.fixes {
+				t.Errorf("got %d fix(es), want %d: %v", len(fixes), test.fixes, fixes)
+			}
+			if len(remaining) != test.remaining {
+				t.Errorf("got %d remaining violation(s), want %d: %v", len(remaining), test.remaining, remaining)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckConditions(t *testing.T) {
+	policy, err := ParsePolicy([]byte("rules:\n  - {id: a, key: use_smb, equals: \"false\", when: {objectives_not_completed: [9]}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if violations := policy.Check([]byte(`{"use_smb":"true"}`), ""); len(violations) != 1 {
+		t.Errorf("got %d violation(s) before objective 9, want 1", len(violations))
+	}
+}
+
+func TestPolicyVersion(t *testing.T) {
+	double, integer := "1.5", "8"
+	catalog := PreferenceCatalog
+	PreferenceCatalog = append(append([]KnownPreference{}, catalog...),
+		KnownPreference{Key: "policy_test_value", Type: TypeDouble, Default: &double, Removed: "3.2"},
+		KnownPreference{Key: "policy_test_value", Type: TypeInt, Default: &integer, Since: "3.2"},
+	)
+	defer func() { PreferenceCatalog = catalog }()
+
+	policy, err := ParsePolicy([]byte("rules:\n  - {id: a, key: policy_test_value, max: 4.5}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the missing preference has the default value of the catalog entry for the version of the export
+	if violations := policy.Check([]byte(`{}`), "3.1.0"); len(violations) != 0 {
+		t.Errorf("got violations %v for 3.1.0", violations)
+	}
+	violations := policy.Check([]byte(`{}`), "3.2.0")
+	if len(violations) != 1 || violations[0].Fix == nil || *violations[0].Fix != "4" {
+		t.Errorf("got violations %v for 3.2.0, want a fix to the rounded maximum", violations)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing id":        "rules:\n  - {key: a}",
+		"missing key":       "rules:\n  - {id: a}",
+		"unknown severity":  "rules:\n  - {id: a, key: a, severity: fatal}",
+		"unknown objective": "rules:\n  - {id: a, key: a, when: {objectives_completed: [99]}}",
+		"unknown field":     "rules:\n  - {id: a, key: a, maximum: 1}",
+	}
+
+	for name, data := range tests {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
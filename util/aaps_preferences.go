@@ -2,9 +2,13 @@ package util
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/pretty"
 	"github.com/tidwall/sjson"
+	"sort"
+	"strings"
 )
 
 const (
@@ -15,6 +19,27 @@ const (
 	FileHashPlaceholder = "--to-be-calculated--"
 )
 
+// pathEscaper escapes the characters which have a special meaning in gjson/sjson paths
+var pathEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	".", `\.`,
+	"*", `\*`,
+	"?", `\?`,
+	"|", `\|`,
+	"#", `\#`,
+	"@", `\@`,
+	"!", `\!`,
+	"=", `\=`,
+	"<", `\<`,
+	">", `\>`,
+	"%", `\%`,
+)
+
+// PreferencePath converts a preference key to a gjson/sjson path, so keys containing path syntax are used literally
+func PreferencePath(key string) string {
+	return pathEscaper.Replace(key)
+}
+
 func CalculateFileHash(exportJson []byte) []byte {
 	exportJson, _ = sjson.SetBytes(exportJson, "security.file_hash", FileHashPlaceholder)
 
@@ -23,6 +48,12 @@ func CalculateFileHash(exportJson []byte) []byte {
 	return exportJson
 }
 
+// IsFileHashValid checks whether the file hash embedded in an export matches its contents
+func IsFileHashValid(exportJson []byte) bool {
+	hash := gjson.GetBytes(exportJson, "security.file_hash").String()
+	return gjson.GetBytes(CalculateFileHash(exportJson), "security.file_hash").String() == hash
+}
+
 func IsEncrypted(exportJson []byte) bool {
 	return gjson.GetBytes(exportJson, "format").String() == "aaps_encrypted"
 }
@@ -38,8 +69,40 @@ func ConvertPreferencesToObject(exportJson []byte) []byte {
 	return CalculateFileHash(export)
 }
 
+// ConvertPreferencesToTypedObject stores the preferences as a JSON object with native JSON booleans and numbers,
+// see TypePreferences
+func ConvertPreferencesToTypedObject(exportJson []byte) []byte {
+	exportJson = ConvertPreferencesToObject(exportJson)
+	content := TypePreferences([]byte(gjson.GetBytes(exportJson, "content").Raw), ExportVersion(exportJson))
+	export, _ := sjson.SetRawBytes(exportJson, "content", content)
+	export = pretty.Pretty(export)
+	return CalculateFileHash(export)
+}
+
+// ConvertPreferencesToExpandedObject stores the preferences as a JSON object in which JSON serialized inside string
+// values is expanded to real objects and arrays (see ExpandPreferences), optionally with native JSON booleans and
+// numbers (see TypePreferences)
+func ConvertPreferencesToExpandedObject(exportJson []byte, typed bool) ([]byte, error) {
+	exportJson = ConvertPreferencesToObject(exportJson)
+	version := ExportVersion(exportJson)
+
+	content, err := ExpandPreferences([]byte(gjson.GetBytes(exportJson, "content").Raw), version)
+	if err != nil {
+		return nil, err
+	}
+	if typed {
+		content = TypePreferences(content, version)
+	}
+
+	export, _ := sjson.SetRawBytes(exportJson, "content", content)
+	export = pretty.Pretty(export)
+	return CalculateFileHash(export), nil
+}
+
+// ConvertPreferencesToString stores the preferences as a JSON string, which is the only format AAPS can import.
+// Typed preferences are converted back to strings first.
 func ConvertPreferencesToString(exportJson []byte) []byte {
-	content := gjson.GetBytes(exportJson, "content|@ugly").Raw
+	content := string(pretty.Ugly(UntypePreferences([]byte(gjson.GetBytes(exportJson, "content").Raw))))
 	export, _ := sjson.SetBytes(exportJson, "content", content)
 	export = pretty.Pretty(export)
 	return CalculateFileHash(export)
@@ -70,3 +133,139 @@ func ConvertToEncryptedFormat(unencryptedExportJson []byte, salt []byte, encrypt
 
 	return CalculateFileHash(output)
 }
+
+// TypePreferences converts the string values of preferences to native JSON booleans and numbers.
+// Known preferences are converted according to their type in the catalog, unknown preferences when their value is a
+// JSON boolean or number. Numbers keep their exact text (e.g. "3.0" becomes 3.0), so UntypePreferences restores the
+// original strings.
+func TypePreferences(prefs []byte, version string) []byte {
+	for _, key := range PreferenceKeys(prefs) {
+		result := gjson.GetBytes(prefs, PreferencePath(key))
+		if result.Type != gjson.String {
+			continue
+		}
+		value := result.String()
+
+		isBool := value == "true" || value == "false"
+		isNumber := !isBool && gjson.Valid(value) && gjson.Parse(value).Type == gjson.Number
+
+		typed := isBool || isNumber
+		if pref := LookupPreference(key, version); pref != nil {
+			switch pref.Type {
+			case TypeBool:
+				typed = isBool
+			case TypeInt, TypeDouble:
+				typed = isNumber
+			default:
+				typed = false
+			}
+		}
+		if typed {
+			prefs, _ = sjson.SetRawBytes(prefs, PreferencePath(key), []byte(value))
+		}
+	}
+	return prefs
+}
+
+// UntypePreferences converts native JSON booleans and numbers of preferences back to the strings AAPS stores them as.
+// Expanded objects and arrays are serialized back into compact JSON strings.
+func UntypePreferences(prefs []byte) []byte {
+	for _, key := range PreferenceKeys(prefs) {
+		result := gjson.GetBytes(prefs, PreferencePath(key))
+		switch {
+		case result.Type == gjson.True, result.Type == gjson.False, result.Type == gjson.Number:
+			prefs = SetPreference(prefs, key, result.Raw)
+		case result.IsObject(), result.IsArray():
+			prefs = SetPreference(prefs, key, string(pretty.Ugly([]byte(result.Raw))))
+		}
+	}
+	return prefs
+}
+
+// ExpandPreferences parses JSON serialized inside string values, such as automation events, QuickWizard entries and
+// local profiles, into real objects and arrays. Known preferences are expanded when their type in the catalog is json,
+// unknown preferences when their value is a JSON object or array. UntypePreferences serializes them back.
+func ExpandPreferences(prefs []byte, version string) ([]byte, error) {
+	for _, key := range PreferenceKeys(prefs) {
+		result := gjson.GetBytes(prefs, PreferencePath(key))
+		if result.Type != gjson.String {
+			continue
+		}
+		value := strings.TrimSpace(result.String())
+
+		isJson := (strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[")) && json.Valid([]byte(value))
+		if pref := LookupPreference(key, version); pref != nil {
+			if pref.Type != TypeJson || value == "" {
+				continue
+			}
+			if !isJson {
+				return nil, fmt.Errorf("preference \"%s\": value is not a JSON object or array", key)
+			}
+		}
+		if isJson {
+			prefs, _ = sjson.SetRawBytes(prefs, PreferencePath(key), []byte(value))
+		}
+	}
+	return prefs, nil
+}
+
+// ValidateExpandedPreferences checks that every expanded object and array of the preferences is valid JSON,
+// naming the first preference which isn't
+func ValidateExpandedPreferences(prefs []byte) error {
+	for _, key := range PreferenceKeys(prefs) {
+		result := gjson.GetBytes(prefs, PreferencePath(key))
+		if (result.IsObject() || result.IsArray()) && !json.Valid([]byte(result.Raw)) {
+			return fmt.Errorf("preference \"%s\": invalid JSON", key)
+		}
+	}
+	return nil
+}
+
+// HasExpandedPreferences checks whether any preference is an expanded JSON object or array instead of a string
+func HasExpandedPreferences(prefs []byte) bool {
+	expanded := false
+	gjson.ParseBytes(prefs).ForEach(func(_, value gjson.Result) bool {
+		expanded = value.IsObject() || value.IsArray()
+		return !expanded
+	})
+	return expanded
+}
+
+// HasTypedPreferences checks whether any preference is a native JSON boolean or number instead of a string
+func HasTypedPreferences(prefs []byte) bool {
+	typed := false
+	gjson.ParseBytes(prefs).ForEach(func(_, value gjson.Result) bool {
+		typed = value.Type == gjson.True || value.Type == gjson.False || value.Type == gjson.Number
+		return !typed
+	})
+	return typed
+}
+
+// PreferenceKeys returns the sorted keys of a preferences JSON object
+func PreferenceKeys(prefs []byte) []string {
+	var keys []string
+	gjson.ParseBytes(prefs).ForEach(func(key, _ gjson.Result) bool {
+		keys = append(keys, key.String())
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// GetPreference returns the value of a preference, and whether it exists
+func GetPreference(prefs []byte, key string) (string, bool) {
+	result := gjson.GetBytes(prefs, PreferencePath(key))
+	return result.String(), result.Exists()
+}
+
+// SetPreference sets a preference to a string value, which is how AAPS stores all preferences in an export
+func SetPreference(prefs []byte, key string, value string) []byte {
+	out, _ := sjson.SetBytes(prefs, PreferencePath(key), value)
+	return out
+}
+
+// DeletePreference removes a preference
+func DeletePreference(prefs []byte, key string) []byte {
+	out, _ := sjson.DeleteBytes(prefs, PreferencePath(key))
+	return out
+}
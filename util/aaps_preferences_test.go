@@ -0,0 +1,100 @@
+package util
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestTypePreferences(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		// want is the raw JSON of the typed value
+		want string
+	}{
+		{"use_smb", "true", `true`},
+		{"use_smb", "yes", `"yes"`},
+		{"openapsmb_max_iob", "3.0", `3.0`},
+		{"loop_openmode_min_change", "20", `20`},
+		{"patient_name", "007", `"007"`},
+		{"units", "mg/dl", `"mg/dl"`},
+		{"unknown_bool", "false", `false`},
+		{"unknown_number", "1.50", `1.50`},
+		{"unknown_string", "abc", `"abc"`},
+		{"unknown_json", `{"a":1}`, `"{\"a\":1}"`},
+	}
+
+	for _, test := range tests {
+		prefs := SetPreference([]byte(`{}`), test.key, test.value)
+		typed := TypePreferences(prefs, "3.2.0")
+
+		if got := string(compactRaw(gjson.GetBytes(typed, PreferencePath(test.key)))); got != test.want {
+			t.Errorf("%s = %s: got %s, want %s", test.key, test.value, got, test.want)
+		}
+		if untyped := UntypePreferences(typed); !jsonEqual(untyped, prefs) {
+			t.Errorf("%s = %s: untyped to %s", test.key, test.value, untyped)
+		}
+		if isTyped := test.want[0] != '"'; HasTypedPreferences(typed) != isTyped {
+			t.Errorf("%s = %s: HasTypedPreferences is %v, want %v", test.key, test.value, !isTyped, isTyped)
+		}
+	}
+}
+
+func TestExpandPreferences(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		// want is the raw JSON of the expanded value
+		want string
+	}{
+		{"QuickWizard", `[{"buttonText":"Meal"}]`, `[{"buttonText":"Meal"}]`},
+		{"QuickWizard", ` [] `, `[]`},
+		{"QuickWizard", "", `""`},
+		{"units", `{"a":1}`, `"{\"a\":1}"`},
+		{"unknown_object", `{"a":[1,2]}`, `{"a":[1,2]}`},
+		{"unknown_invalid", `{"a":`, `"{\"a\":"`},
+		{"unknown_number", `1`, `"1"`},
+	}
+
+	for _, test := range tests {
+		prefs := SetPreference([]byte(`{}`), test.key, test.value)
+		expanded, err := ExpandPreferences(prefs, "3.2.0")
+		if err != nil {
+			t.Fatalf("%s = %s: %s", test.key, test.value, err)
+		}
+
+		if got := string(compactRaw(gjson.GetBytes(expanded, PreferencePath(test.key)))); got != test.want {
+			t.Errorf("%s = %s: got %s, want %s", test.key, test.value, got, test.want)
+		}
+		if err := ValidateExpandedPreferences(expanded); err != nil {
+			t.Errorf("%s = %s: %s", test.key, test.value, err)
+		}
+		if isExpanded := test.want[0] != '"'; HasExpandedPreferences(expanded) != isExpanded {
+			t.Errorf("%s = %s: HasExpandedPreferences is %v, want %v", test.key, test.value, !isExpanded, isExpanded)
+		}
+	}
+}
+
+func TestExpandPreferencesRoundTrip(t *testing.T) {
+	prefs := []byte(`{"QuickWizard":"[{\"buttonText\":\"Meal\",\"carbs\":30}]","use_smb":"true","units":"mg/dl"}`)
+
+	expanded, err := ExpandPreferences(prefs, "3.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed := TypePreferences(expanded, "3.2.0")
+	if untyped := UntypePreferences(typed); !jsonEqual(untyped, prefs) {
+		t.Errorf("got %s, want %s", untyped, prefs)
+	}
+}
+
+func TestExpandPreferencesErrors(t *testing.T) {
+	prefs := SetPreference([]byte(`{}`), "QuickWizard", "not json")
+	if _, err := ExpandPreferences(prefs, "3.2.0"); err == nil {
+		t.Errorf("an invalid JSON preference was expanded")
+	}
+
+	if err := ValidateExpandedPreferences([]byte(`{"a":{"b":}}`)); err == nil {
+		t.Errorf("invalid expanded JSON was accepted")
+	}
+}
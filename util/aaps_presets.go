@@ -0,0 +1,288 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+	"math"
+	"strings"
+)
+
+// QuickWizardKey is the preference in which the QuickWizard buttons are stored as a JSON array of objects
+const QuickWizardKey = "QuickWizard"
+
+// values of the QuickWizard options, as in QuickWizardEntry of AAPS. Only useTrend supports the positive and
+// negative only values.
+const (
+	QuickWizardYes          = 0
+	QuickWizardNo           = 1
+	QuickWizardPositiveOnly = 2
+	QuickWizardNegativeOnly = 3
+)
+
+// QuickWizardOptions are the options of a QuickWizard button, which decide what the bolus calculation includes
+var QuickWizardOptions = []QuickWizardOption{
+	{Key: "useBG", Name: "bg", Description: "BG", Default: QuickWizardYes},
+	{Key: "useCOB", Name: "cob", Description: "COB", Default: QuickWizardNo},
+	{Key: "useBolusIOB", Name: "bolus-iob", Description: "bolus IOB", Default: QuickWizardYes},
+	{Key: "useBasalIOB", Name: "basal-iob", Description: "basal IOB", Default: QuickWizardYes},
+	{Key: "useTrend", Name: "trend", Description: "trend", Default: QuickWizardNo, Signed: true},
+	{Key: "useSuperBolus", Name: "superbolus", Description: "superbolus", Default: QuickWizardNo},
+	{Key: "useTempTarget", Name: "temptarget", Description: "temp target", Default: QuickWizardNo},
+}
+
+// QuickWizardOption is an option of a QuickWizard button. Signed options also support QuickWizardPositiveOnly and
+// QuickWizardNegativeOnly.
+type QuickWizardOption struct {
+	Key         string
+	Name        string
+	Description string
+	Default     int
+	Signed      bool
+}
+
+// QuickWizardEntry is a QuickWizard button. Raw is its compact JSON object, which keeps fields unknown to this tool.
+// The time window is in seconds since midnight, and the button is offered within it, both ends included.
+type QuickWizardEntry struct {
+	Raw       string
+	Text      string
+	Carbs     int
+	ValidFrom int
+	ValidTo   int
+}
+
+// NewQuickWizardEntry creates a QuickWizard button with the default options
+func NewQuickWizardEntry() QuickWizardEntry {
+	entry := QuickWizardEntry{Raw: "{}"}
+	entry.SetText("")
+	entry.SetCarbs(0)
+	entry.SetWindow(0, 86340)
+	for _, option := range QuickWizardOptions {
+		entry.SetOption(option.Key, option.Default)
+	}
+	return entry
+}
+
+// QuickWizardEntries reads the QuickWizard buttons of the preferences
+func QuickWizardEntries(prefs []byte) ([]QuickWizardEntry, error) {
+	value, ok := GetPreference(prefs, QuickWizardKey)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	if !gjson.Valid(value) || !gjson.Parse(value).IsArray() {
+		return nil, fmt.Errorf("preference \"%s\" is not a JSON array", QuickWizardKey)
+	}
+
+	var entries []QuickWizardEntry
+	var err error
+	gjson.Parse(value).ForEach(func(_, item gjson.Result) bool {
+		if !item.IsObject() {
+			err = fmt.Errorf("QuickWizard %d: expected a JSON object", len(entries)+1)
+			return false
+		}
+		entries = append(entries, QuickWizardEntry{
+			Raw:       string(pretty.Ugly([]byte(item.Raw))),
+			Text:      item.Get("buttonText").String(),
+			Carbs:     int(item.Get("carbs").Int()),
+			ValidFrom: int(item.Get("validFrom").Int()),
+			ValidTo:   int(item.Get("validTo").Int()),
+		})
+		return true
+	})
+	return entries, err
+}
+
+// SetQuickWizardEntries stores the QuickWizard buttons in the preferences
+func SetQuickWizardEntries(prefs []byte, entries []QuickWizardEntry) []byte {
+	raws := make([]string, len(entries))
+	for i, entry := range entries {
+		raws[i] = entry.Raw
+	}
+	return SetPreference(prefs, QuickWizardKey, "["+strings.Join(raws, ",")+"]")
+}
+
+func (e *QuickWizardEntry) SetText(text string) {
+	e.Raw, _ = sjson.Set(e.Raw, "buttonText", text)
+	e.Text = text
+}
+
+func (e *QuickWizardEntry) SetCarbs(carbs int) {
+	e.Raw, _ = sjson.Set(e.Raw, "carbs", carbs)
+	e.Carbs = carbs
+}
+
+func (e *QuickWizardEntry) SetWindow(from int, to int) {
+	e.Raw, _ = sjson.Set(e.Raw, "validFrom", from)
+	e.Raw, _ = sjson.Set(e.Raw, "validTo", to)
+	e.ValidFrom, e.ValidTo = from, to
+}
+
+// Option returns the value of an option, or its default if the button doesn't set it
+func (e *QuickWizardEntry) Option(key string) int {
+	result := gjson.Get(e.Raw, key)
+	if !result.Exists() {
+		for _, option := range QuickWizardOptions {
+			if option.Key == key {
+				return option.Default
+			}
+		}
+	}
+	return int(result.Int())
+}
+
+func (e *QuickWizardEntry) SetOption(key string, value int) {
+	e.Raw, _ = sjson.Set(e.Raw, key, value)
+}
+
+// Validate checks the carbs, the time window and the options of the button
+func (e *QuickWizardEntry) Validate() error {
+	if strings.TrimSpace(e.Text) == "" {
+		return errors.New("the button text is empty")
+	}
+	if e.Carbs <= 0 {
+		return fmt.Errorf("\"%s\": carbs must be positive", e.Text)
+	}
+	if e.ValidFrom < 0 || e.ValidTo >= 86400 || e.ValidFrom > e.ValidTo {
+		return fmt.Errorf("\"%s\": invalid time window %s-%s, it must be within a day", e.Text, FormatTimeOfDay(e.ValidFrom), FormatTimeOfDay(e.ValidTo))
+	}
+	for _, option := range QuickWizardOptions {
+		value := e.Option(option.Key)
+		if value < QuickWizardYes || value > QuickWizardNegativeOnly || !option.Signed && value > QuickWizardNo {
+			return fmt.Errorf("\"%s\": invalid value %d of %s", e.Text, value, option.Key)
+		}
+	}
+	return nil
+}
+
+// Overlaps checks whether the time windows of two buttons overlap. Both ends of a window are included, so windows
+// sharing their end and start time, like 08:00-09:00 and 09:00-10:00, overlap, while 08:00-08:59 and 09:00-10:00
+// don't.
+func (e *QuickWizardEntry) Overlaps(other *QuickWizardEntry) bool {
+	return e.ValidFrom <= other.ValidTo && other.ValidFrom <= e.ValidTo
+}
+
+// QuickWizardOverlaps returns an error for every pair of buttons with overlapping time windows
+func QuickWizardOverlaps(entries []QuickWizardEntry) []error {
+	var errs []error
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if entries[i].Overlaps(&entries[j]) {
+				errs = append(errs, quickWizardOverlapError(&entries[i], &entries[j]))
+			}
+		}
+	}
+	return errs
+}
+
+// QuickWizardOverlapsOf returns an error for every other button whose time window overlaps the one of the button at
+// the index, ignoring overlaps among the other buttons
+func QuickWizardOverlapsOf(entries []QuickWizardEntry, index int) []error {
+	var errs []error
+	for i := range entries {
+		if i != index && entries[index].Overlaps(&entries[i]) {
+			errs = append(errs, quickWizardOverlapError(&entries[index], &entries[i]))
+		}
+	}
+	return errs
+}
+
+func quickWizardOverlapError(a *QuickWizardEntry, b *QuickWizardEntry) error {
+	return fmt.Errorf("the time windows of \"%s\" (%s-%s) and \"%s\" (%s-%s) overlap",
+		a.Text, FormatTimeOfDay(a.ValidFrom), FormatTimeOfDay(a.ValidTo),
+		b.Text, FormatTimeOfDay(b.ValidFrom), FormatTimeOfDay(b.ValidTo))
+}
+
+// TempTargetPresetNames are the temporary target presets of AAPS, which are stored in <name>_target and
+// <name>_duration preferences
+var TempTargetPresetNames = []string{"eatingsoon", "activity", "hypo"}
+
+// TempTargetPreset is a temporary target preset, with the target in the glucose units of the app
+type TempTargetPreset struct {
+	Name     string
+	Target   float64
+	Duration int
+}
+
+// GlucoseUnits returns the glucose units of the app, UnitsMgdl or UnitsMmol
+func GlucoseUnits(prefs []byte) string {
+	if units, ok := GetPreference(prefs, "units"); ok && units == UnitsMmol {
+		return UnitsMmol
+	}
+	return UnitsMgdl
+}
+
+// ConvertGlucose converts a glucose value between UnitsMgdl and UnitsMmol. mg/dl values are rounded to whole numbers
+// and mmol/l values to one decimal, like AAPS shows them.
+func ConvertGlucose(value float64, from string, to string) float64 {
+	switch {
+	case from == to:
+		return value
+	case to == UnitsMgdl:
+		return math.Round(value * MgdlPerMmol)
+	default:
+		return roundTo(value/MgdlPerMmol, 1)
+	}
+}
+
+// TempTargetPresets reads the temporary target presets, using the catalog defaults for presets which aren't set
+func TempTargetPresets(prefs []byte, version string) ([]TempTargetPreset, error) {
+	units := GlucoseUnits(prefs)
+	var presets []TempTargetPreset
+	for _, name := range TempTargetPresetNames {
+		target, err := presetValue(prefs, name+"_target", version)
+		if err != nil {
+			return nil, err
+		}
+		duration, err := presetValue(prefs, name+"_duration", version)
+		if err != nil {
+			return nil, err
+		}
+
+		// the catalog defaults are in mg/dl
+		if _, ok := GetPreference(prefs, name+"_target"); !ok {
+			target = ConvertGlucose(target, UnitsMgdl, units)
+		}
+		presets = append(presets, TempTargetPreset{Name: name, Target: target, Duration: int(duration)})
+	}
+	return presets, nil
+}
+
+// SetTempTargetPreset stores a temporary target preset, with its target in the glucose units of the app. The target
+// is checked against the catalog range, which is in mg/dl.
+func SetTempTargetPreset(prefs []byte, preset TempTargetPreset, version string) ([]byte, error) {
+	units := GlucoseUnits(prefs)
+	targetKey, durationKey := preset.Name+"_target", preset.Name+"_duration"
+
+	if pref := LookupPreference(targetKey, version); pref != nil {
+		if err := pref.Validate(formatNumber(ConvertGlucose(preset.Target, units, UnitsMgdl))); err != nil {
+			return nil, fmt.Errorf("%s target: %s mg/dl", preset.Name, err)
+		}
+	}
+	if pref := LookupPreference(durationKey, version); pref != nil {
+		if err := pref.Validate(fmt.Sprint(preset.Duration)); err != nil {
+			return nil, fmt.Errorf("%s duration: %s minutes", preset.Name, err)
+		}
+	}
+
+	prefs = SetPreference(prefs, targetKey, formatNumber(preset.Target))
+	prefs = SetPreference(prefs, durationKey, fmt.Sprint(preset.Duration))
+	return prefs, nil
+}
+
+func presetValue(prefs []byte, key string, version string) (float64, error) {
+	value, ok := GetPreference(prefs, key)
+	if !ok {
+		pref := LookupPreference(key, version)
+		if pref == nil || pref.Default == nil {
+			return 0, nil
+		}
+		value = *pref.Default
+	}
+	number := gjson.Parse(value)
+	if number.Type != gjson.Number {
+		return 0, fmt.Errorf("preference \"%s\": \"%s\" is not a number", key, value)
+	}
+	return number.Float(), nil
+}
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuickWizardEntries(t *testing.T) {
+	prefs := SetPreference([]byte(`{}`), QuickWizardKey,
+		`[{"buttonText":"Breakfast","carbs":40,"validFrom":21600,"validTo":35940,"useCOB":0,"useTempTarget":0,"usePercentage":1},`+
+			`{"buttonText":"Snack","carbs":15,"validFrom":36000,"validTo":86340}]`)
+
+	entries, err := QuickWizardEntries(prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	breakfast := entries[0]
+	if breakfast.Text != "Breakfast" || breakfast.Carbs != 40 || breakfast.ValidFrom != 21600 || breakfast.ValidTo != 35940 {
+		t.Errorf("unexpected entry %+v", breakfast)
+	}
+	tests := []struct {
+		entry QuickWizardEntry
+		key   string
+		want  int
+	}{
+		{breakfast, "useCOB", QuickWizardYes},
+		{breakfast, "useTempTarget", QuickWizardYes},
+		{breakfast, "useBG", QuickWizardYes},
+		{entries[1], "useCOB", QuickWizardNo},
+		{entries[1], "useTempTarget", QuickWizardNo},
+	}
+	for _, test := range tests {
+		if got := test.entry.Option(test.key); got != test.want {
+			t.Errorf("%s: %s = %d, want %d", test.entry.Text, test.key, got, test.want)
+		}
+	}
+
+	// fields unknown to this tool are kept
+	saved, err := QuickWizardEntries(SetQuickWizardEntries([]byte(`{}`), entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[0].Raw != breakfast.Raw {
+		t.Errorf("got %s, want %s", saved[0].Raw, breakfast.Raw)
+	}
+}
+
+func TestQuickWizardEntriesErrors(t *testing.T) {
+	tests := map[string]string{
+		"not JSON":       `[{`,
+		"not an array":   `{"buttonText":"Breakfast"}`,
+		"not an object":  `[1]`,
+		"second invalid": `[{"buttonText":"Breakfast"},"Snack"]`,
+	}
+
+	for name, value := range tests {
+		if _, err := QuickWizardEntries(SetPreference([]byte(`{}`), QuickWizardKey, value)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	if entries, err := QuickWizardEntries([]byte(`{}`)); err != nil || entries != nil {
+		t.Errorf("missing preference: got %v, %v", entries, err)
+	}
+}
+
+func TestQuickWizardEntryValidate(t *testing.T) {
+	valid := NewQuickWizardEntry()
+	valid.SetText("Breakfast")
+	valid.SetCarbs(40)
+
+	tests := []struct {
+		name  string
+		edit  func(e *QuickWizardEntry)
+		valid bool
+	}{
+		{"valid", func(e *QuickWizardEntry) {}, true},
+		{"empty text", func(e *QuickWizardEntry) { e.SetText(" ") }, false},
+		{"no carbs", func(e *QuickWizardEntry) { e.SetCarbs(0) }, false},
+		{"reversed window", func(e *QuickWizardEntry) { e.SetWindow(36000, 21600) }, false},
+		{"window past midnight", func(e *QuickWizardEntry) { e.SetWindow(0, 86400) }, false},
+		{"signed trend", func(e *QuickWizardEntry) { e.SetOption("useTrend", QuickWizardNegativeOnly) }, true},
+		{"signed cob", func(e *QuickWizardEntry) { e.SetOption("useCOB", QuickWizardPositiveOnly) }, false},
+	}
+
+	for _, test := range tests {
+		entry := valid
+		test.edit(&entry)
+		if err := entry.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestQuickWizardOverlaps(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b [2]string
+		want bool
+	}{
+		{"separate", [2]string{"06:00", "08:59"}, [2]string{"09:00", "10:00"}, false},
+		{"shared end", [2]string{"08:00", "09:00"}, [2]string{"09:00", "10:00"}, true},
+		{"contained", [2]string{"06:00", "12:00"}, [2]string{"09:00", "10:00"}, true},
+		{"same", [2]string{"09:00", "09:00"}, [2]string{"09:00", "09:00"}, true},
+	}
+
+	for _, test := range tests {
+		a, b := NewQuickWizardEntry(), NewQuickWizardEntry()
+		a.SetWindow(parseTime(t, test.a[0]), parseTime(t, test.a[1]))
+		b.SetWindow(parseTime(t, test.b[0]), parseTime(t, test.b[1]))
+		if got := a.Overlaps(&b); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+		if got := len(QuickWizardOverlaps([]QuickWizardEntry{a, b})) > 0; got != test.want {
+			t.Errorf("%s: QuickWizardOverlaps got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func parseTime(t *testing.T, time string) int {
+	seconds, err := ParseTimeOfDay(time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seconds
+}
+
+func TestQuickWizardOverlapsOf(t *testing.T) {
+	window := func(text string, from string, to string) QuickWizardEntry {
+		entry := NewQuickWizardEntry()
+		entry.SetText(text)
+		entry.SetWindow(parseTime(t, from), parseTime(t, to))
+		return entry
+	}
+	// the first two buttons already overlap
+	entries := []QuickWizardEntry{
+		window("Breakfast", "06:00", "10:00"),
+		window("Early lunch", "09:00", "12:00"),
+		window("Dinner", "17:00", "20:00"),
+	}
+
+	tests := []struct {
+		index int
+		want  int
+	}{
+		{0, 1},
+		{1, 1},
+		{2, 0},
+	}
+	for _, test := range tests {
+		if got := len(QuickWizardOverlapsOf(entries, test.index)); got != test.want {
+			t.Errorf("button %d: got %d overlap(s), want %d", test.index+1, got, test.want)
+		}
+	}
+
+	entries = append(entries, window("Snack", "19:00", "21:00"))
+	if errs := QuickWizardOverlapsOf(entries, 3); len(errs) != 1 || !strings.Contains(errs[0].Error(), "Dinner") {
+		t.Errorf("got %v, want an overlap with Dinner", errs)
+	}
+}
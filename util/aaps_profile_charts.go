@@ -0,0 +1,249 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ChartGlyphs are the markers of the series in ASCII charts, in order
+const ChartGlyphs = "*o+x%&"
+
+// ChartColors are the colors of the series in SVG charts, in order
+var ChartColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b"}
+
+// ChartSeries is one profile in a chart. Upper is only set for target ranges, with Schedule as the lower bound.
+type ChartSeries struct {
+	Label    string
+	Schedule ProfileSchedule
+	Upper    ProfileSchedule
+}
+
+// ProfileChart is a chart of the same schedule of one or more profiles over 24 hours
+type ProfileChart struct {
+	Title     string
+	Units     string
+	ZeroBased bool
+	Series    []ChartSeries
+}
+
+// ProfileCharts returns the basal, ISF, IC and target charts of the profiles, each profile being a series with the
+// given label. ISF and targets are converted to the units of the first profile, so profiles can be compared.
+func ProfileCharts(profiles []LocalProfile, labels []string) []ProfileChart {
+	if len(profiles) == 0 {
+		return nil
+	}
+	mgdl := profiles[0].Mgdl
+	units := profiles[0].Units()
+
+	charts := []ProfileChart{
+		{Title: "Basal", Units: "U/h", ZeroBased: true},
+		{Title: "ISF", Units: units + "/U"},
+		{Title: "IC", Units: "g/U"},
+		{Title: "Target", Units: units},
+	}
+	for i := range profiles {
+		profile := &profiles[i]
+		factor := 1.0
+		if profile.Mgdl && !mgdl {
+			factor = 1 / MgdlPerMmol
+		} else if !profile.Mgdl && mgdl {
+			factor = MgdlPerMmol
+		}
+
+		charts[0].Series = append(charts[0].Series, ChartSeries{Label: labels[i], Schedule: profile.Basal})
+		charts[1].Series = append(charts[1].Series, ChartSeries{Label: labels[i], Schedule: scaleSchedule(profile.ISF, factor)})
+		charts[2].Series = append(charts[2].Series, ChartSeries{Label: labels[i], Schedule: profile.IC})
+		charts[3].Series = append(charts[3].Series, ChartSeries{
+			Label:    labels[i],
+			Schedule: scaleSchedule(profile.TargetLow, factor),
+			Upper:    scaleSchedule(profile.TargetHigh, factor),
+		})
+	}
+	return charts
+}
+
+// valueRange returns the lowest and highest value of all series, padded when all values are equal
+func (c *ProfileChart) valueRange() (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, series := range c.Series {
+		for _, schedule := range []ProfileSchedule{series.Schedule, series.Upper} {
+			for _, block := range schedule {
+				low = math.Min(low, block.Value)
+				high = math.Max(high, block.Value)
+			}
+		}
+	}
+	if math.IsInf(low, 0) {
+		return 0, 1
+	}
+	if c.ZeroBased {
+		low = math.Min(low, 0)
+	}
+	if low == high {
+		low, high = low-1, high+1
+		if c.ZeroBased && low < 0 {
+			low = 0
+		}
+	}
+	return low, high
+}
+
+// ASCII renders the chart as text with one column per half hour and the given number of rows. Each series is drawn
+// with its glyph of ChartGlyphs, and '#' where several series overlap.
+func (c *ProfileChart) ASCII(height int) string {
+	const columns = 48
+	low, high := c.valueRange()
+
+	grid := make([][]byte, height)
+	for i := range grid {
+		grid[i] = bytes.Repeat([]byte{' '}, columns)
+	}
+	plot := func(row int, column int, glyph byte) {
+		cell := &grid[height-1-row][column]
+		if *cell != ' ' && *cell != glyph {
+			*cell = '#'
+		} else {
+			*cell = glyph
+		}
+	}
+
+	for i, series := range c.Series {
+		glyph := ChartGlyphs[i%len(ChartGlyphs)]
+		for column := 0; column < columns; column++ {
+			seconds := column * 1800
+			for _, schedule := range []ProfileSchedule{series.Schedule, series.Upper} {
+				if len(schedule) == 0 {
+					continue
+				}
+				row := int(math.Round((schedule.ValueAt(seconds) - low) / (high - low) * float64(height-1)))
+				plot(row, column, glyph)
+			}
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s (%s)\n", c.Title, c.Units)
+	for i, line := range grid {
+		label := ""
+		switch i {
+		case 0:
+			label = formatChartValue(high)
+		case height - 1:
+			label = formatChartValue(low)
+		}
+		fmt.Fprintf(&out, "%7s |%s\n", label, strings.TrimRight(string(line), " "))
+	}
+	fmt.Fprintf(&out, "%7s +%s\n", "", strings.Repeat("-", columns))
+	fmt.Fprintf(&out, "%7s  %-12s%-12s%-12s%-12s24\n", "", "00", "06", "12", "18")
+	return out.String()
+}
+
+// ProfileChartsSvg renders the charts stacked in a standalone SVG document, with a title and a legend line per series
+func ProfileChartsSvg(title string, charts []ProfileChart, legend []string) []byte {
+	const (
+		width       = 800
+		left        = 70
+		right       = 20
+		plotWidth   = width - left - right
+		plotHeight  = 140
+		chartHeight = plotHeight + 60
+	)
+	header := 50 + 20*len(legend)
+	height := header + chartHeight*len(charts)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">
+<rect width="100%%" height="100%%" fill="white"/>
+<text x="%d" y="28" font-size="18" font-weight="bold">%s</text>
+`, width, height, width, height, left, html.EscapeString(title))
+
+	for i, line := range legend {
+		y := 52 + 20*i
+		color := ChartColors[i%len(ChartColors)]
+		fmt.Fprintf(&out, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="3"/>
+<text x="%d" y="%d">%s</text>
+`, left, y-4, left+24, y-4, color, left+32, y, html.EscapeString(line))
+	}
+
+	for i := range charts {
+		chart := &charts[i]
+		top := header + chartHeight*i + 30
+		low, high := chart.valueRange()
+		x := func(seconds int) float64 {
+			return left + float64(seconds)/86400*plotWidth
+		}
+		y := func(value float64) float64 {
+			return float64(top) + (high-value)/(high-low)*plotHeight
+		}
+
+		fmt.Fprintf(&out, `<text x="%d" y="%d" font-weight="bold">%s (%s)</text>
+`, left, top-10, html.EscapeString(chart.Title), html.EscapeString(chart.Units))
+		for hour := 0; hour <= 24; hour += 3 {
+			fmt.Fprintf(&out, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#ddd"/>
+<text x="%.1f" y="%d" text-anchor="middle" fill="#555">%02d:00</text>
+`, x(hour*3600), top, x(hour*3600), top+plotHeight, x(hour*3600), top+plotHeight+16, hour)
+		}
+		for step := 0; step <= 4; step++ {
+			value := low + (high-low)*float64(step)/4
+			fmt.Fprintf(&out, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>
+<text x="%d" y="%.1f" text-anchor="end" fill="#555">%s</text>
+`, left, y(value), left+plotWidth, y(value), left-6, y(value)+4, formatChartValue(value))
+		}
+		fmt.Fprintf(&out, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#999"/>
+`, left, top, plotWidth, plotHeight)
+
+		for j, series := range chart.Series {
+			color := ChartColors[j%len(ChartColors)]
+			if len(series.Upper) > 0 {
+				fmt.Fprintf(&out, `<path d="%s" fill="%s" fill-opacity="0.15" stroke="none"/>
+`, svgBandPath(series.Schedule, series.Upper, x, y), color)
+				fmt.Fprintf(&out, `<path d="%s" fill="none" stroke="%s" stroke-width="2"/>
+`, svgStepPath(series.Upper, x, y), color)
+			}
+			fmt.Fprintf(&out, `<path d="%s" fill="none" stroke="%s" stroke-width="2"/>
+`, svgStepPath(series.Schedule, x, y), color)
+		}
+	}
+
+	out.WriteString("</svg>\n")
+	return []byte(out.String())
+}
+
+// svgStepPoints returns the corners of a schedule drawn as a step line from 00:00 to 24:00
+func svgStepPoints(schedule ProfileSchedule, x func(int) float64, y func(float64) float64) []string {
+	times := append(ScheduleTimes(schedule), 86400)
+	if times[0] != 0 {
+		times = append([]int{0}, times...)
+	}
+	var points []string
+	for i := 0; i+1 < len(times); i++ {
+		value := y(schedule.ValueAt(times[i]))
+		points = append(points,
+			fmt.Sprintf("%.1f,%.1f", x(times[i]), value),
+			fmt.Sprintf("%.1f,%.1f", x(times[i+1]), value))
+	}
+	return points
+}
+
+func svgStepPath(schedule ProfileSchedule, x func(int) float64, y func(float64) float64) string {
+	return "M" + strings.Join(svgStepPoints(schedule, x, y), " L")
+}
+
+// svgBandPath returns a closed path of the area between a lower and an upper schedule
+func svgBandPath(lower ProfileSchedule, upper ProfileSchedule, x func(int) float64, y func(float64) float64) string {
+	points := svgStepPoints(upper, x, y)
+	lowerPoints := svgStepPoints(lower, x, y)
+	for i := len(lowerPoints) - 1; i >= 0; i-- {
+		points = append(points, lowerPoints[i])
+	}
+	return "M" + strings.Join(points, " L") + " Z"
+}
+
+func formatChartValue(value float64) string {
+	return strconv.FormatFloat(roundTo(value, 2), 'f', -1, 64)
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata")
+
+// checkGolden compares the output with a file in testdata, or writes it with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run the tests with -update after checking it:\n%s", path, got)
+	}
+}
+
+func testChartProfiles() []LocalProfile {
+	profile := func(name string) LocalProfile {
+		return LocalProfile{Name: name, Mgdl: true, Dia: 5, IC: testSchedule(0, 10), ISF: testSchedule(0, 50),
+			Basal: testSchedule(0, 1, 6, 1.2), TargetLow: testSchedule(0, 100), TargetHigh: testSchedule(0, 110)}
+	}
+	sport := profile("Sport")
+	sport.Basal = testSchedule(0, 0.6, 8, 0.9, 20, 0.5)
+	sport.TargetLow, sport.TargetHigh = testSchedule(0, 120), testSchedule(0, 140)
+
+	mmol := profile("Night")
+	mmol.Mgdl = false
+	mmol.ISF = testSchedule(0, 2.5, 12, 3)
+	mmol.TargetLow, mmol.TargetHigh = testSchedule(0, 5, 22, 6), testSchedule(0, 6, 22, 7)
+
+	return []LocalProfile{profile("Default"), sport, mmol}
+}
+
+func TestProfileChartASCII(t *testing.T) {
+	var out bytes.Buffer
+	for _, chart := range ProfileCharts(testChartProfiles(), []string{"Default", "Sport", "Night"}) {
+		out.WriteString(chart.ASCII(6))
+		out.WriteString("\n")
+	}
+	checkGolden(t, "profile_chart.txt", out.Bytes())
+}
+
+func TestProfileChartsSvg(t *testing.T) {
+	charts := ProfileCharts(testChartProfiles(), []string{"Default", "Sport", "Night"})
+	checkGolden(t, "profile_chart.svg", ProfileChartsSvg("Profiles <Default, Sport & Night>", charts, []string{"Default", "Sport", "Night"}))
+}
@@ -0,0 +1,500 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LocalProfilePrefix is the prefix of the preferences of the local profile plugin
+	LocalProfilePrefix = "LocalProfile_"
+	// LocalProfileCountKey is the preference with the number of local profiles
+	LocalProfileCountKey = "LocalProfile_profiles"
+
+	UnitsMgdl = "mg/dl"
+	UnitsMmol = "mmol"
+)
+
+// ProfileBlock is a value of a profile schedule, which applies from its time until the time of the next block
+type ProfileBlock struct {
+	Time          string  `json:"time"`
+	TimeAsSeconds int     `json:"timeAsSeconds"`
+	Value         float64 `json:"value"`
+}
+
+// UnmarshalJSON accepts the numbers of a block as JSON numbers or strings, since Nightscout stores both, and
+// calculates the seconds from the time if they're missing
+func (b *ProfileBlock) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Time          string      `json:"time"`
+		TimeAsSeconds json.Number `json:"timeAsSeconds"`
+		Value         json.Number `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	value, err := raw.Value.Float64()
+	if err != nil {
+		return fmt.Errorf("invalid value \"%s\" at %s", raw.Value, raw.Time)
+	}
+
+	seconds := 0
+	if raw.TimeAsSeconds != "" {
+		s, err := raw.TimeAsSeconds.Int64()
+		if err != nil {
+			return fmt.Errorf("invalid timeAsSeconds \"%s\" at %s", raw.TimeAsSeconds, raw.Time)
+		}
+		seconds = int(s)
+	} else if seconds, err = ParseTimeOfDay(raw.Time); err != nil {
+		return err
+	}
+
+	*b = ProfileBlock{Time: FormatTimeOfDay(seconds), TimeAsSeconds: seconds, Value: value}
+	return nil
+}
+
+// ProfileSchedule is a list of blocks sorted by time, covering a whole day
+type ProfileSchedule []ProfileBlock
+
+// ValueAt returns the value of the schedule at the given second of the day
+func (s ProfileSchedule) ValueAt(seconds int) float64 {
+	value := 0.0
+	for _, block := range s {
+		if block.TimeAsSeconds > seconds {
+			break
+		}
+		value = block.Value
+	}
+	return value
+}
+
+// Total integrates the schedule over a day, such as the total daily basal in units
+func (s ProfileSchedule) Total() float64 {
+	total := 0.0
+	for i, block := range s {
+		end := 24 * 3600
+		if i+1 < len(s) {
+			end = s[i+1].TimeAsSeconds
+		}
+		total += block.Value * float64(end-block.TimeAsSeconds) / 3600
+	}
+	return total
+}
+
+// LocalProfile is a profile of the local profile plugin, stored in the LocalProfile_<index>_* preferences
+type LocalProfile struct {
+	Name string
+	// Mgdl is set when the glucose values of the profile are in mg/dl, otherwise they're in mmol/l
+	Mgdl       bool
+	Dia        float64
+	IC         ProfileSchedule
+	ISF        ProfileSchedule
+	Basal      ProfileSchedule
+	TargetLow  ProfileSchedule
+	TargetHigh ProfileSchedule
+}
+
+// Units returns the glucose units of the profile, UnitsMgdl or UnitsMmol
+func (p *LocalProfile) Units() string {
+	if p.Mgdl {
+		return UnitsMgdl
+	}
+	return UnitsMmol
+}
+
+// LocalProfiles reads all local profiles from the preferences, in the order of their index
+func LocalProfiles(prefs []byte) ([]LocalProfile, error) {
+	count := 0
+	if value, ok := GetPreference(prefs, LocalProfileCountKey); ok {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("preference \"%s\": \"%s\" is not a number", LocalProfileCountKey, value)
+		}
+	}
+
+	profiles := make([]LocalProfile, 0, count)
+	for i := 0; i < count; i++ {
+		profile, err := readLocalProfile(prefs, i)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *profile)
+	}
+	return profiles, nil
+}
+
+// FindLocalProfile returns the profile with the given name, or nil if there is none
+func FindLocalProfile(profiles []LocalProfile, name string) *LocalProfile {
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// LocalProfileNames returns the names of the profiles
+func LocalProfileNames(profiles []LocalProfile) []string {
+	names := make([]string, len(profiles))
+	for i, profile := range profiles {
+		names[i] = profile.Name
+	}
+	return names
+}
+
+func readLocalProfile(prefs []byte, index int) (*LocalProfile, error) {
+	prefix := fmt.Sprintf("%s%d_", LocalProfilePrefix, index)
+	get := func(name string) (string, error) {
+		value, ok := GetPreference(prefs, prefix+name)
+		if !ok {
+			return "", fmt.Errorf("preference \"%s%s\" is missing", prefix, name)
+		}
+		return value, nil
+	}
+
+	profile := &LocalProfile{}
+	var err error
+	if profile.Name, err = get("name"); err != nil {
+		return nil, err
+	}
+
+	mgdl, err := get("mgdl")
+	if err != nil {
+		return nil, err
+	}
+	profile.Mgdl = mgdl == "true"
+
+	dia, err := get("dia")
+	if err != nil {
+		return nil, err
+	}
+	if profile.Dia, err = strconv.ParseFloat(dia, 64); err != nil {
+		return nil, fmt.Errorf("preference \"%sdia\": \"%s\" is not a number", prefix, dia)
+	}
+
+	for name, schedule := range map[string]*ProfileSchedule{
+		"ic":         &profile.IC,
+		"isf":        &profile.ISF,
+		"basal":      &profile.Basal,
+		"targetlow":  &profile.TargetLow,
+		"targethigh": &profile.TargetHigh,
+	} {
+		value, err := get(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(value), schedule); err != nil {
+			return nil, fmt.Errorf("preference \"%s%s\": %w", prefix, name, err)
+		}
+		*schedule = sortedSchedule(*schedule)
+	}
+
+	return profile, nil
+}
+
+// NightscoutProfile is a Nightscout profile document, which contains one or more named profiles in its store
+type NightscoutProfile struct {
+	DefaultProfile string                            `json:"defaultProfile"`
+	Store          map[string]NightscoutProfileStore `json:"store"`
+	StartDate      string                            `json:"startDate,omitempty"`
+	Units          string                            `json:"units,omitempty"`
+}
+
+// NightscoutProfileStore is a single profile of a NightscoutProfile
+type NightscoutProfileStore struct {
+	Dia        json.Number     `json:"dia"`
+	CarbRatio  ProfileSchedule `json:"carbratio"`
+	Sens       ProfileSchedule `json:"sens"`
+	Basal      ProfileSchedule `json:"basal"`
+	TargetLow  ProfileSchedule `json:"target_low"`
+	TargetHigh ProfileSchedule `json:"target_high"`
+	Units      string          `json:"units"`
+	Timezone   string          `json:"timezone,omitempty"`
+}
+
+// ToNightscout converts local profiles to a Nightscout profile document, with the first profile as the default
+func ToNightscout(profiles []LocalProfile, timezone string) *NightscoutProfile {
+	ns := &NightscoutProfile{Store: map[string]NightscoutProfileStore{}}
+	for i, profile := range profiles {
+		if i == 0 {
+			ns.DefaultProfile = profile.Name
+			ns.Units = profile.Units()
+		}
+		ns.Store[profile.Name] = NightscoutProfileStore{
+			Dia:        json.Number(formatNumber(profile.Dia)),
+			CarbRatio:  profile.IC,
+			Sens:       profile.ISF,
+			Basal:      profile.Basal,
+			TargetLow:  profile.TargetLow,
+			TargetHigh: profile.TargetHigh,
+			Units:      profile.Units(),
+			Timezone:   timezone,
+		}
+	}
+	return ns
+}
+
+// FormatTimeOfDay formats seconds since midnight as HH:MM
+func FormatTimeOfDay(seconds int) string {
+	return fmt.Sprintf("%02d:%02d", seconds/3600, seconds%3600/60)
+}
+
+// ParseTimeOfDay parses a HH:MM time of day into seconds since midnight
+func ParseTimeOfDay(time string) (int, error) {
+	hours, minutes, found := strings.Cut(time, ":")
+	h, hErr := strconv.Atoi(hours)
+	m, mErr := strconv.Atoi(minutes)
+	if !found || hErr != nil || mErr != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time \"%s\", expected HH:MM", time)
+	}
+	return h*3600 + m*60, nil
+}
+
+// profile values outside of these ranges are rejected by Validate. They're sanity checks to catch unit mix-ups and
+// typos, not medical limits.
+var (
+	basalRange      = [2]float64{0, HardLimitMaxBasal[len(HardLimitMaxBasal)-1]}
+	icRange         = [2]float64{1, 150}
+	isfRangeMgdl    = [2]float64{2, 1000}
+	isfRangeMmol    = [2]float64{0.1, 55}
+	targetRangeMgdl = [2]float64{72, 270}
+	targetRangeMmol = [2]float64{4, 15}
+)
+
+// Validate checks the name, DIA and schedules of the profile: every schedule must start at 00:00 with strictly
+// increasing times, values must be within sane ranges for the units, and the low target must not be above the high
+// target
+func (p *LocalProfile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("profile has no name")
+	}
+	fail := func(format string, a ...interface{}) error {
+		return fmt.Errorf("profile \"%s\": %s", p.Name, fmt.Sprintf(format, a...))
+	}
+
+	if pref := LookupPreference(LocalProfilePrefix+"0_dia", ""); pref != nil {
+		if err := pref.Validate(formatNumber(p.Dia)); err != nil {
+			return fail("DIA %s", err)
+		}
+	}
+
+	isfRange, targetRange := isfRangeMgdl, targetRangeMgdl
+	if !p.Mgdl {
+		isfRange, targetRange = isfRangeMmol, targetRangeMmol
+	}
+
+	for _, s := range []struct {
+		name     string
+		schedule ProfileSchedule
+		bounds   [2]float64
+	}{
+		{"basal", p.Basal, basalRange},
+		{"IC", p.IC, icRange},
+		{"ISF", p.ISF, isfRange},
+		{"low target", p.TargetLow, targetRange},
+		{"high target", p.TargetHigh, targetRange},
+	} {
+		if len(s.schedule) == 0 {
+			return fail("%s schedule is empty", s.name)
+		}
+		for i, block := range s.schedule {
+			if i == 0 && block.TimeAsSeconds != 0 {
+				return fail("%s schedule must start at 00:00, not %s", s.name, block.Time)
+			}
+			if i > 0 && block.TimeAsSeconds <= s.schedule[i-1].TimeAsSeconds {
+				return fail("%s schedule times must be increasing, %s follows %s", s.name, block.Time, s.schedule[i-1].Time)
+			}
+			if block.TimeAsSeconds < 0 || block.TimeAsSeconds >= 24*3600 || block.TimeAsSeconds%60 != 0 {
+				return fail("%s schedule has an invalid time of %d seconds", s.name, block.TimeAsSeconds)
+			}
+			if block.Value < s.bounds[0] || block.Value > s.bounds[1] {
+				return fail("%s of %s at %s is outside of %s to %s %s", s.name, formatNumber(block.Value), block.Time,
+					formatNumber(s.bounds[0]), formatNumber(s.bounds[1]), p.Units())
+			}
+		}
+	}
+
+	for _, block := range append(append(ProfileSchedule{}, p.TargetLow...), p.TargetHigh...) {
+		low, high := p.TargetLow.ValueAt(block.TimeAsSeconds), p.TargetHigh.ValueAt(block.TimeAsSeconds)
+		if low > high {
+			return fail("low target %s is above high target %s at %s", formatNumber(low), formatNumber(high), block.Time)
+		}
+	}
+
+	return nil
+}
+
+// SetLocalProfiles replaces all local profiles in the preferences
+func SetLocalProfiles(prefs []byte, profiles []LocalProfile) []byte {
+	for _, key := range PreferenceKeys(prefs) {
+		if isIndexedLocalProfileKey(key) {
+			prefs = DeletePreference(prefs, key)
+		}
+	}
+
+	prefs = SetPreference(prefs, LocalProfileCountKey, strconv.Itoa(len(profiles)))
+	for i, profile := range profiles {
+		prefix := fmt.Sprintf("%s%d_", LocalProfilePrefix, i)
+		prefs = SetPreference(prefs, prefix+"name", profile.Name)
+		prefs = SetPreference(prefs, prefix+"mgdl", strconv.FormatBool(profile.Mgdl))
+		prefs = SetPreference(prefs, prefix+"dia", formatNumber(profile.Dia))
+		prefs = SetPreference(prefs, prefix+"ic", profile.IC.String())
+		prefs = SetPreference(prefs, prefix+"isf", profile.ISF.String())
+		prefs = SetPreference(prefs, prefix+"basal", profile.Basal.String())
+		prefs = SetPreference(prefs, prefix+"targetlow", profile.TargetLow.String())
+		prefs = SetPreference(prefs, prefix+"targethigh", profile.TargetHigh.String())
+	}
+	return prefs
+}
+
+// ImportLocalProfiles validates the imported profiles and merges them into the local profiles. A profile with the
+// name of an existing profile replaces it, other profiles are added after the existing ones. A non-empty active name
+// moves that profile to the first position, which AAPS selects in the local profile plugin.
+func ImportLocalProfiles(profiles []LocalProfile, imported []LocalProfile, active string) ([]LocalProfile, error) {
+	for i := range imported {
+		if err := imported[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := append([]LocalProfile{}, profiles...)
+	for _, profile := range imported {
+		if existing := FindLocalProfile(merged, profile.Name); existing != nil {
+			*existing = profile
+		} else {
+			merged = append(merged, profile)
+		}
+	}
+
+	if active != "" {
+		index := -1
+		for i := range merged {
+			if merged[i].Name == active {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("profile \"%s\" does not exist, the profiles are: %s", active, strings.Join(LocalProfileNames(merged), ", "))
+		}
+		first := merged[index]
+		merged = append(append([]LocalProfile{first}, merged[:index]...), merged[index+1:]...)
+	}
+	return merged, nil
+}
+
+// String serializes the schedule as compact JSON, the way the local profile plugin stores it
+func (s ProfileSchedule) String() string {
+	blocks := s
+	if blocks == nil {
+		blocks = ProfileSchedule{}
+	}
+	out, _ := marshalJson([]ProfileBlock(blocks))
+	return string(out)
+}
+
+// isIndexedLocalProfileKey checks whether the key is a preference of a single local profile, LocalProfile_<index>_*
+func isIndexedLocalProfileKey(key string) bool {
+	rest := strings.TrimPrefix(key, LocalProfilePrefix)
+	index, _, found := strings.Cut(rest, "_")
+	if rest == key || !found || index == "" {
+		return false
+	}
+	_, err := strconv.Atoi(index)
+	return err == nil
+}
+
+// ParseNightscoutProfiles parses a Nightscout profile document, or an array of them as returned by the Nightscout API
+func ParseNightscoutProfiles(data []byte) ([]NightscoutProfile, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var profiles []NightscoutProfile
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return nil, err
+		}
+		return profiles, nil
+	}
+
+	var profile NightscoutProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, err
+	}
+	return []NightscoutProfile{profile}, nil
+}
+
+// FromNightscout converts the stores of Nightscout profile documents to local profiles. The default profile of each
+// document comes first, followed by its other profiles sorted by name. A name in multiple documents is taken from the
+// first document containing it.
+func FromNightscout(documents []NightscoutProfile) ([]LocalProfile, error) {
+	var profiles []LocalProfile
+	for _, document := range documents {
+		names := make([]string, 0, len(document.Store))
+		for name := range document.Store {
+			if name != document.DefaultProfile {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if _, ok := document.Store[document.DefaultProfile]; ok {
+			names = append([]string{document.DefaultProfile}, names...)
+		}
+
+		for _, name := range names {
+			if FindLocalProfile(profiles, name) != nil {
+				continue
+			}
+			store := document.Store[name]
+
+			units := store.Units
+			if units == "" {
+				units = document.Units
+			}
+			mgdl, err := parseUnits(units)
+			if err != nil {
+				return nil, fmt.Errorf("profile \"%s\": %w", name, err)
+			}
+
+			dia, err := store.Dia.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("profile \"%s\": invalid DIA \"%s\"", name, store.Dia)
+			}
+
+			profile := LocalProfile{
+				Name:       name,
+				Mgdl:       mgdl,
+				Dia:        dia,
+				IC:         sortedSchedule(store.CarbRatio),
+				ISF:        sortedSchedule(store.Sens),
+				Basal:      sortedSchedule(store.Basal),
+				TargetLow:  sortedSchedule(store.TargetLow),
+				TargetHigh: sortedSchedule(store.TargetHigh),
+			}
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+func parseUnits(units string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(units)) {
+	case "mg/dl", "mgdl":
+		return true, nil
+	case "mmol", "mmol/l":
+		return false, nil
+	case "":
+		return false, fmt.Errorf("units are missing")
+	default:
+		return false, fmt.Errorf("unknown units \"%s\"", units)
+	}
+}
+
+func sortedSchedule(schedule ProfileSchedule) ProfileSchedule {
+	sorted := append(ProfileSchedule{}, schedule...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TimeAsSeconds < sorted[j].TimeAsSeconds
+	})
+	return sorted
+}
@@ -0,0 +1,314 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSchedule creates a schedule from pairs of an hour of the day and a value
+func testSchedule(pairs ...float64) ProfileSchedule {
+	var schedule ProfileSchedule
+	for i := 0; i+1 < len(pairs); i += 2 {
+		seconds := int(pairs[i] * 3600)
+		schedule = append(schedule, ProfileBlock{Time: FormatTimeOfDay(seconds), TimeAsSeconds: seconds, Value: pairs[i+1]})
+	}
+	return schedule
+}
+
+// testLocalProfilePrefs stores a profile in the preferences like the local profile plugin does
+func testLocalProfilePrefs(prefs []byte, index int, name string, basal string) []byte {
+	prefix := LocalProfilePrefix + string(rune('0'+index)) + "_"
+	prefs = SetPreference(prefs, prefix+"name", name)
+	prefs = SetPreference(prefs, prefix+"mgdl", "true")
+	prefs = SetPreference(prefs, prefix+"dia", "5.0")
+	prefs = SetPreference(prefs, prefix+"ic", `[{"time":"00:00","timeAsSeconds":"0","value":"10"}]`)
+	prefs = SetPreference(prefs, prefix+"isf", `[{"time":"00:00","timeAsSeconds":0,"value":50.0},{"time":"12:00","timeAsSeconds":43200,"value":60.0}]`)
+	prefs = SetPreference(prefs, prefix+"basal", basal)
+	prefs = SetPreference(prefs, prefix+"targetlow", `[{"time":"00:00","timeAsSeconds":0,"value":100}]`)
+	prefs = SetPreference(prefs, prefix+"targethigh", `[{"time":"00:00","timeAsSeconds":0,"value":110}]`)
+	return prefs
+}
+
+func TestLocalProfiles(t *testing.T) {
+	prefs := SetPreference([]byte(`{}`), LocalProfileCountKey, "2")
+	prefs = testLocalProfilePrefs(prefs, 0, "Default", `[{"time":"00:00","timeAsSeconds":0,"value":1.0},{"time":"06:00","value":1.2}]`)
+	prefs = testLocalProfilePrefs(prefs, 1, "Sport", `[{"time":"00:00","timeAsSeconds":0,"value":0.5}]`)
+
+	profiles, err := LocalProfiles(prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(LocalProfileNames(profiles), []string{"Default", "Sport"}) {
+		t.Fatalf("got profiles %v", LocalProfileNames(profiles))
+	}
+
+	profile := FindLocalProfile(profiles, "Default")
+	want := LocalProfile{
+		Name:       "Default",
+		Mgdl:       true,
+		Dia:        5,
+		IC:         testSchedule(0, 10),
+		ISF:        testSchedule(0, 50, 12, 60),
+		Basal:      testSchedule(0, 1.0, 6, 1.2),
+		TargetLow:  testSchedule(0, 100),
+		TargetHigh: testSchedule(0, 110),
+	}
+	if !reflect.DeepEqual(*profile, want) {
+		t.Errorf("got %+v, want %+v", *profile, want)
+	}
+	if FindLocalProfile(profiles, "missing") != nil {
+		t.Errorf("found a missing profile")
+	}
+}
+
+func TestLocalProfilesErrors(t *testing.T) {
+	valid := testLocalProfilePrefs(SetPreference([]byte(`{}`), LocalProfileCountKey, "1"), 0, "Default", `[{"time":"00:00","value":1}]`)
+
+	tests := map[string][]byte{
+		"count not a number": SetPreference(valid, LocalProfileCountKey, "one"),
+		"missing profile":    SetPreference(valid, LocalProfileCountKey, "2"),
+		"missing schedule":   DeletePreference(valid, "LocalProfile_0_isf"),
+		"invalid schedule":   SetPreference(valid, "LocalProfile_0_basal", `[{"time":"00:00","value":"fast"}]`),
+		"invalid time":       SetPreference(valid, "LocalProfile_0_basal", `[{"time":"25:00","value":1}]`),
+		"invalid DIA":        SetPreference(valid, "LocalProfile_0_dia", "long"),
+	}
+
+	for name, prefs := range tests {
+		if _, err := LocalProfiles(prefs); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	if profiles, err := LocalProfiles([]byte(`{}`)); err != nil || len(profiles) != 0 {
+		t.Errorf("without local profiles: got %v, %v", profiles, err)
+	}
+}
+
+func TestProfileSchedule(t *testing.T) {
+	schedule := testSchedule(0, 1.0, 6, 1.5, 22, 0.5)
+
+	tests := []struct {
+		hour float64
+		want float64
+	}{
+		{0, 1.0},
+		{5.99, 1.0},
+		{6, 1.5},
+		{21.5, 1.5},
+		{23.99, 0.5},
+	}
+	for _, test := range tests {
+		if got := schedule.ValueAt(int(test.hour * 3600)); got != test.want {
+			t.Errorf("ValueAt(%v) = %v, want %v", test.hour, got, test.want)
+		}
+	}
+
+	if got := schedule.Total(); got != 6*1.0+16*1.5+2*0.5 {
+		t.Errorf("Total() = %v", got)
+	}
+}
+
+func TestToNightscout(t *testing.T) {
+	profiles := []LocalProfile{
+		{Name: "Default", Mgdl: false, Dia: 6, IC: testSchedule(0, 8), ISF: testSchedule(0, 2.5), Basal: testSchedule(0, 0.8, 7, 1),
+			TargetLow: testSchedule(0, 5.5), TargetHigh: testSchedule(0, 6)},
+		{Name: "Sport", Mgdl: false, Dia: 6, IC: testSchedule(0, 12), ISF: testSchedule(0, 3), Basal: testSchedule(0, 0.4),
+			TargetLow: testSchedule(0, 7), TargetHigh: testSchedule(0, 8)},
+	}
+
+	ns := ToNightscout(profiles, "Europe/Prague")
+	if ns.DefaultProfile != "Default" || ns.Units != UnitsMmol || len(ns.Store) != 2 {
+		t.Fatalf("unexpected document %+v", ns)
+	}
+
+	// the document is read again like Nightscout documents are
+	data, err := json.Marshal(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read NightscoutProfile
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	store := read.Store["Default"]
+	if store.Dia != "6" || store.Units != UnitsMmol || store.Timezone != "Europe/Prague" {
+		t.Errorf("unexpected store %+v", store)
+	}
+	if !reflect.DeepEqual(store.Basal, profiles[0].Basal) || !reflect.DeepEqual(store.TargetHigh, profiles[0].TargetHigh) {
+		t.Errorf("got basal %v and high target %v", store.Basal, store.TargetHigh)
+	}
+}
+
+func TestSetLocalProfiles(t *testing.T) {
+	prefs := SetPreference([]byte(`{"units":"mg/dl"}`), LocalProfileCountKey, "3")
+	for i, name := range []string{"Default", "Sport", "Sick"} {
+		prefs = testLocalProfilePrefs(prefs, i, name, `[{"time":"00:00","value":1}]`)
+	}
+	profiles, err := LocalProfiles(prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles[0].Basal = testSchedule(0, 0.9, 12.5, 1.1)
+	prefs = SetLocalProfiles(prefs, profiles[:2])
+
+	if value, ok := GetPreference(prefs, LocalProfileCountKey); !ok || value != "2" {
+		t.Errorf("%s = %q", LocalProfileCountKey, value)
+	}
+	for _, key := range PreferenceKeys(prefs) {
+		if strings.HasPrefix(key, LocalProfilePrefix+"2_") {
+			t.Errorf("the preference %s of a removed profile was kept", key)
+		}
+	}
+	if value, _ := GetPreference(prefs, "units"); value != "mg/dl" {
+		t.Errorf("other preferences were changed")
+	}
+	if value, _ := GetPreference(prefs, "LocalProfile_0_basal"); value != `[{"time":"00:00","timeAsSeconds":0,"value":0.9},{"time":"12:30","timeAsSeconds":45000,"value":1.1}]` {
+		t.Errorf("got basal %s", value)
+	}
+
+	saved, err := LocalProfiles(prefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, profiles[:2]) {
+		t.Errorf("got %+v, want %+v", saved, profiles[:2])
+	}
+}
+
+func TestFromNightscout(t *testing.T) {
+	document := `{"defaultProfile":"Work","units":"mmol","store":{
+		"Work":{"dia":"5","carbratio":[{"time":"00:00","value":10}],"sens":[{"time":"00:00","value":3}],
+			"basal":[{"time":"06:00","timeAsSeconds":21600,"value":1.2},{"time":"00:00","timeAsSeconds":0,"value":0.8}],
+			"target_low":[{"time":"00:00","value":5}],"target_high":[{"time":"00:00","value":6}]},
+		"Home":{"dia":6,"units":"mg/dl","carbratio":[{"time":"00:00","value":"12"}],"sens":[{"time":"00:00","value":"50"}],
+			"basal":[{"time":"00:00","value":"0.7"}],"target_low":[{"time":"00:00","value":100}],"target_high":[{"time":"00:00","value":110}]}}}`
+
+	for _, data := range []string{document, "[" + document + "," + document + "]"} {
+		documents, err := ParseNightscoutProfiles([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		profiles, err := FromNightscout(documents)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the default profile comes first, and a name in multiple documents is imported once
+		if !reflect.DeepEqual(LocalProfileNames(profiles), []string{"Work", "Home"}) {
+			t.Fatalf("got profiles %v", LocalProfileNames(profiles))
+		}
+		work, home := profiles[0], profiles[1]
+		if work.Mgdl || work.Dia != 5 || !reflect.DeepEqual(work.Basal, testSchedule(0, 0.8, 6, 1.2)) {
+			t.Errorf("unexpected profile %+v", work)
+		}
+		if !home.Mgdl || home.Dia != 6 || !reflect.DeepEqual(home.ISF, testSchedule(0, 50)) {
+			t.Errorf("unexpected profile %+v", home)
+		}
+		for i := range profiles {
+			if err := profiles[i].Validate(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	tests := map[string]string{
+		"missing units": `{"defaultProfile":"A","store":{"A":{"dia":5}}}`,
+		"unknown units": `{"defaultProfile":"A","units":"mg","store":{"A":{"dia":5}}}`,
+		"missing DIA":   `{"defaultProfile":"A","units":"mmol","store":{"A":{}}}`,
+	}
+	for name, data := range tests {
+		documents, err := ParseNightscoutProfiles([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := FromNightscout(documents); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func testLocalProfile(name string) LocalProfile {
+	return LocalProfile{Name: name, Mgdl: true, Dia: 5, IC: testSchedule(0, 10), ISF: testSchedule(0, 50),
+		Basal: testSchedule(0, 1, 6, 1.2), TargetLow: testSchedule(0, 100), TargetHigh: testSchedule(0, 110)}
+}
+
+func TestLocalProfileValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(p *LocalProfile)
+		valid bool
+	}{
+		{"valid", func(p *LocalProfile) {}, true},
+		{"valid mmol", func(p *LocalProfile) {
+			p.Mgdl, p.ISF, p.TargetLow, p.TargetHigh = false, testSchedule(0, 3), testSchedule(0, 5.5), testSchedule(0, 6)
+		}, true},
+		{"no name", func(p *LocalProfile) { p.Name = " " }, false},
+		{"empty schedule", func(p *LocalProfile) { p.IC = nil }, false},
+		{"not starting at midnight", func(p *LocalProfile) { p.Basal = testSchedule(1, 1) }, false},
+		{"unsorted times", func(p *LocalProfile) { p.Basal = testSchedule(0, 1, 8, 1, 6, 1) }, false},
+		{"time between minutes", func(p *LocalProfile) { p.Basal = testSchedule(0, 1, 6.001, 1) }, false},
+		{"negative basal", func(p *LocalProfile) { p.Basal = testSchedule(0, -0.1) }, false},
+		{"mmol ISF in mg/dl", func(p *LocalProfile) { p.ISF = testSchedule(0, 1.5) }, false},
+		{"mg/dl target in mmol", func(p *LocalProfile) {
+			p.Mgdl, p.ISF = false, testSchedule(0, 3)
+		}, false},
+		{"low target above high target", func(p *LocalProfile) { p.TargetLow = testSchedule(0, 100, 12, 120) }, false},
+	}
+
+	for _, test := range tests {
+		profile := testLocalProfile("Default")
+		test.edit(&profile)
+		if err := profile.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestImportLocalProfiles(t *testing.T) {
+	existing := []LocalProfile{testLocalProfile("Default"), testLocalProfile("Sport"), testLocalProfile("Sick")}
+	sport := testLocalProfile("Sport")
+	sport.Dia = 7
+
+	tests := []struct {
+		name     string
+		imported []LocalProfile
+		active   string
+		want     []string
+	}{
+		{"replace by name", []LocalProfile{sport}, "", []string{"Default", "Sport", "Sick"}},
+		{"add new profiles last", []LocalProfile{testLocalProfile("Night")}, "", []string{"Default", "Sport", "Sick", "Night"}},
+		{"active existing profile", nil, "Sick", []string{"Sick", "Default", "Sport"}},
+		{"active imported profile", []LocalProfile{testLocalProfile("Night")}, "Night", []string{"Night", "Default", "Sport", "Sick"}},
+		{"active first profile", nil, "Default", []string{"Default", "Sport", "Sick"}},
+	}
+
+	for _, test := range tests {
+		profiles, err := ImportLocalProfiles(existing, test.imported, test.active)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := LocalProfileNames(profiles); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	profiles, err := ImportLocalProfiles(existing, []LocalProfile{sport}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FindLocalProfile(profiles, "Sport").Dia != 7 || existing[1].Dia != 5 {
+		t.Errorf("the imported profile didn't replace only the merged profile")
+	}
+
+	invalid := testLocalProfile("Broken")
+	invalid.TargetLow = testSchedule(0, 150)
+	if _, err := ImportLocalProfiles(existing, []LocalProfile{testLocalProfile("Night"), invalid}, ""); err == nil {
+		t.Errorf("an invalid profile was imported")
+	}
+	if _, err := ImportLocalProfiles(existing, nil, "Missing"); err == nil {
+		t.Errorf("a missing profile was activated")
+	}
+}
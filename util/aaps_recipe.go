@@ -0,0 +1,200 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/tidwall/sjson"
+	"gopkg.in/yaml.v3"
+	"sort"
+)
+
+// Recipe is a declarative list of operations which are applied in order to a single decrypted copy of an export.
+//
+// Example:
+//
+//	steps:
+//	  - set:
+//	      language: en
+//	  - unset: [key_a, key_b]
+//	  - rename:
+//	      old_key: new_key
+//	  - objectives:
+//	      complete: [1, 2, 3]
+//	  - metadata:
+//	      device_name: Clinic phone
+//	  - format: string
+//	  - encryption: encrypted
+type Recipe struct {
+	Steps []RecipeStep `yaml:"steps"`
+}
+
+// RecipeStep is a single operation of a Recipe. Exactly one of its fields must be set.
+type RecipeStep struct {
+	Set   map[string]string `yaml:"set"`
+	Unset []string          `yaml:"unset"`
+	// Rename maps old keys to new keys. The renames of a step happen at once, so a step can swap two preferences,
+	// and renames which depend on each other have to be split into separate steps.
+	Rename     map[string]string `yaml:"rename"`
+	Objectives *RecipeObjectives `yaml:"objectives"`
+	Metadata   map[string]string `yaml:"metadata"`
+	// Format is the storage format of the preferences, either "string" or "object"
+	Format string `yaml:"format"`
+	// Encryption is either "encrypted" or "decrypted"
+	Encryption string `yaml:"encryption"`
+}
+
+// RecipeObjectives lists the objectives to mark as completed or to reset
+type RecipeObjectives struct {
+	Complete []int `yaml:"complete"`
+	Reset    []int `yaml:"reset"`
+}
+
+// ParseRecipe parses and validates a YAML recipe
+func ParseRecipe(data []byte) (*Recipe, error) {
+	recipe := &Recipe{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(recipe); err != nil {
+		return nil, err
+	}
+
+	for i, step := range recipe.Steps {
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+
+	return recipe, nil
+}
+
+func (step *RecipeStep) validate() error {
+	operations := 0
+	for _, set := range []bool{
+		step.Set != nil,
+		step.Unset != nil,
+		step.Rename != nil,
+		step.Objectives != nil,
+		step.Metadata != nil,
+		step.Format != "",
+		step.Encryption != "",
+	} {
+		if set {
+			operations++
+		}
+	}
+	if operations != 1 {
+		return fmt.Errorf("expected exactly one operation, found %d", operations)
+	}
+
+	renamed := map[string]string{}
+	for _, oldKey := range sortedKeys(step.Rename) {
+		newKey := step.Rename[oldKey]
+		if other, ok := renamed[newKey]; ok {
+			return fmt.Errorf("\"%s\" and \"%s\" are both renamed to \"%s\"", other, oldKey, newKey)
+		}
+		renamed[newKey] = oldKey
+	}
+
+	if step.Format != "" && step.Format != "string" && step.Format != "object" {
+		return fmt.Errorf("unknown format \"%s\", expected \"string\" or \"object\"", step.Format)
+	}
+	if step.Encryption != "" && step.Encryption != "encrypted" && step.Encryption != "decrypted" {
+		return fmt.Errorf("unknown encryption \"%s\", expected \"encrypted\" or \"decrypted\"", step.Encryption)
+	}
+	if step.Objectives != nil {
+		for _, num := range append(step.Objectives.Complete, step.Objectives.Reset...) {
+			if num < 1 || num > len(Objectives) {
+				return fmt.Errorf("unknown objective %d", num)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Apply runs the steps of the recipe on the export.
+// The password function is only called when an unencrypted export has to be encrypted.
+func (r *Recipe) Apply(export *Export, password func() (string, error)) error {
+	for i, step := range r.Steps {
+		if err := step.apply(export, password); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (step *RecipeStep) apply(export *Export, password func() (string, error)) error {
+	prefs := export.Preferences()
+
+	for _, key := range sortedKeys(step.Set) {
+		prefs = SetPreference(prefs, key, step.Set[key])
+	}
+	for _, key := range step.Unset {
+		prefs = DeletePreference(prefs, key)
+	}
+	oldKeys := sortedKeys(step.Rename)
+	values := make([]string, len(oldKeys))
+	for i, oldKey := range oldKeys {
+		value, ok := GetPreference(prefs, oldKey)
+		if !ok {
+			return fmt.Errorf("cannot rename \"%s\": preference does not exist", oldKey)
+		}
+		values[i] = value
+	}
+	for _, oldKey := range oldKeys {
+		prefs = DeletePreference(prefs, oldKey)
+	}
+	for i, oldKey := range oldKeys {
+		prefs = SetPreference(prefs, step.Rename[oldKey], values[i])
+	}
+	if step.Objectives != nil {
+		for _, obj := range ObjectiveNumbersToObjects(step.Objectives.Complete) {
+			prefs = obj.Complete(prefs)
+		}
+		for _, obj := range ObjectiveNumbersToObjects(step.Objectives.Reset) {
+			prefs = obj.Reset(prefs)
+		}
+	}
+	export.SetPreferences(prefs)
+
+	for _, key := range sortedKeys(step.Metadata) {
+		export.Data, _ = sjson.SetBytes(export.Data, "metadata."+PreferencePath(key), step.Metadata[key])
+	}
+
+	switch step.Format {
+	case "string":
+		export.PreferencesObject = false
+	case "object":
+		export.PreferencesObject = true
+	}
+
+	switch step.Encryption {
+	case "decrypted":
+		export.Encrypted = false
+		export.Password = ""
+	case "encrypted":
+		if export.Password == "" {
+			pw, err := password()
+			if err != nil {
+				return err
+			}
+			if pw == "" {
+				return errors.New("cannot encrypt with an empty password")
+			}
+			export.Password = pw
+		}
+		export.Encrypted = true
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestRecipeRename(t *testing.T) {
+	tests := []struct {
+		name   string
+		recipe string
+		want   map[string]string
+	}{
+		{
+			name:   "swap",
+			recipe: "steps:\n  - rename: {a: b, b: a}",
+			want:   map[string]string{"a": "2", "b": "1", "c": "3"},
+		},
+		{
+			name:   "renames of a step happen at once",
+			recipe: "steps:\n  - rename: {a: b, b: d}",
+			want:   map[string]string{"b": "1", "c": "3", "d": "2"},
+		},
+		{
+			name:   "chained renames in separate steps",
+			recipe: "steps:\n  - rename: {a: d}\n  - rename: {d: e}",
+			want:   map[string]string{"b": "2", "c": "3", "e": "1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recipe, err := ParseRecipe([]byte(test.recipe))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the result must not depend on the iteration order of the rename map
+			for i := 0; i < 20; i++ {
+				export := &Export{Data: []byte(`{"content":{"a":"1","b":"2","c":"3"}}`)}
+				if err := recipe.Apply(export, nil); err != nil {
+					t.Fatal(err)
+				}
+				prefs := export.Preferences()
+				if keys := PreferenceKeys(prefs); len(keys) != len(test.want) {
+					t.Fatalf("got keys %v, want %v", keys, test.want)
+				}
+				for key, value := range test.want {
+					if got, _ := GetPreference(prefs, key); got != value {
+						t.Fatalf("%s = %q, want %q", key, got, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecipeSetOrder(t *testing.T) {
+	recipe, err := ParseRecipe([]byte("steps:\n  - set: {z: '1', m: '2', a: '3'}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first string
+	for i := 0; i < 20; i++ {
+		export := &Export{Data: []byte(`{"content":{}}`)}
+		if err := recipe.Apply(export, nil); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = string(export.Preferences())
+		} else if got := string(export.Preferences()); got != first {
+			t.Fatalf("got %s, then %s", first, got)
+		}
+	}
+}
+
+func TestParseRecipeErrors(t *testing.T) {
+	tests := map[string]string{
+		"two operations":     "steps:\n  - unset: [a]\n    format: string",
+		"unknown format":     "steps:\n  - format: xml",
+		"unknown encryption": "steps:\n  - encryption: maybe",
+		"unknown objective":  "steps:\n  - objectives: {complete: [99]}",
+		"duplicate target":   "steps:\n  - rename: {a: c, b: c}",
+		"unknown field":      "steps:\n  - copy: {a: b}",
+	}
+
+	for name, data := range tests {
+		if _, err := ParseRecipe([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestRecipeRenameMissing(t *testing.T) {
+	recipe, err := ParseRecipe([]byte("steps:\n  - rename: {a: b, missing: c}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := &Export{Data: []byte(`{"content":{"a":"1"}}`)}
+	if err := recipe.Apply(export, nil); err == nil {
+		t.Errorf("renaming a missing preference succeeded")
+	}
+}
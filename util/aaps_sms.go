@@ -0,0 +1,148 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// preferences of the SMS communicator plugin
+const (
+	SmsAllowedNumbersKey  = "smscommunicator_allowednumbers"
+	SmsRemoteCommandsKey  = "smscommunicator_remotecommandsallowed"
+	SmsBolusDistanceKey   = "smscommunicator_remotebolusmindistance"
+	SmsOtpPinKey          = "smscommunicator_otp_password"
+	SmsOtpSecretKey       = "smscommunicator_otp_secret"
+	smsAllowedNumbersSep  = ";"
+	smsMinOtpPinLength    = 3
+	smsMinNumbersForBolus = 2
+)
+
+// e164Pattern matches phone numbers in E.164 format: a plus, the country code and up to 15 digits in total
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+var digitsPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// NormalizePhoneNumber removes the whitespace of a phone number, like AAPS does before comparing numbers
+func NormalizePhoneNumber(number string) string {
+	return whitespacePattern.ReplaceAllString(number, "")
+}
+
+// ValidatePhoneNumber checks that a phone number is in E.164 format, e.g. +420123456789. AAPS compares the number of
+// an incoming SMS with the allowed numbers, so numbers without the country code never match.
+func ValidatePhoneNumber(number string) error {
+	if !e164Pattern.MatchString(NormalizePhoneNumber(number)) {
+		return fmt.Errorf("\"%s\" is not a phone number in E.164 format, like +420123456789", number)
+	}
+	return nil
+}
+
+// ValidateSmsOtpPin checks that the PIN prepended to one-time passwords is a number of at least 3 digits
+func ValidateSmsOtpPin(pin string) error {
+	if len(pin) < smsMinOtpPinLength || !digitsPattern.MatchString(pin) {
+		return fmt.Errorf("the one-time password PIN must be a number of at least %d digits", smsMinOtpPinLength)
+	}
+	return nil
+}
+
+// SmsAllowedNumbers returns the normalized phone numbers which may send commands, in their stored order
+func SmsAllowedNumbers(prefs []byte) []string {
+	value, _ := GetPreference(prefs, SmsAllowedNumbersKey)
+	var numbers []string
+	for _, number := range strings.Split(value, smsAllowedNumbersSep) {
+		if number = NormalizePhoneNumber(number); number != "" {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers
+}
+
+// SetSmsAllowedNumbers stores the phone numbers which may send commands
+func SetSmsAllowedNumbers(prefs []byte, numbers []string) []byte {
+	return SetPreference(prefs, SmsAllowedNumbersKey, strings.Join(numbers, smsAllowedNumbersSep))
+}
+
+// SmsNumberProblems returns an error for every number which isn't in E.164 format, and for every duplicate number
+func SmsNumberProblems(numbers []string) []error {
+	var errs []error
+	seen := map[string]bool{}
+	for _, number := range numbers {
+		number = NormalizePhoneNumber(number)
+		if err := ValidatePhoneNumber(number); err != nil {
+			errs = append(errs, err)
+		}
+		if seen[number] {
+			errs = append(errs, fmt.Errorf("%s is listed more than once", number))
+		}
+		seen[number] = true
+	}
+	return errs
+}
+
+// SmsCheck is a prerequisite of SMS remote commands, and whether the preferences satisfy it
+type SmsCheck struct {
+	Description string
+	OK          bool
+	// Detail explains why the check failed
+	Detail string
+}
+
+// SmsRemoteBolusChecks checks the prerequisites of remote boluses via SMS which are stored in the preferences:
+// remote commands are allowed, the allowed numbers are valid, and one-time passwords are set up with a PIN and an
+// authenticator. The checks can't tell whether the phone can send SMS, or whether the authenticator is still valid.
+func SmsRemoteBolusChecks(prefs []byte, version string) []SmsCheck {
+	remoteCommands, _ := GetPreference(prefs, SmsRemoteCommandsKey)
+	numbers := SmsAllowedNumbers(prefs)
+	problems := SmsNumberProblems(numbers)
+	pin, _ := GetPreference(prefs, SmsOtpPinKey)
+	secret, _ := GetPreference(prefs, SmsOtpSecretKey)
+
+	checks := []SmsCheck{
+		{
+			Description: "Remote commands are allowed",
+			OK:          remoteCommands == "true",
+			Detail:      SmsRemoteCommandsKey + " is not true",
+		},
+		{
+			Description: "At least one phone number is allowed",
+			OK:          len(numbers) > 0,
+			Detail:      SmsAllowedNumbersKey + " is empty",
+		},
+		{
+			Description: "All allowed phone numbers are valid",
+			OK:          len(problems) == 0,
+		},
+		{
+			Description: fmt.Sprintf("The one-time password PIN has at least %d digits", smsMinOtpPinLength),
+			OK:          ValidateSmsOtpPin(pin) == nil,
+			Detail:      SmsOtpPinKey + " is missing, too short or not a number",
+		},
+		{
+			Description: "An authenticator is set up for one-time passwords",
+			OK:          secret != "",
+			Detail:      SmsOtpSecretKey + " is empty, set up the authenticator in the SMS communicator of AAPS",
+		},
+	}
+	if len(problems) > 0 {
+		checks[2].Detail = problems[0].Error()
+	}
+
+	if pref := LookupPreference(SmsBolusDistanceKey, version); pref != nil {
+		distance, ok := GetPreference(prefs, SmsBolusDistanceKey)
+		if !ok && pref.Default != nil {
+			distance = *pref.Default
+		}
+		err := pref.Validate(distance)
+		check := SmsCheck{Description: "The minimum time between remote boluses is valid", OK: err == nil}
+		if err != nil {
+			check.Detail = err.Error()
+		} else if !pref.IsDefault(distance) && len(numbers) < smsMinNumbersForBolus {
+			check.OK = false
+			check.Detail = fmt.Sprintf("AAPS only allows changing it with at least %d allowed numbers", smsMinNumbersForBolus)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidatePhoneNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		valid  bool
+	}{
+		{"+420123456789", true},
+		{"+44 7700 900123", true},
+		{"+1\t555 0100", true},
+		{"420123456789", false},
+		{"00420123456789", false},
+		{"+0123456789", false},
+		{"+4201234567890123", false},
+		{"+420-123-456", false},
+		{"+", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if err := ValidatePhoneNumber(test.number); (err == nil) != test.valid {
+			t.Errorf("%q: got %v, want valid %v", test.number, err, test.valid)
+		}
+	}
+}
+
+func TestValidateSmsOtpPin(t *testing.T) {
+	tests := []struct {
+		pin   string
+		valid bool
+	}{
+		{"123", true},
+		{"0000", true},
+		{"12", false},
+		{"12a4", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if err := ValidateSmsOtpPin(test.pin); (err == nil) != test.valid {
+			t.Errorf("%q: got %v, want valid %v", test.pin, err, test.valid)
+		}
+	}
+}
+
+func TestSmsAllowedNumbers(t *testing.T) {
+	prefs := SetPreference([]byte(`{}`), SmsAllowedNumbersKey, "+420 123 456 789; +44 7700 900123;;")
+
+	numbers := SmsAllowedNumbers(prefs)
+	want := []string{"+420123456789", "+447700900123"}
+	if !reflect.DeepEqual(numbers, want) {
+		t.Errorf("got %v, want %v", numbers, want)
+	}
+
+	value, _ := GetPreference(SetSmsAllowedNumbers(prefs, want), SmsAllowedNumbersKey)
+	if value != "+420123456789;+447700900123" {
+		t.Errorf("stored %q", value)
+	}
+
+	if numbers := SmsAllowedNumbers([]byte(`{}`)); numbers != nil {
+		t.Errorf("got %v without the preference", numbers)
+	}
+}
+
+func TestSmsNumberProblems(t *testing.T) {
+	tests := []struct {
+		numbers  []string
+		problems int
+	}{
+		{[]string{"+420123456789", "+447700900123"}, 0},
+		{[]string{"+420123456789", "+420 123 456 789"}, 1},
+		{[]string{"123456789", "+447700900123"}, 1},
+		{[]string{"123", "123"}, 3},
+	}
+
+	for _, test := range tests {
+		if problems := SmsNumberProblems(test.numbers); len(problems) != test.problems {
+			t.Errorf("%v: got %v, want %d problems", test.numbers, problems, test.problems)
+		}
+	}
+}
+
+func TestSmsRemoteBolusChecks(t *testing.T) {
+	ready := map[string]string{
+		SmsRemoteCommandsKey: "true",
+		SmsAllowedNumbersKey: "+420123456789",
+		SmsOtpPinKey:         "1234",
+		SmsOtpSecretKey:      "secret",
+		SmsBolusDistanceKey:  "15",
+	}
+
+	tests := []struct {
+		name    string
+		changes map[string]string
+		// failed lists the indexes of the checks which fail
+		failed []int
+	}{
+		{"ready", nil, nil},
+		{"remote commands off", map[string]string{SmsRemoteCommandsKey: "false"}, []int{0}},
+		{"no numbers", map[string]string{SmsAllowedNumbersKey: ""}, []int{1}},
+		{"invalid number", map[string]string{SmsAllowedNumbersKey: "420123456789"}, []int{2}},
+		{"short PIN", map[string]string{SmsOtpPinKey: "12"}, []int{3}},
+		{"no authenticator", map[string]string{SmsOtpSecretKey: ""}, []int{4}},
+		{"distance out of range", map[string]string{SmsBolusDistanceKey: "2"}, []int{5}},
+		{"changed distance with one number", map[string]string{SmsBolusDistanceKey: "20"}, []int{5}},
+		{"changed distance with two numbers", map[string]string{SmsBolusDistanceKey: "20", SmsAllowedNumbersKey: "+420123456789;+447700900123"}, nil},
+	}
+
+	for _, test := range tests {
+		prefs := []byte(`{}`)
+		for key, value := range ready {
+			prefs = SetPreference(prefs, key, value)
+		}
+		for key, value := range test.changes {
+			prefs = SetPreference(prefs, key, value)
+		}
+
+		checks := SmsRemoteBolusChecks(prefs, "3.2.0")
+		if len(checks) != 6 {
+			t.Fatalf("%s: got %d checks, want 6", test.name, len(checks))
+		}
+		var failed []int
+		for i, check := range checks {
+			if !check.OK {
+				failed = append(failed, i)
+				if check.Detail == "" {
+					t.Errorf("%s: check %q failed without a detail", test.name, check.Description)
+				}
+			}
+		}
+		if !reflect.DeepEqual(failed, test.failed) {
+			t.Errorf("%s: got failed checks %v, want %v", test.name, failed, test.failed)
+		}
+	}
+}